@@ -0,0 +1,25 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// AdminMiddleware only lets requests through that carry the ADMIN_API_KEY
+// as "Authorization: ApiKey <key>". Admin endpoints are disabled while the
+// key is not configured.
+func AdminMiddleware(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		adminKey := os.Getenv("ADMIN_API_KEY")
+		scheme, key, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+
+		if adminKey == "" || scheme != "ApiKey" || subtle.ConstantTimeCompare([]byte(key), []byte(adminKey)) != 1 {
+			RespondWithError(w, 401, "invalid api key")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
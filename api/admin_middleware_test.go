@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminMiddleware(t *testing.T) {
+	t.Setenv("ADMIN_API_KEY", "secret")
+	handler := AdminMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"api key", "ApiKey secret", http.StatusNoContent},
+		{"other scheme", "Bearer secret", http.StatusUnauthorized},
+		{"any word", "x secret", http.StatusUnauthorized},
+		{"lowercase scheme", "apikey secret", http.StatusUnauthorized},
+		{"wrong key", "ApiKey guess", http.StatusUnauthorized},
+		{"trailing text", "ApiKey secret extra", http.StatusUnauthorized},
+		{"no scheme", "secret", http.StatusUnauthorized},
+		{"missing", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/admin/moderation/policies", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+
+	t.Run("unconfigured", func(t *testing.T) {
+		t.Setenv("ADMIN_API_KEY", "")
+		r := httptest.NewRequest(http.MethodGet, "/admin/moderation/policies", nil)
+		r.Header.Set("Authorization", "ApiKey ")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+		}
+	})
+}
@@ -3,6 +3,7 @@ package api
 import (
 	"encoding/json"
 	"errors"
+	"net"
 	"net/http"
 
 	"github.com/ortin779/chirpy/db"
@@ -10,10 +11,10 @@ import (
 )
 
 type AuthHandler struct {
-	database *db.DB
+	database db.Store
 }
 
-func NewAuthHandler(db *db.DB) AuthHandler {
+func NewAuthHandler(db db.Store) AuthHandler {
 	return AuthHandler{
 		database: db,
 	}
@@ -33,12 +34,16 @@ func (h *AuthHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := h.database.LoginUser(requestBody)
+	user, err := h.database.LoginUser(requestBody, clientInfo(r))
 	if err != nil {
 		if errors.As(err, &db.AuthenticationError{}) {
 			RespondWithError(w, 401, err.Error())
 			return
 		}
+		if errors.As(err, &db.AuthorizationError{}) {
+			RespondWithError(w, 403, err.Error())
+			return
+		}
 
 		RespondWithError(w, 500, err.Error())
 		return
@@ -55,7 +60,7 @@ func (h *AuthHandler) HandleRefresToken(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	resp, err := h.database.RefreshToken(token)
+	resp, err := h.database.RefreshToken(token, clientInfo(r))
 	if err != nil {
 		if errors.As(err, &db.AuthenticationError{}) {
 			RespondWithError(w, 401, err.Error())
@@ -90,3 +95,16 @@ func (h *AuthHandler) HandleRevokeToken(w http.ResponseWriter, r *http.Request)
 
 	RespondWithJSON(w, http.StatusOK, struct{}{})
 }
+
+// clientInfo describes the device a login or refresh comes from, shown in
+// the session list.
+func clientInfo(r *http.Request) models.Client {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return models.Client{
+		UserAgent: r.UserAgent(),
+		IP:        ip,
+	}
+}
@@ -1,34 +1,57 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/ortin779/chirpy/helpers"
 )
 
-func AuthMiddleware(next http.HandlerFunc) http.Handler {
+// Denylist tells which access tokens were revoked before they expired.
+type Denylist interface {
+	IsAccessTokenRevoked(jti string) (bool, error)
+}
+
+// AuthMiddleware authenticates requests by their access token and sets the
+// User-Id header to the user it was issued to.
+type AuthMiddleware struct {
+	denylist Denylist
+}
+
+// NewAuthMiddleware rejects the access tokens on the denylist.
+func NewAuthMiddleware(denylist Denylist) AuthMiddleware {
+	return AuthMiddleware{
+		denylist: denylist,
+	}
+}
+
+// Required only lets authenticated requests through.
+func (am AuthMiddleware) Required(next http.HandlerFunc) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		jwtToken := r.Header.Get("Authorization")
-		token, err := helpers.ValidateToken(jwtToken)
-		if err != nil {
-			RespondWithError(w, 401, err.Error())
-			return
-		}
-		if !token.Valid {
-			RespondWithError(w, 401, "invalid token")
-			return
-		}
-		issuer, err := token.Claims.GetIssuer()
+		userId, err := authenticate(r, am.denylist)
 		if err != nil {
 			RespondWithError(w, 401, err.Error())
 			return
 		}
 
-		if issuer != "chirpy-access" {
-			RespondWithError(w, 401, "invalid access token")
+		r.Header.Set("User-Id", userId)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Optional is Required for public endpoints. Requests without an
+// Authorization header are passed on anonymously, without a User-Id header.
+func (am AuthMiddleware) Optional(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del("User-Id")
+		if r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
 			return
 		}
-		userId, err := token.Claims.GetSubject()
+
+		userId, err := authenticate(r, am.denylist)
 		if err != nil {
 			RespondWithError(w, 401, err.Error())
 			return
@@ -39,3 +62,44 @@ func AuthMiddleware(next http.HandlerFunc) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// authenticate validates the access token in the Authorization header and
+// returns the id of the user it was issued to.
+func authenticate(r *http.Request, denylist Denylist) (string, error) {
+	claims, err := accessClaims(r, denylist)
+	if err != nil {
+		return "", err
+	}
+	return claims.Subject, nil
+}
+
+// accessClaims validates the access token in the Authorization header and
+// returns its claims. Tokens without a jti cannot be denylisted and are
+// refused.
+func accessClaims(r *http.Request, denylist Denylist) (*jwt.RegisteredClaims, error) {
+	jwtToken := r.Header.Get("Authorization")
+	token, err := helpers.ValidateToken(jwtToken)
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(*jwt.RegisteredClaims)
+	if !token.Valid || !ok {
+		return nil, errors.New("invalid token")
+	}
+	if claims.Issuer != "chirpy-access" {
+		return nil, errors.New("invalid access token")
+	}
+
+	if claims.ID == "" {
+		return nil, errors.New("invalid access token")
+	}
+
+	revoked, err := denylist.IsAccessTokenRevoked(claims.ID)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, errors.New("access token revoked")
+	}
+	return claims, nil
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ortin779/chirpy/helpers"
+)
+
+// denylist holds the revoked jtis.
+type denylist map[string]bool
+
+func (d denylist) IsAccessTokenRevoked(jti string) (bool, error) {
+	return d[jti], nil
+}
+
+func TestAccessClaims(t *testing.T) {
+	keyring, err := helpers.NewKeyring(filepath.Join(t.TempDir(), "jwt_keys.json"), helpers.AlgorithmEdDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	helpers.UseKeyring(keyring)
+	defer helpers.UseKeyring(nil)
+
+	tests := []struct {
+		name   string
+		issuer string
+		jti    string
+		valid  bool
+	}{
+		{"access token", "chirpy-access", "jti", true},
+		{"revoked access token", "chirpy-access", "revoked", false},
+		{"access token without a jti", "chirpy-access", "", false},
+		{"refresh token", "chirpy-refresh", "jti", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := helpers.CreateToken(&jwt.RegisteredClaims{
+				Issuer:    tt.issuer,
+				Subject:   "1",
+				ID:        tt.jti,
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest("GET", "/api/users", nil)
+			r.Header.Set("Authorization", "Bearer "+token)
+
+			_, err = accessClaims(r, denylist{"revoked": true})
+			if tt.valid != (err == nil) {
+				t.Errorf("accessClaims() error = %v, want valid: %t", err, tt.valid)
+			}
+		})
+	}
+}
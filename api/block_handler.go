@@ -0,0 +1,68 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ortin779/chirpy/db"
+)
+
+type BlockHandler struct {
+	database db.Store
+}
+
+func NewBlockHandler(db db.Store) BlockHandler {
+	return BlockHandler{
+		database: db,
+	}
+}
+
+func (h *BlockHandler) HandleBlockUser(w http.ResponseWriter, r *http.Request) {
+	blockedId, blockerId, ok := followIds(w, r)
+	if !ok {
+		return
+	}
+
+	block, err := h.database.BlockUser(blockerId, blockedId)
+	if err != nil {
+		if errors.Is(err, db.NotFoundError{}) {
+			RespondWithError(w, 404, err.Error())
+		} else if errors.As(err, &db.ValidationError{}) {
+			RespondWithError(w, 400, err.Error())
+		} else {
+			RespondWithError(w, 500, err.Error())
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, block)
+}
+
+func (h *BlockHandler) HandleUnblockUser(w http.ResponseWriter, r *http.Request) {
+	blockedId, blockerId, ok := followIds(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.database.UnblockUser(blockerId, blockedId)
+	if err != nil {
+		if errors.Is(err, db.NotFoundError{}) {
+			RespondWithError(w, 404, err.Error())
+		} else {
+			RespondWithError(w, 500, err.Error())
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, struct{}{})
+}
+
+func (h *BlockHandler) HandleGetBlocks(w http.ResponseWriter, r *http.Request) {
+	blocks, err := h.database.GetBlocks(viewerId(r))
+	if err != nil {
+		RespondWithError(w, 500, err.Error())
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, blocks)
+}
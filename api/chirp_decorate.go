@@ -0,0 +1,103 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/ortin779/chirpy/db"
+	"github.com/ortin779/chirpy/models"
+)
+
+// viewerId returns the id of the authenticated user making the request, zero
+// for anonymous requests.
+func viewerId(r *http.Request) int {
+	id, err := strconv.Atoi(r.Header.Get("User-Id"))
+	if err != nil {
+		return 0
+	}
+	return id
+}
+
+// decorateChirps fills in the fields of chirps that are not stored: the
+// Original of quotes and rechirps, and LikedByMe for the user making the
+// request.
+func decorateChirps(database db.Store, r *http.Request, chirps []*models.Chirp) error {
+	originalIds := []int{}
+	for _, chirp := range chirps {
+		if id := originalId(*chirp); id != 0 {
+			originalIds = append(originalIds, id)
+		}
+	}
+
+	if len(originalIds) > 0 {
+		originals, err := database.GetChirpsByIds(originalIds)
+		if err != nil {
+			return err
+		}
+
+		resolved := []*models.Chirp{}
+		for _, chirp := range chirps {
+			id := originalId(*chirp)
+			if id == 0 {
+				continue
+			}
+			original, ok := originals[id]
+			if !ok {
+				chirp.OriginalDeleted = true
+				continue
+			}
+			chirp.Original = &original
+			resolved = append(resolved, chirp.Original)
+		}
+		chirps = append(chirps, resolved...)
+	}
+
+	userId := viewerId(r)
+	if userId == 0 || len(chirps) == 0 {
+		return nil
+	}
+
+	chirpIds := make([]int, 0, len(chirps))
+	for _, chirp := range chirps {
+		chirpIds = append(chirpIds, chirp.Id)
+	}
+	liked, err := database.HasLiked(userId, chirpIds)
+	if err != nil {
+		return err
+	}
+	for _, chirp := range chirps {
+		chirp.LikedByMe = liked[chirp.Id]
+	}
+	return nil
+}
+
+// originalId returns the id of the chirp a quote or rechirp shares, zero for
+// any other chirp.
+func originalId(chirp models.Chirp) int {
+	if chirp.RechirpOf != 0 {
+		return chirp.RechirpOf
+	}
+	return chirp.QuoteOf
+}
+
+func chirpRefs(chirps []models.Chirp) []*models.Chirp {
+	refs := make([]*models.Chirp, 0, len(chirps))
+	for i := range chirps {
+		refs = append(refs, &chirps[i])
+	}
+	return refs
+}
+
+func threadRefs(thread *models.ChirpThread) []*models.Chirp {
+	refs := chirpRefs(thread.Ancestors)
+
+	var collect func(node *models.ChirpNode)
+	collect = func(node *models.ChirpNode) {
+		refs = append(refs, &node.Chirp)
+		for i := range node.Replies {
+			collect(&node.Replies[i])
+		}
+	}
+	collect(&thread.Chirp)
+	return refs
+}
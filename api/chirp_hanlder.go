@@ -3,29 +3,45 @@ package api
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/ortin779/chirpy/db"
+	"github.com/ortin779/chirpy/entities"
+	"github.com/ortin779/chirpy/models"
+	"github.com/ortin779/chirpy/moderation"
 )
 
 type chirpRequestBody struct {
-	Body string `json:"body"`
+	Body    string `json:"body"`
+	ReplyTo int    `json:"reply_to"`
+	QuoteOf int    `json:"quote_of"`
 }
 
-var ProfaneWords = []string{
-	"kerfuffle",
-	"sharbert",
-	"fornax",
-}
+const (
+	maxChirpLength = 140
+
+	defaultPageSize = 20
+	maxPageSize     = 100
+
+	defaultTrendingLimit = 10
+	maxTrendingWindow    = 30 * 24 * time.Hour
+)
 
 type ChirpHandler struct {
-	database *db.DB
+	database  db.Store
+	moderator *moderation.Moderator
+	// restoreWindow is how long after deletion a chirp can be restored.
+	restoreWindow time.Duration
 }
 
-func NewChirpHandler(db *db.DB) ChirpHandler {
+func NewChirpHandler(db db.Store, moderator *moderation.Moderator, restoreWindow time.Duration) ChirpHandler {
 	return ChirpHandler{
-		database: db,
+		database:      db,
+		moderator:     moderator,
+		restoreWindow: restoreWindow,
 	}
 }
 
@@ -45,43 +61,307 @@ func (ch *ChirpHandler) HandleCreateChirp(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	if len(requestBody.Body) > 140 {
-		RespondWithError(w, 400, "Chirp is too long")
+	id, err := strconv.Atoi(userId)
+
+	if err != nil {
+		RespondWithError(w, 400, "invalid user id")
 		return
 	}
 
-	id, err := strconv.Atoi(userId)
+	moderated, ok := ch.moderateBody(w, requestBody.Body)
+	if !ok {
+		return
+	}
 
+	chirp, err := ch.database.CreateChirp(models.Chirp{
+		Body:     moderated.Body,
+		AuthorId: id,
+		Flagged:  moderated.Flagged,
+		ReplyTo:  requestBody.ReplyTo,
+		QuoteOf:  requestBody.QuoteOf,
+	})
+	if err != nil {
+		if errors.As(err, &db.ValidationError{}) {
+			RespondWithError(w, 400, err.Error())
+			return
+		}
+		RespondWithError(w, 500, err.Error())
+		return
+	}
+
+	ch.respondWithChirp(w, r, http.StatusCreated, chirp)
+}
+
+// HandleRechirp shares the chirp on the caller's behalf. Rechirping a
+// rechirp shares its original.
+func (ch *ChirpHandler) HandleRechirp(w http.ResponseWriter, r *http.Request) {
+	chirpId := r.PathValue("chirpId")
+	parsedId, err := strconv.Atoi(chirpId)
+	if err != nil {
+		RespondWithError(w, 400, err.Error())
+		return
+	}
+
+	userId := r.Header.Get("User-Id")
+	id, err := strconv.Atoi(userId)
 	if err != nil {
 		RespondWithError(w, 400, "invalid user id")
 		return
 	}
 
-	chirp, err := ch.database.CreateChirp(requestBody.Body, id)
+	_, err = ch.database.GetChirp(parsedId)
+	if err != nil {
+		if errors.Is(err, db.NotFoundError{}) {
+			RespondWithError(w, 404, err.Error())
+		} else if errors.As(err, &db.GoneError{}) {
+			RespondWithError(w, 410, err.Error())
+		} else {
+			RespondWithError(w, 500, err.Error())
+		}
+		return
+	}
+
+	chirp, err := ch.database.CreateChirp(models.Chirp{
+		AuthorId:  id,
+		RechirpOf: parsedId,
+	})
 	if err != nil {
+		if errors.As(err, &db.ValidationError{}) {
+			RespondWithError(w, 400, err.Error())
+			return
+		}
 		RespondWithError(w, 500, err.Error())
 		return
 	}
 
-	RespondWithJSON(w, http.StatusCreated, chirp)
+	ch.respondWithChirp(w, r, http.StatusCreated, chirp)
 }
 
 func (ch *ChirpHandler) HandleGetChirps(w http.ResponseWriter, r *http.Request) {
+	query, ok := parseChirpQuery(w, r)
+	if !ok {
+		return
+	}
+	ch.respondWithChirps(w, r, query)
+}
+
+func (ch *ChirpHandler) HandleGetReplies(w http.ResponseWriter, r *http.Request) {
+	chirpId := r.PathValue("chirpId")
+	parsedId, err := strconv.Atoi(chirpId)
+	if err != nil {
+		RespondWithError(w, 400, err.Error())
+		return
+	}
+
+	_, err = ch.database.GetChirp(parsedId)
+	if err != nil {
+		if errors.Is(err, db.NotFoundError{}) {
+			RespondWithError(w, 404, err.Error())
+		} else if errors.As(err, &db.GoneError{}) {
+			RespondWithError(w, 410, err.Error())
+		} else {
+			RespondWithError(w, 500, err.Error())
+		}
+		return
+	}
+
+	query, ok := parseChirpQuery(w, r)
+	if !ok {
+		return
+	}
+	query.ReplyTo = parsedId
+	ch.respondWithChirps(w, r, query)
+}
+
+func (ch *ChirpHandler) HandleGetThread(w http.ResponseWriter, r *http.Request) {
+	chirpId := r.PathValue("chirpId")
+	parsedId, err := strconv.Atoi(chirpId)
+	if err != nil {
+		RespondWithError(w, 400, err.Error())
+		return
+	}
+
+	thread, err := ch.database.GetThread(parsedId)
+	if err != nil {
+		if errors.Is(err, db.NotFoundError{}) {
+			RespondWithError(w, 404, err.Error())
+		} else if errors.As(err, &db.GoneError{}) {
+			RespondWithError(w, 410, err.Error())
+		} else {
+			RespondWithError(w, 500, err.Error())
+		}
+		return
+	}
+
+	err = decorateChirps(ch.database, r, threadRefs(&thread))
+	if err != nil {
+		RespondWithError(w, 500, err.Error())
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, thread)
+}
+
+// HandleGetTimeline returns the chirps of everyone the caller follows,
+// newest first. The response is always a page.
+func (ch *ChirpHandler) HandleGetTimeline(w http.ResponseWriter, r *http.Request) {
+	userId := r.Header.Get("User-Id")
+	id, err := strconv.Atoi(userId)
+	if err != nil {
+		RespondWithError(w, 400, "invalid user id")
+		return
+	}
+
+	query, ok := parseChirpQuery(w, r)
+	if !ok {
+		return
+	}
+	query.FollowedBy = id
+	query.Sort = "-created_at"
+	if query.Limit == 0 {
+		query.Limit = defaultPageSize
+	}
+	ch.respondWithChirps(w, r, query)
+}
+
+func (ch *ChirpHandler) HandleGetTagChirps(w http.ResponseWriter, r *http.Request) {
+	tag := entities.NormalizeTag(r.PathValue("tag"))
+	if tag == "" {
+		RespondWithError(w, 400, "invalid tag")
+		return
+	}
+
+	query, ok := parseChirpQuery(w, r)
+	if !ok {
+		return
+	}
+	query.Tag = tag
+	if r.URL.Query().Get("sort") == "" {
+		query.Sort = "-created_at"
+	}
+	ch.respondWithChirps(w, r, query)
+}
+
+// HandleGetTrendingTags returns the hashtags used by the most chirps within
+// the window, 24 hours unless given.
+func (ch *ChirpHandler) HandleGetTrendingTags(w http.ResponseWriter, r *http.Request) {
+	window := 24 * time.Hour
+	if param := r.URL.Query().Get("window"); param != "" {
+		parsed, err := time.ParseDuration(param)
+		if err != nil || parsed <= 0 || parsed > maxTrendingWindow {
+			RespondWithError(w, 400, fmt.Sprintf("window must be a duration up to %s", maxTrendingWindow))
+			return
+		}
+		window = parsed
+	}
+
+	limit := defaultTrendingLimit
+	if param := r.URL.Query().Get("limit"); param != "" {
+		parsed, err := strconv.Atoi(param)
+		if err != nil || parsed < 1 || parsed > maxPageSize {
+			RespondWithError(w, 400, fmt.Sprintf("limit must be between 1 and %d", maxPageSize))
+			return
+		}
+		limit = parsed
+	}
+
+	trending, err := ch.database.GetTrendingTags(time.Now().UTC().Add(-window), limit)
+	if err != nil {
+		RespondWithError(w, 500, err.Error())
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, trending)
+}
+
+// parseChirpQuery reads the filter and pagination parameters shared by the
+// chirp listings. If a parameter is invalid the error response has been
+// written and ok is false.
+func parseChirpQuery(w http.ResponseWriter, r *http.Request) (query models.ChirpQuery, ok bool) {
 	authorId := r.URL.Query().Get("author_id")
 	sortOrder := r.URL.Query().Get("sort")
+	limit := r.URL.Query().Get("limit")
+	cursor := r.URL.Query().Get("cursor")
+	since := r.URL.Query().Get("since")
+	until := r.URL.Query().Get("until")
 
 	if sortOrder == "" {
 		sortOrder = "asc"
 	}
 
-	chirps, err := ch.database.GetChirps(authorId, sortOrder)
+	query = models.ChirpQuery{
+		Sort:   sortOrder,
+		Cursor: cursor,
+	}
+
+	if authorId != "" {
+		id, err := strconv.Atoi(authorId)
+		if err != nil {
+			RespondWithError(w, 400, "invalid author id")
+			return models.ChirpQuery{}, false
+		}
+		query.AuthorId = id
+	}
+
+	if since != "" {
+		parsedSince, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			RespondWithError(w, 400, "since must be an RFC 3339 timestamp")
+			return models.ChirpQuery{}, false
+		}
+		query.Since = parsedSince
+	}
+
+	if until != "" {
+		parsedUntil, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			RespondWithError(w, 400, "until must be an RFC 3339 timestamp")
+			return models.ChirpQuery{}, false
+		}
+		query.Until = parsedUntil
+	}
+
+	// A limit, explicit or defaulted, makes the response a page.
+	if limit != "" || cursor != "" {
+		query.Limit = defaultPageSize
+	}
+	if limit != "" {
+		parsedLimit, err := strconv.Atoi(limit)
+		if err != nil || parsedLimit < 1 || parsedLimit > maxPageSize {
+			RespondWithError(w, 400, fmt.Sprintf("limit must be between 1 and %d", maxPageSize))
+			return models.ChirpQuery{}, false
+		}
+		query.Limit = parsedLimit
+	}
+	return query, true
+}
+
+// respondWithChirps runs query and writes the matching chirps. Without limit
+// or cursor the full list is returned as a plain array, as it was before
+// pagination existed.
+func (ch *ChirpHandler) respondWithChirps(w http.ResponseWriter, r *http.Request, query models.ChirpQuery) {
+	page, err := ch.database.GetChirps(query)
 
 	if err != nil {
+		if errors.As(err, &db.ValidationError{}) {
+			RespondWithError(w, 400, err.Error())
+			return
+		}
 		RespondWithError(w, 500, err.Error())
 		return
 	}
 
-	RespondWithJSON(w, http.StatusOK, chirps)
+	err = decorateChirps(ch.database, r, chirpRefs(page.Chirps))
+	if err != nil {
+		RespondWithError(w, 500, err.Error())
+		return
+	}
+
+	if query.Limit == 0 {
+		RespondWithJSON(w, http.StatusOK, page.Chirps)
+		return
+	}
+	RespondWithJSON(w, http.StatusOK, page)
 }
 
 func (ch *ChirpHandler) HandleGetChirp(w http.ResponseWriter, r *http.Request) {
@@ -89,19 +369,21 @@ func (ch *ChirpHandler) HandleGetChirp(w http.ResponseWriter, r *http.Request) {
 	parsedId, err := strconv.Atoi(chirpId)
 	if err != nil {
 		RespondWithError(w, 400, err.Error())
+		return
 	}
 	chirp, err := ch.database.GetChirp(parsedId)
 	if err != nil {
 		if errors.Is(err, db.NotFoundError{}) {
-
 			RespondWithError(w, 404, err.Error())
+		} else if errors.As(err, &db.GoneError{}) {
+			RespondWithError(w, 410, err.Error())
 		} else {
 			RespondWithError(w, 500, err.Error())
 		}
 		return
 	}
 
-	RespondWithJSON(w, http.StatusOK, chirp)
+	ch.respondWithChirp(w, r, http.StatusOK, chirp)
 }
 
 func (ch *ChirpHandler) HandleDeleteChirp(w http.ResponseWriter, r *http.Request) {
@@ -109,6 +391,7 @@ func (ch *ChirpHandler) HandleDeleteChirp(w http.ResponseWriter, r *http.Request
 	parsedId, err := strconv.Atoi(chirpId)
 	if err != nil {
 		RespondWithError(w, 400, err.Error())
+		return
 	}
 
 	userId := r.Header.Get("User-Id")
@@ -123,6 +406,8 @@ func (ch *ChirpHandler) HandleDeleteChirp(w http.ResponseWriter, r *http.Request
 	if err != nil {
 		if errors.Is(err, db.NotFoundError{}) {
 			RespondWithError(w, 404, err.Error())
+		} else if errors.As(err, &db.GoneError{}) {
+			RespondWithError(w, 410, err.Error())
 		} else if errors.As(err, &db.AuthorizationError{}) {
 			RespondWithError(w, 403, err.Error())
 		} else {
@@ -133,3 +418,145 @@ func (ch *ChirpHandler) HandleDeleteChirp(w http.ResponseWriter, r *http.Request
 
 	RespondWithJSON(w, http.StatusOK, struct{}{})
 }
+
+func (ch *ChirpHandler) HandleEditChirp(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	chirpId := r.PathValue("chirpId")
+	parsedId, err := strconv.Atoi(chirpId)
+	if err != nil {
+		RespondWithError(w, 400, err.Error())
+		return
+	}
+
+	userId := r.Header.Get("User-Id")
+	id, err := strconv.Atoi(userId)
+	if err != nil {
+		RespondWithError(w, 400, "invalid user id")
+		return
+	}
+
+	requestBody := chirpRequestBody{}
+	err = json.NewDecoder(r.Body).Decode(&requestBody)
+	if err != nil {
+		RespondWithError(w, 400, "invalid chirp body")
+		return
+	}
+
+	moderated, ok := ch.moderateBody(w, requestBody.Body)
+	if !ok {
+		return
+	}
+
+	chirp, err := ch.database.UpdateChirp(parsedId, id, moderated.Body, moderated.Flagged)
+	if err != nil {
+		if errors.Is(err, db.NotFoundError{}) {
+			RespondWithError(w, 404, err.Error())
+		} else if errors.As(err, &db.GoneError{}) {
+			RespondWithError(w, 410, err.Error())
+		} else if errors.As(err, &db.AuthorizationError{}) {
+			RespondWithError(w, 403, err.Error())
+		} else if errors.As(err, &db.ValidationError{}) {
+			RespondWithError(w, 400, err.Error())
+		} else {
+			RespondWithError(w, 500, err.Error())
+		}
+		return
+	}
+
+	ch.respondWithChirp(w, r, http.StatusOK, chirp)
+}
+
+func (ch *ChirpHandler) HandleGetChirpHistory(w http.ResponseWriter, r *http.Request) {
+	chirpId := r.PathValue("chirpId")
+	parsedId, err := strconv.Atoi(chirpId)
+	if err != nil {
+		RespondWithError(w, 400, err.Error())
+		return
+	}
+
+	versions, err := ch.database.GetChirpHistory(parsedId)
+	if err != nil {
+		if errors.Is(err, db.NotFoundError{}) {
+			RespondWithError(w, 404, err.Error())
+		} else if errors.As(err, &db.GoneError{}) {
+			RespondWithError(w, 410, err.Error())
+		} else {
+			RespondWithError(w, 500, err.Error())
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, versions)
+}
+
+func (ch *ChirpHandler) HandleRestoreChirp(w http.ResponseWriter, r *http.Request) {
+	chirpId := r.PathValue("chirpId")
+	parsedId, err := strconv.Atoi(chirpId)
+	if err != nil {
+		RespondWithError(w, 400, err.Error())
+		return
+	}
+
+	userId := r.Header.Get("User-Id")
+	id, err := strconv.Atoi(userId)
+	if err != nil {
+		RespondWithError(w, 400, "invalid user id")
+		return
+	}
+
+	chirp, err := ch.database.RestoreChirp(parsedId, id, ch.restoreWindow)
+	if err != nil {
+		if errors.Is(err, db.NotFoundError{}) {
+			RespondWithError(w, 404, err.Error())
+		} else if errors.As(err, &db.GoneError{}) {
+			RespondWithError(w, 410, err.Error())
+		} else if errors.As(err, &db.AuthorizationError{}) {
+			RespondWithError(w, 403, err.Error())
+		} else if errors.As(err, &db.ValidationError{}) {
+			RespondWithError(w, 400, err.Error())
+		} else {
+			RespondWithError(w, 500, err.Error())
+		}
+		return
+	}
+
+	ch.respondWithChirp(w, r, http.StatusOK, chirp)
+}
+
+// respondWithChirp writes chirp with the fields filled in that are not
+// stored.
+func (ch *ChirpHandler) respondWithChirp(w http.ResponseWriter, r *http.Request, status int, chirp models.Chirp) {
+	err := decorateChirps(ch.database, r, []*models.Chirp{&chirp})
+	if err != nil {
+		RespondWithError(w, 500, err.Error())
+		return
+	}
+	RespondWithJSON(w, status, chirp)
+}
+
+// moderateBody enforces the length limit and the moderation policies on a
+// chirp body. Masking a short word makes the body longer, so the limit
+// applies to the masked body as well. If the body is refused the error
+// response has been written and ok is false.
+func (ch *ChirpHandler) moderateBody(w http.ResponseWriter, body string) (moderated moderation.Result, ok bool) {
+	if len(body) > maxChirpLength {
+		RespondWithError(w, 400, "Chirp is too long")
+		return moderation.Result{}, false
+	}
+
+	moderated, err := ch.moderator.Moderate(body)
+	if err != nil {
+		if errors.As(err, &moderation.RejectedError{}) {
+			RespondWithError(w, 400, err.Error())
+		} else {
+			RespondWithError(w, 500, err.Error())
+		}
+		return moderation.Result{}, false
+	}
+	if len(moderated.Body) > maxChirpLength {
+		RespondWithError(w, 400, "Chirp is too long once masked")
+		return moderation.Result{}, false
+	}
+	return moderated, true
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ortin779/chirpy/moderation"
+)
+
+func TestModerateBodyLength(t *testing.T) {
+	moderator, err := moderation.New(filepath.Join(t.TempDir(), "moderation.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = moderator.SetPolicy(moderation.Policy{Name: "short", Action: moderation.ActionMask, Words: []string{"ab"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = moderator.SetPolicy(moderation.Policy{Name: "threats", Action: moderation.ActionReject, Words: []string{"doxx"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := ChirpHandler{moderator: moderator}
+
+	tests := []struct {
+		name    string
+		body    string
+		ok      bool
+		message string
+	}{
+		{"at the limit", strings.Repeat("x", maxChirpLength), true, ""},
+		{"over the limit", strings.Repeat("x", maxChirpLength+1), false, "Chirp is too long"},
+		{"over the limit and rejected", "doxx " + strings.Repeat("x", maxChirpLength), false, "Chirp is too long"},
+		{"masked within the limit", "ab " + strings.Repeat("x", maxChirpLength-5), true, ""},
+		{"masked past the limit", "ab " + strings.Repeat("x", maxChirpLength-3), false, "Chirp is too long once masked"},
+		{"rejected", "AB, doxx!", false, "chirp violates the threats policy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			moderated, ok := ch.moderateBody(w, tt.body)
+			if ok != tt.ok {
+				t.Fatalf("moderateBody() ok = %t, want %t (status %d)", ok, tt.ok, w.Code)
+			}
+			if ok && len(moderated.Body) > maxChirpLength {
+				t.Errorf("moderated body is %d long", len(moderated.Body))
+			}
+			if !ok && (w.Code != 400 || !strings.Contains(w.Body.String(), `"`+tt.message+`"`)) {
+				t.Errorf("response = %d %s, want 400 %q", w.Code, w.Body.String(), tt.message)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/ortin779/chirpy/db"
+	"github.com/ortin779/chirpy/models"
+)
+
+type createConversationRequestBody struct {
+	MemberIds []int `json:"member_ids"`
+}
+
+type sendMessageRequestBody struct {
+	Body string `json:"body"`
+}
+
+type markConversationReadRequestBody struct {
+	MessageId int `json:"message_id"`
+}
+
+type ConversationHandler struct {
+	database db.Store
+}
+
+func NewConversationHandler(db db.Store) ConversationHandler {
+	return ConversationHandler{
+		database: db,
+	}
+}
+
+// HandleCreateConversation starts a conversation, or returns the existing
+// one between the same two users with 200 instead of 201.
+func (h *ConversationHandler) HandleCreateConversation(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	requestBody := createConversationRequestBody{}
+	err := json.NewDecoder(r.Body).Decode(&requestBody)
+	if err != nil {
+		RespondWithError(w, 400, "invalid conversation body")
+		return
+	}
+
+	conversation, created, err := h.database.CreateConversation(viewerId(r), requestBody.MemberIds)
+	if err != nil {
+		respondWithConversationError(w, err)
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	RespondWithJSON(w, status, conversation)
+}
+
+func (h *ConversationHandler) HandleGetConversations(w http.ResponseWriter, r *http.Request) {
+	conversations, err := h.database.GetConversations(viewerId(r))
+	if err != nil {
+		RespondWithError(w, 500, err.Error())
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, conversations)
+}
+
+func (h *ConversationHandler) HandleGetConversation(w http.ResponseWriter, r *http.Request) {
+	conversationId, ok := parseConversationId(w, r)
+	if !ok {
+		return
+	}
+
+	conversation, err := h.database.GetConversation(conversationId, viewerId(r))
+	if err != nil {
+		respondWithConversationError(w, err)
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, conversation)
+}
+
+func (h *ConversationHandler) HandleSendMessage(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	conversationId, ok := parseConversationId(w, r)
+	if !ok {
+		return
+	}
+
+	requestBody := sendMessageRequestBody{}
+	err := json.NewDecoder(r.Body).Decode(&requestBody)
+	if err != nil {
+		RespondWithError(w, 400, "invalid message body")
+		return
+	}
+
+	message, err := h.database.SendMessage(conversationId, viewerId(r), requestBody.Body)
+	if err != nil {
+		respondWithConversationError(w, err)
+		return
+	}
+
+	RespondWithJSON(w, http.StatusCreated, message)
+}
+
+func (h *ConversationHandler) HandleGetMessages(w http.ResponseWriter, r *http.Request) {
+	conversationId, ok := parseConversationId(w, r)
+	if !ok {
+		return
+	}
+
+	query := models.MessageQuery{
+		Limit:  defaultPageSize,
+		Cursor: r.URL.Query().Get("cursor"),
+	}
+	if param := r.URL.Query().Get("limit"); param != "" {
+		parsed, err := strconv.Atoi(param)
+		if err != nil || parsed < 1 || parsed > maxPageSize {
+			RespondWithError(w, 400, fmt.Sprintf("limit must be between 1 and %d", maxPageSize))
+			return
+		}
+		query.Limit = parsed
+	}
+
+	page, err := h.database.GetMessages(conversationId, viewerId(r), query)
+	if err != nil {
+		respondWithConversationError(w, err)
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, page)
+}
+
+// HandleMarkConversationRead moves the read receipt of the user up to the
+// message of the body, or the newest message without a body.
+func (h *ConversationHandler) HandleMarkConversationRead(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	conversationId, ok := parseConversationId(w, r)
+	if !ok {
+		return
+	}
+
+	requestBody := markConversationReadRequestBody{}
+	err := json.NewDecoder(r.Body).Decode(&requestBody)
+	if err != nil && !errors.Is(err, io.EOF) {
+		RespondWithError(w, 400, "invalid read body")
+		return
+	}
+
+	conversation, err := h.database.MarkConversationRead(conversationId, viewerId(r), requestBody.MessageId)
+	if err != nil {
+		respondWithConversationError(w, err)
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, conversation)
+}
+
+// parseConversationId returns the conversation of the path. If it is
+// invalid the error response has been written and ok is false.
+func parseConversationId(w http.ResponseWriter, r *http.Request) (conversationId int, ok bool) {
+	conversationId, err := strconv.Atoi(r.PathValue("conversationId"))
+	if err != nil {
+		RespondWithError(w, 400, "invalid conversation id")
+		return 0, false
+	}
+	return conversationId, true
+}
+
+func respondWithConversationError(w http.ResponseWriter, err error) {
+	if errors.Is(err, db.NotFoundError{}) {
+		RespondWithError(w, 404, err.Error())
+	} else if errors.As(err, &db.AuthorizationError{}) {
+		RespondWithError(w, 403, err.Error())
+	} else if errors.As(err, &db.ValidationError{}) {
+		RespondWithError(w, 400, err.Error())
+	} else {
+		RespondWithError(w, 500, err.Error())
+	}
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/ortin779/chirpy/db"
+	"github.com/ortin779/chirpy/models"
+)
+
+type FollowHandler struct {
+	database db.Store
+}
+
+func NewFollowHandler(db db.Store) FollowHandler {
+	return FollowHandler{
+		database: db,
+	}
+}
+
+func (h *FollowHandler) HandleFollowUser(w http.ResponseWriter, r *http.Request) {
+	followeeId, followerId, ok := followIds(w, r)
+	if !ok {
+		return
+	}
+
+	follow, err := h.database.FollowUser(followerId, followeeId)
+	if err != nil {
+		if errors.Is(err, db.NotFoundError{}) {
+			RespondWithError(w, 404, err.Error())
+		} else if errors.As(err, &db.ValidationError{}) {
+			RespondWithError(w, 400, err.Error())
+		} else {
+			RespondWithError(w, 500, err.Error())
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, follow)
+}
+
+func (h *FollowHandler) HandleUnfollowUser(w http.ResponseWriter, r *http.Request) {
+	followeeId, followerId, ok := followIds(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.database.UnfollowUser(followerId, followeeId)
+	if err != nil {
+		if errors.Is(err, db.NotFoundError{}) {
+			RespondWithError(w, 404, err.Error())
+		} else {
+			RespondWithError(w, 500, err.Error())
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, struct{}{})
+}
+
+func (h *FollowHandler) HandleGetFollowers(w http.ResponseWriter, r *http.Request) {
+	h.handleFollowList(w, r, h.database.GetFollowers)
+}
+
+func (h *FollowHandler) HandleGetFollowing(w http.ResponseWriter, r *http.Request) {
+	h.handleFollowList(w, r, h.database.GetFollowing)
+}
+
+func (h *FollowHandler) handleFollowList(w http.ResponseWriter, r *http.Request, list func(userId int) ([]models.FollowUser, error)) {
+	userId := r.PathValue("userId")
+	parsedId, err := strconv.Atoi(userId)
+	if err != nil {
+		RespondWithError(w, 400, "invalid user id")
+		return
+	}
+
+	users, err := list(parsedId)
+	if err != nil {
+		if errors.Is(err, db.NotFoundError{}) {
+			RespondWithError(w, 404, err.Error())
+		} else {
+			RespondWithError(w, 500, err.Error())
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, users)
+}
+
+// followIds returns the user of the path and the authenticated user. If
+// either is invalid the error response has been written and ok is false.
+func followIds(w http.ResponseWriter, r *http.Request) (followeeId int, followerId int, ok bool) {
+	followeeId, err := strconv.Atoi(r.PathValue("userId"))
+	if err != nil {
+		RespondWithError(w, 400, "invalid user id")
+		return 0, 0, false
+	}
+
+	followerId, err = strconv.Atoi(r.Header.Get("User-Id"))
+	if err != nil {
+		RespondWithError(w, 400, "invalid user id")
+		return 0, 0, false
+	}
+	return followeeId, followerId, true
+}
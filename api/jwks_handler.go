@@ -0,0 +1,24 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/ortin779/chirpy/helpers"
+)
+
+type JWKSHandler struct {
+	keyring *helpers.Keyring
+}
+
+func NewJWKSHandler(keyring *helpers.Keyring) JWKSHandler {
+	return JWKSHandler{
+		keyring: keyring,
+	}
+}
+
+// HandleGetJWKS publishes the public keys that access tokens are verified
+// with, so other services can verify them.
+func (h *JWKSHandler) HandleGetJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	RespondWithJSON(w, http.StatusOK, h.keyring.JWKS())
+}
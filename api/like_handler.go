@@ -0,0 +1,85 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/ortin779/chirpy/db"
+	"github.com/ortin779/chirpy/models"
+)
+
+type LikeHandler struct {
+	database db.Store
+}
+
+func NewLikeHandler(db db.Store) LikeHandler {
+	return LikeHandler{
+		database: db,
+	}
+}
+
+func (h *LikeHandler) HandleLikeChirp(w http.ResponseWriter, r *http.Request) {
+	h.handleLike(w, r, h.database.LikeChirp)
+}
+
+func (h *LikeHandler) HandleUnlikeChirp(w http.ResponseWriter, r *http.Request) {
+	h.handleLike(w, r, h.database.UnlikeChirp)
+}
+
+func (h *LikeHandler) handleLike(w http.ResponseWriter, r *http.Request, like func(chirpId int, userId int) (models.Chirp, error)) {
+	chirpId := r.PathValue("chirpId")
+	parsedId, err := strconv.Atoi(chirpId)
+	if err != nil {
+		RespondWithError(w, 400, err.Error())
+		return
+	}
+
+	userId := r.Header.Get("User-Id")
+	id, err := strconv.Atoi(userId)
+	if err != nil {
+		RespondWithError(w, 400, "invalid user id")
+		return
+	}
+
+	chirp, err := like(parsedId, id)
+	if err != nil {
+		if errors.Is(err, db.NotFoundError{}) {
+			RespondWithError(w, 404, err.Error())
+		} else if errors.As(err, &db.GoneError{}) {
+			RespondWithError(w, 410, err.Error())
+		} else {
+			RespondWithError(w, 500, err.Error())
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, chirp)
+}
+
+func (h *LikeHandler) HandleGetLikedChirps(w http.ResponseWriter, r *http.Request) {
+	userId := r.PathValue("userId")
+	parsedId, err := strconv.Atoi(userId)
+	if err != nil {
+		RespondWithError(w, 400, "invalid user id")
+		return
+	}
+
+	chirps, err := h.database.GetLikedChirps(parsedId)
+	if err != nil {
+		if errors.Is(err, db.NotFoundError{}) {
+			RespondWithError(w, 404, err.Error())
+		} else {
+			RespondWithError(w, 500, err.Error())
+		}
+		return
+	}
+
+	err = decorateChirps(h.database, r, chirpRefs(chirps))
+	if err != nil {
+		RespondWithError(w, 500, err.Error())
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, chirps)
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/ortin779/chirpy/db"
+	"github.com/ortin779/chirpy/models"
+	"github.com/ortin779/chirpy/moderation"
+)
+
+type policyRequestBody struct {
+	Action moderation.Action `json:"action"`
+	Words  []string          `json:"words"`
+}
+
+type wordsRequestBody struct {
+	Words []string `json:"words"`
+}
+
+type ModerationHandler struct {
+	moderator *moderation.Moderator
+	database  db.Store
+}
+
+func NewModerationHandler(moderator *moderation.Moderator, db db.Store) ModerationHandler {
+	return ModerationHandler{
+		moderator: moderator,
+		database:  db,
+	}
+}
+
+func (mh *ModerationHandler) HandleGetPolicies(w http.ResponseWriter, r *http.Request) {
+	RespondWithJSON(w, http.StatusOK, mh.moderator.Policies())
+}
+
+func (mh *ModerationHandler) HandlePutPolicy(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	requestBody := policyRequestBody{}
+	err := json.NewDecoder(r.Body).Decode(&requestBody)
+	if err != nil {
+		RespondWithError(w, 400, "invalid policy body")
+		return
+	}
+
+	policy, err := mh.moderator.SetPolicy(moderation.Policy{
+		Name:   r.PathValue("name"),
+		Action: requestBody.Action,
+		Words:  requestBody.Words,
+	})
+	if err != nil {
+		respondWithModerationError(w, err)
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, policy)
+}
+
+func (mh *ModerationHandler) HandleDeletePolicy(w http.ResponseWriter, r *http.Request) {
+	err := mh.moderator.DeletePolicy(r.PathValue("name"))
+	if err != nil {
+		respondWithModerationError(w, err)
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, struct{}{})
+}
+
+func (mh *ModerationHandler) HandleAddWords(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	requestBody := wordsRequestBody{}
+	err := json.NewDecoder(r.Body).Decode(&requestBody)
+	if err != nil {
+		RespondWithError(w, 400, "invalid words body")
+		return
+	}
+
+	policy, err := mh.moderator.AddWords(r.PathValue("name"), requestBody.Words)
+	if err != nil {
+		respondWithModerationError(w, err)
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, policy)
+}
+
+func (mh *ModerationHandler) HandleRemoveWords(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	requestBody := wordsRequestBody{}
+	err := json.NewDecoder(r.Body).Decode(&requestBody)
+	if err != nil {
+		RespondWithError(w, 400, "invalid words body")
+		return
+	}
+
+	policy, err := mh.moderator.RemoveWords(r.PathValue("name"), requestBody.Words)
+	if err != nil {
+		respondWithModerationError(w, err)
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, policy)
+}
+
+// HandleGetFlaggedChirps lists the chirps flagged for review, newest first.
+func (mh *ModerationHandler) HandleGetFlaggedChirps(w http.ResponseWriter, r *http.Request) {
+	page, err := mh.database.GetChirps(models.ChirpQuery{
+		FlaggedOnly: true,
+		Sort:        "desc",
+	})
+	if err != nil {
+		RespondWithError(w, 500, err.Error())
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, page.Chirps)
+}
+
+func respondWithModerationError(w http.ResponseWriter, err error) {
+	if errors.As(err, &moderation.ValidationError{}) {
+		RespondWithError(w, 400, err.Error())
+		return
+	}
+	if errors.As(err, &moderation.NotFoundError{}) {
+		RespondWithError(w, 404, err.Error())
+		return
+	}
+	RespondWithError(w, 500, err.Error())
+}
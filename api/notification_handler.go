@@ -0,0 +1,164 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/ortin779/chirpy/db"
+	"github.com/ortin779/chirpy/models"
+)
+
+// notificationWindow is the number of newest notifications that are grouped
+// into the response.
+const notificationWindow = 500
+
+type markReadRequestBody struct {
+	Ids []int `json:"ids"`
+}
+
+type NotificationHandler struct {
+	database db.Store
+}
+
+func NewNotificationHandler(db db.Store) NotificationHandler {
+	return NotificationHandler{
+		database: db,
+	}
+}
+
+func (h *NotificationHandler) HandleGetNotifications(w http.ResponseWriter, r *http.Request) {
+	userId := viewerId(r)
+
+	limit := defaultPageSize
+	if param := r.URL.Query().Get("limit"); param != "" {
+		parsed, err := strconv.Atoi(param)
+		if err != nil || parsed < 1 || parsed > maxPageSize {
+			RespondWithError(w, 400, fmt.Sprintf("limit must be between 1 and %d", maxPageSize))
+			return
+		}
+		limit = parsed
+	}
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+
+	notifications, err := h.database.GetNotifications(userId, notificationWindow)
+	if err != nil {
+		RespondWithError(w, 500, err.Error())
+		return
+	}
+	unread, err := h.database.CountUnreadNotifications(userId)
+	if err != nil {
+		RespondWithError(w, 500, err.Error())
+		return
+	}
+
+	if unreadOnly {
+		notifications = slices.DeleteFunc(notifications, func(n models.Notification) bool { return n.ReadAt != nil })
+	}
+	groups := groupNotifications(notifications)
+	if len(groups) > limit {
+		groups = groups[:limit]
+	}
+
+	RespondWithJSON(w, http.StatusOK, models.NotificationInbox{
+		UnreadCount:   unread,
+		Notifications: groups,
+	})
+}
+
+// HandleMarkNotificationsRead marks the notifications listed in the body as
+// read, or every notification without a body.
+func (h *NotificationHandler) HandleMarkNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	userId := viewerId(r)
+
+	requestBody := markReadRequestBody{}
+	err := json.NewDecoder(r.Body).Decode(&requestBody)
+	if err != nil && !errors.Is(err, io.EOF) {
+		RespondWithError(w, 400, "invalid notifications body")
+		return
+	}
+
+	_, err = h.database.MarkNotificationsRead(userId, requestBody.Ids)
+	if err != nil {
+		RespondWithError(w, 500, err.Error())
+		return
+	}
+	unread, err := h.database.CountUnreadNotifications(userId)
+	if err != nil {
+		RespondWithError(w, 500, err.Error())
+		return
+	}
+	RespondWithJSON(w, http.StatusOK, map[string]int{"unread_count": unread})
+}
+
+// groupNotifications folds bursts into single entries: the likes of a chirp
+// and follows are grouped, separately for read and unread ones. Mentions,
+// replies, quotes and rechirps each come from a different chirp and stay
+// on their own.
+// notifications and the groups are newest first.
+func groupNotifications(notifications []models.Notification) []models.NotificationGroup {
+	groups := []models.NotificationGroup{}
+	index := make(map[string]int)
+
+	for _, notification := range notifications {
+		key := ""
+		switch notification.Type {
+		case models.NotificationLike, models.NotificationFollow:
+			key = fmt.Sprintf("%s:%d:%t", notification.Type, notification.ChirpId, notification.ReadAt != nil)
+		}
+
+		i, ok := index[key]
+		if key == "" || !ok {
+			i = len(groups)
+			groups = append(groups, models.NotificationGroup{
+				Type:      notification.Type,
+				ChirpId:   notification.ChirpId,
+				ActorIds:  []int{},
+				Read:      notification.ReadAt != nil,
+				CreatedAt: notification.CreatedAt,
+			})
+			if key != "" {
+				index[key] = i
+			}
+		}
+
+		group := &groups[i]
+		group.NotificationIds = append(group.NotificationIds, notification.Id)
+		if !slices.Contains(group.ActorIds, notification.ActorId) {
+			group.ActorIds = append(group.ActorIds, notification.ActorId)
+		}
+	}
+
+	for i := range groups {
+		groups[i].Message = notificationMessage(groups[i])
+	}
+	return groups
+}
+
+func notificationMessage(group models.NotificationGroup) string {
+	actors := "1 person"
+	if len(group.ActorIds) > 1 {
+		actors = fmt.Sprintf("%d people", len(group.ActorIds))
+	}
+
+	switch group.Type {
+	case models.NotificationMention:
+		return actors + " mentioned you"
+	case models.NotificationReply:
+		return actors + " replied to your chirp"
+	case models.NotificationQuote:
+		return actors + " quoted your chirp"
+	case models.NotificationRechirp:
+		return actors + " rechirped your chirp"
+	case models.NotificationLike:
+		return actors + " liked your chirp"
+	case models.NotificationFollow:
+		return actors + " followed you"
+	}
+	return ""
+}
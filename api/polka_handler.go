@@ -12,10 +12,10 @@ import (
 )
 
 type PolkaHandler struct {
-	database *db.DB
+	database db.Store
 }
 
-func NewPolksHandler(db *db.DB) PolkaHandler {
+func NewPolksHandler(db db.Store) PolkaHandler {
 	return PolkaHandler{
 		database: db,
 	}
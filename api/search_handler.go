@@ -0,0 +1,65 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ortin779/chirpy/search"
+)
+
+type SearchHandler struct {
+	database *search.Store
+}
+
+func NewSearchHandler(database *search.Store) SearchHandler {
+	return SearchHandler{
+		database: database,
+	}
+}
+
+// HandleSearchChirps returns a page of the chirps matching the q parameter,
+// best match first.
+func (sh *SearchHandler) HandleSearchChirps(w http.ResponseWriter, r *http.Request) {
+	query := search.Query{
+		Text:   r.URL.Query().Get("q"),
+		Limit:  defaultPageSize,
+		Cursor: r.URL.Query().Get("cursor"),
+	}
+
+	if authorId := r.URL.Query().Get("author_id"); authorId != "" {
+		id, err := strconv.Atoi(authorId)
+		if err != nil {
+			RespondWithError(w, 400, "invalid author id")
+			return
+		}
+		query.AuthorId = id
+	}
+
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		parsedLimit, err := strconv.Atoi(limit)
+		if err != nil || parsedLimit < 1 || parsedLimit > maxPageSize {
+			RespondWithError(w, 400, fmt.Sprintf("limit must be between 1 and %d", maxPageSize))
+			return
+		}
+		query.Limit = parsedLimit
+	}
+
+	page, err := sh.database.SearchChirps(query)
+	if err != nil {
+		if errors.As(err, &search.ValidationError{}) {
+			RespondWithError(w, 400, err.Error())
+			return
+		}
+		RespondWithError(w, 500, err.Error())
+		return
+	}
+
+	err = decorateChirps(sh.database, r, chirpRefs(page.Chirps))
+	if err != nil {
+		RespondWithError(w, 500, err.Error())
+		return
+	}
+	RespondWithJSON(w, http.StatusOK, page)
+}
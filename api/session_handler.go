@@ -0,0 +1,54 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ortin779/chirpy/db"
+)
+
+type SessionHandler struct {
+	database db.Store
+}
+
+func NewSessionHandler(db db.Store) SessionHandler {
+	return SessionHandler{
+		database: db,
+	}
+}
+
+func (h *SessionHandler) HandleGetSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := h.database.GetSessions(viewerId(r))
+	if err != nil {
+		RespondWithError(w, 500, err.Error())
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, sessions)
+}
+
+func (h *SessionHandler) HandleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	err := h.database.RevokeSession(viewerId(r), r.PathValue("sessionId"))
+	if err != nil {
+		if errors.Is(err, db.NotFoundError{}) {
+			RespondWithError(w, 404, err.Error())
+		} else {
+			RespondWithError(w, 500, err.Error())
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, struct{}{})
+}
+
+// HandleLogoutAll revokes every session of the user, who has to log in
+// again on all their devices.
+func (h *SessionHandler) HandleLogoutAll(w http.ResponseWriter, r *http.Request) {
+	revoked, err := h.database.RevokeAllSessions(viewerId(r))
+	if err != nil {
+		RespondWithError(w, 500, err.Error())
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]int{"revoked_sessions": revoked})
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ortin779/chirpy/events"
+)
+
+// heartbeatInterval is how often an idle stream sends a comment, so proxies
+// keep the connection open and dead clients are noticed.
+const heartbeatInterval = 15 * time.Second
+
+type StreamHandler struct {
+	hub *events.Hub
+}
+
+func NewStreamHandler(hub *events.Hub) StreamHandler {
+	return StreamHandler{
+		hub: hub,
+	}
+}
+
+// HandleStreamChirps sends created and deleted chirps as Server-Sent Events
+// until the client disconnects. A client resuming with Last-Event-ID first
+// gets the events it missed, as far as they are still kept.
+func (sh *StreamHandler) HandleStreamChirps(w http.ResponseWriter, r *http.Request) {
+	authorId := 0
+	if param := r.URL.Query().Get("author_id"); param != "" {
+		id, err := strconv.Atoi(param)
+		if err != nil {
+			RespondWithError(w, 400, "invalid author id")
+			return
+		}
+		authorId = id
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		RespondWithError(w, 500, "streaming is not supported")
+		return
+	}
+
+	sub, missed := sh.hub.Subscribe(r.Header.Get("Last-Event-ID"))
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	send := func(event events.Event) error {
+		if !event.IsChirp() {
+			return nil
+		}
+		if authorId != 0 && event.Chirp.AuthorId != authorId {
+			return nil
+		}
+		data, err := json.Marshal(event.ChirpData())
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.Id, event.Type, data)
+		return err
+	}
+
+	for _, event := range missed {
+		if send(event) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.C:
+			if !ok {
+				// Dropped for falling behind or shutting down, the
+				// client reconnects with the last id it got.
+				return
+			}
+			if send(event) != nil {
+				return
+			}
+		case <-heartbeat.C:
+			_, err := fmt.Fprint(w, ": heartbeat\n\n")
+			if err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
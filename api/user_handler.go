@@ -2,17 +2,19 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
 
 	"github.com/ortin779/chirpy/db"
 	"github.com/ortin779/chirpy/models"
 )
 
 type UserHandler struct {
-	database *db.DB
+	database db.Store
 }
 
-func NewUserHandler(db *db.DB) UserHandler {
+func NewUserHandler(db db.Store) UserHandler {
 	return UserHandler{
 		database: db,
 	}
@@ -34,7 +36,11 @@ func (h *UserHandler) HandleCreateUser(w http.ResponseWriter, r *http.Request) {
 
 	user, err := h.database.CreateUser(requestBody)
 	if err != nil {
-		RespondWithError(w, 500, err.Error())
+		if errors.As(err, &db.ValidationError{}) {
+			RespondWithError(w, 400, err.Error())
+		} else {
+			RespondWithError(w, 500, err.Error())
+		}
 		return
 	}
 
@@ -58,7 +64,53 @@ func (h *UserHandler) HandleEditUser(w http.ResponseWriter, r *http.Request) {
 
 	user, err := h.database.UpdateUser(requestBody, userId)
 	if err != nil {
-		RespondWithError(w, 500, err.Error())
+		if errors.As(err, &db.ValidationError{}) {
+			RespondWithError(w, 400, err.Error())
+		} else {
+			RespondWithError(w, 500, err.Error())
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, user)
+}
+
+// HandleSuspendUser suspends the user of the path, who is logged out
+// everywhere and cannot log in until unsuspended.
+func (h *UserHandler) HandleSuspendUser(w http.ResponseWriter, r *http.Request) {
+	userId, err := strconv.Atoi(r.PathValue("userId"))
+	if err != nil {
+		RespondWithError(w, 400, "invalid user id")
+		return
+	}
+
+	user, err := h.database.SuspendUser(userId)
+	if err != nil {
+		if errors.Is(err, db.NotFoundError{}) {
+			RespondWithError(w, 404, err.Error())
+		} else {
+			RespondWithError(w, 500, err.Error())
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, user)
+}
+
+func (h *UserHandler) HandleUnsuspendUser(w http.ResponseWriter, r *http.Request) {
+	userId, err := strconv.Atoi(r.PathValue("userId"))
+	if err != nil {
+		RespondWithError(w, 400, "invalid user id")
+		return
+	}
+
+	user, err := h.database.UnsuspendUser(userId)
+	if err != nil {
+		if errors.Is(err, db.NotFoundError{}) {
+			RespondWithError(w, 404, err.Error())
+		} else {
+			RespondWithError(w, 500, err.Error())
+		}
 		return
 	}
 
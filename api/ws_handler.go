@@ -0,0 +1,431 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+	"github.com/ortin779/chirpy/db"
+	"github.com/ortin779/chirpy/entities"
+	"github.com/ortin779/chirpy/events"
+)
+
+const (
+	// wsWriteWait bounds how long writing a single frame may take.
+	wsWriteWait = 10 * time.Second
+	// wsPongWait is how long a connection may stay silent, pings are sent
+	// often enough for a pong to arrive within it.
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = wsPongWait * 9 / 10
+	// wsSendBuffer is the number of frames a client can fall behind before
+	// it is disconnected.
+	wsSendBuffer     = 256
+	wsMaxMessageSize = 4096
+	// wsAuthCheckPeriod is how often a connection checks that its access
+	// token was not revoked and its user not suspended.
+	wsAuthCheckPeriod = 30 * time.Second
+)
+
+const (
+	topicTimeline      = "timeline"
+	topicUser          = "user"
+	topicTag           = "tag"
+	topicNotifications = "notifications"
+)
+
+// wsTopic identifies a subscription, UserId is set for the user topic and
+// Tag for the tag topic.
+type wsTopic struct {
+	Topic  string `json:"topic,omitempty"`
+	UserId int    `json:"user_id,omitempty"`
+	Tag    string `json:"tag,omitempty"`
+}
+
+// wsRequest is a frame sent by the client, of type subscribe or
+// unsubscribe.
+type wsRequest struct {
+	Type string `json:"type"`
+	wsTopic
+}
+
+// wsFrame is a frame sent to the client.
+type wsFrame struct {
+	Type string `json:"type"`
+	wsTopic
+	// Chirp is the events.Event ChirpData of chirp frames.
+	Chirp       any    `json:"chirp,omitempty"`
+	UnreadCount *int   `json:"unread_count,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+type WebSocketHandler struct {
+	database db.Store
+	hub      *events.Hub
+	upgrader websocket.Upgrader
+}
+
+// NewWebSocketHandler serves WebSockets to browsers on the same origin as
+// the server or one of allowedOrigins, like "https://app.example.com".
+func NewWebSocketHandler(database db.Store, hub *events.Hub, allowedOrigins []string) WebSocketHandler {
+	return WebSocketHandler{
+		database: database,
+		hub:      hub,
+		upgrader: websocket.Upgrader{
+			CheckOrigin: checkOrigin(allowedOrigins),
+		},
+	}
+}
+
+// checkOrigin accepts requests without an Origin header, which do not come
+// from a browser, and those from the same host or one of the allowed
+// origins.
+func checkOrigin(allowedOrigins []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		if slices.ContainsFunc(allowedOrigins, func(allowed string) bool {
+			return strings.EqualFold(allowed, origin)
+		}) {
+			return true
+		}
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+}
+
+// HandleWebSocket upgrades the request and serves the topics the client
+// subscribes to until either side closes the connection. The connection
+// is closed once the access token it was opened with expires or is
+// revoked, or its user is suspended.
+func (h *WebSocketHandler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	claims, err := accessClaims(r, h.database)
+	if err != nil {
+		RespondWithError(w, 401, err.Error())
+		return
+	}
+	if claims.ExpiresAt == nil {
+		RespondWithError(w, 401, "access token does not expire")
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already responded with the error.
+		return
+	}
+
+	client := &wsClient{
+		conn:     conn,
+		database: h.database,
+		userId:   viewerId(r),
+		send:     make(chan []byte, wsSendBuffer),
+		done:     make(chan struct{}),
+		topics:   make(map[wsTopic]bool),
+	}
+	sub, _ := h.hub.Subscribe("")
+	defer sub.Close()
+
+	go client.writeLoop()
+	go client.eventLoop(sub)
+	go client.authLoop(claims)
+	client.readLoop()
+	client.close(websocket.CloseNormalClosure, "")
+}
+
+// wsClient is a single connection. readLoop handles the requests of the
+// client, eventLoop queues the events matching its topics and writeLoop is
+// the only writer to the connection.
+type wsClient struct {
+	conn     *websocket.Conn
+	database db.Store
+	userId   int
+
+	send      chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	closeMsg  []byte
+
+	mx     sync.Mutex
+	topics map[wsTopic]bool
+	// followees is loaded while the timeline topic is subscribed.
+	followees map[int]bool
+}
+
+// close makes writeLoop send a close frame with the code and close the
+// connection. Only the first call has an effect.
+func (c *wsClient) close(code int, text string) {
+	c.closeOnce.Do(func() {
+		c.closeMsg = websocket.FormatCloseMessage(code, text)
+		close(c.done)
+	})
+}
+
+// queue hands a frame to writeLoop. A client whose send buffer is full is
+// too slow to keep up and is disconnected.
+func (c *wsClient) queue(frame wsFrame) {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		log.Printf("ws: encoding frame: %v", err)
+		return
+	}
+	select {
+	case c.send <- data:
+	default:
+		c.close(websocket.ClosePolicyViolation, "slow consumer")
+	}
+}
+
+func (c *wsClient) writeLoop() {
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+	defer c.conn.Close()
+
+	for {
+		select {
+		case <-c.done:
+			c.conn.WriteControl(websocket.CloseMessage, c.closeMsg, time.Now().Add(wsWriteWait))
+			return
+		case data := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			err := c.conn.WriteMessage(websocket.TextMessage, data)
+			if err != nil {
+				c.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-ping.C:
+			err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+			if err != nil {
+				c.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		}
+	}
+}
+
+func (c *wsClient) readLoop() {
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		request := wsRequest{}
+		err = json.Unmarshal(data, &request)
+		if err != nil {
+			c.queue(wsFrame{Type: "error", Error: "invalid request"})
+			continue
+		}
+		c.handleRequest(request)
+	}
+}
+
+func (c *wsClient) handleRequest(request wsRequest) {
+	topic := request.wsTopic
+	switch topic.Topic {
+	case topicTimeline, topicNotifications:
+		topic.UserId = 0
+		topic.Tag = ""
+	case topicUser:
+		topic.Tag = ""
+		if topic.UserId <= 0 {
+			c.queue(wsFrame{Type: "error", wsTopic: request.wsTopic, Error: "user_id is required"})
+			return
+		}
+	case topicTag:
+		topic.UserId = 0
+		topic.Tag = entities.NormalizeTag(topic.Tag)
+		if topic.Tag == "" {
+			c.queue(wsFrame{Type: "error", wsTopic: request.wsTopic, Error: "tag is required"})
+			return
+		}
+	default:
+		c.queue(wsFrame{Type: "error", wsTopic: request.wsTopic, Error: "unknown topic"})
+		return
+	}
+
+	switch request.Type {
+	case "subscribe":
+		if topic.Topic == topicTimeline {
+			err := c.loadFollowees()
+			if err != nil {
+				c.queue(wsFrame{Type: "error", wsTopic: topic, Error: err.Error()})
+				return
+			}
+		}
+		c.mx.Lock()
+		c.topics[topic] = true
+		c.mx.Unlock()
+		c.queue(wsFrame{Type: "subscribed", wsTopic: topic})
+
+		if topic.Topic == topicNotifications {
+			count, err := c.database.CountUnreadNotifications(c.userId)
+			if err != nil {
+				c.queue(wsFrame{Type: "error", wsTopic: topic, Error: err.Error()})
+				return
+			}
+			c.queue(wsFrame{Type: events.TypeNotifications, wsTopic: topic, UnreadCount: &count})
+		}
+	case "unsubscribe":
+		c.mx.Lock()
+		delete(c.topics, topic)
+		c.mx.Unlock()
+		c.queue(wsFrame{Type: "unsubscribed", wsTopic: topic})
+	default:
+		c.queue(wsFrame{Type: "error", Error: "type must be subscribe or unsubscribe"})
+	}
+}
+
+// loadFollowees reads the users the client follows, for the timeline topic.
+func (c *wsClient) loadFollowees() error {
+	following, err := c.database.GetFollowing(c.userId)
+	if err != nil {
+		return err
+	}
+	followees := make(map[int]bool, len(following))
+	for _, followee := range following {
+		followees[followee.Id] = true
+	}
+
+	c.mx.Lock()
+	c.followees = followees
+	c.mx.Unlock()
+	return nil
+}
+
+// authLoop closes the connection when the access token expires, and when
+// a periodic check finds it revoked or the user suspended.
+func (c *wsClient) authLoop(claims *jwt.RegisteredClaims) {
+	expiry := time.NewTimer(time.Until(claims.ExpiresAt.Time))
+	defer expiry.Stop()
+	check := time.NewTicker(wsAuthCheckPeriod)
+	defer check.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-expiry.C:
+			c.close(websocket.ClosePolicyViolation, "access token expired")
+			return
+		case <-check.C:
+			reason, err := c.revoked(claims.ID)
+			if err != nil {
+				log.Printf("ws: checking the access token of user %d: %v", c.userId, err)
+				continue
+			}
+			if reason != "" {
+				c.close(websocket.ClosePolicyViolation, reason)
+				return
+			}
+		}
+	}
+}
+
+// revoked returns why the connection may no longer be served, or an empty
+// string.
+func (c *wsClient) revoked(jti string) (string, error) {
+	revoked, err := c.database.IsAccessTokenRevoked(jti)
+	if err != nil {
+		return "", err
+	}
+	if revoked {
+		return "access token revoked", nil
+	}
+	suspended, err := c.database.IsUserSuspended(c.userId)
+	if err != nil {
+		return "", err
+	}
+	if suspended {
+		return "account suspended", nil
+	}
+	return "", nil
+}
+
+func (c *wsClient) eventLoop(sub *events.Subscription) {
+	for {
+		select {
+		case <-c.done:
+			return
+		case event, ok := <-sub.C:
+			if !ok {
+				c.close(websocket.CloseGoingAway, "stream closed")
+				return
+			}
+			c.dispatch(event)
+		}
+	}
+}
+
+// dispatch queues a frame for every subscribed topic the event belongs to.
+func (c *wsClient) dispatch(event events.Event) {
+	switch event.Type {
+	case events.TypeChirpCreated, events.TypeChirpDeleted, events.TypeChirpRestored:
+		for _, topic := range c.matchingTopics(event) {
+			c.queue(wsFrame{Type: event.Type, wsTopic: topic, Chirp: event.ChirpData()})
+		}
+	case events.TypeNotifications:
+		topic := wsTopic{Topic: topicNotifications}
+		if event.UserId == c.userId && c.subscribed(topic) {
+			count := event.UnreadCount
+			c.queue(wsFrame{Type: event.Type, wsTopic: topic, UnreadCount: &count})
+		}
+	case events.TypeFollowing:
+		if event.UserId == c.userId && c.subscribed(wsTopic{Topic: topicTimeline}) {
+			err := c.loadFollowees()
+			if err != nil {
+				log.Printf("ws: loading followees of user %d: %v", c.userId, err)
+			}
+		}
+	}
+}
+
+func (c *wsClient) subscribed(topic wsTopic) bool {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.topics[topic]
+}
+
+func (c *wsClient) matchingTopics(event events.Event) []wsTopic {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	chirp := event.Chirp
+	matching := []wsTopic{}
+	if c.topics[wsTopic{Topic: topicTimeline}] && c.followees[chirp.AuthorId] {
+		matching = append(matching, wsTopic{Topic: topicTimeline})
+	}
+	if user := (wsTopic{Topic: topicUser, UserId: chirp.AuthorId}); c.topics[user] {
+		matching = append(matching, user)
+	}
+	if event.Type == events.TypeChirpDeleted {
+		// The tags of a deleted chirp are not passed on, so every tag
+		// topic gets the deletion.
+		for topic := range c.topics {
+			if topic.Topic == topicTag {
+				matching = append(matching, topic)
+			}
+		}
+		return matching
+	}
+	for _, tag := range entities.Tags(chirp.Entities) {
+		if topic := (wsTopic{Topic: topicTag, Tag: tag}); c.topics[topic] {
+			matching = append(matching, topic)
+		}
+	}
+	return matching
+}
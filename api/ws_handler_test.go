@@ -0,0 +1,145 @@
+package api
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ortin779/chirpy/db"
+	"github.com/ortin779/chirpy/entities"
+	"github.com/ortin779/chirpy/events"
+	"github.com/ortin779/chirpy/models"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	check := checkOrigin([]string{"https://app.example.com"})
+
+	tests := []struct {
+		name   string
+		origin string
+		want   bool
+	}{
+		{"no origin", "", true},
+		{"same host", "https://chirpy.example.com", true},
+		{"allowed origin", "https://app.example.com", true},
+		{"allowed origin in other case", "https://APP.example.com", true},
+		{"other origin", "https://evil.example.com", false},
+		{"allowed host on other scheme", "http://app.example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "https://chirpy.example.com/api/ws", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if got := check(r); got != tt.want {
+				t.Errorf("checkOrigin(%q) = %t, want %t", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
+
+// authStore is a db.Store answering only the checks of authLoop.
+type authStore struct {
+	db.Store
+	revoked   bool
+	suspended bool
+}
+
+func (s authStore) IsAccessTokenRevoked(jti string) (bool, error) {
+	return s.revoked, nil
+}
+
+func (s authStore) IsUserSuspended(userId int) (bool, error) {
+	return s.suspended, nil
+}
+
+func TestAuthLoopClosesAtExpiry(t *testing.T) {
+	client := &wsClient{database: authStore{}, userId: 1, done: make(chan struct{})}
+	go client.authLoop(&jwt.RegisteredClaims{ID: "jti", ExpiresAt: jwt.NewNumericDate(time.Now().Add(10 * time.Millisecond))})
+
+	select {
+	case <-client.done:
+	case <-time.After(time.Second):
+		t.Fatal("connection was not closed when the token expired")
+	}
+	if !strings.Contains(string(client.closeMsg), "access token expired") {
+		t.Errorf("close message = %q, want the token to have expired", client.closeMsg)
+	}
+}
+
+func TestRevoked(t *testing.T) {
+	tests := []struct {
+		name   string
+		store  authStore
+		reason string
+	}{
+		{"valid", authStore{}, ""},
+		{"revoked", authStore{revoked: true}, "access token revoked"},
+		{"suspended", authStore{suspended: true}, "account suspended"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &wsClient{database: tt.store, userId: 1}
+			reason, err := client.revoked("jti")
+			if err != nil || reason != tt.reason {
+				t.Errorf("revoked() = %q, %v, want %q", reason, err, tt.reason)
+			}
+		})
+	}
+}
+
+func TestDispatchChirps(t *testing.T) {
+	tagged := models.Chirp{Id: 1, Body: "learning #go", AuthorId: 2, Entities: entities.Parse("learning #go")}
+	topics := []wsTopic{{Topic: topicTimeline}, {Topic: topicTag, Tag: "go"}, {Topic: topicTag, Tag: "rust"}}
+
+	tests := []struct {
+		name  string
+		event events.Event
+		// frames counts the frames sent per topic.
+		frames map[string]int
+		body   bool
+	}{
+		{"created", events.Event{Type: events.TypeChirpCreated, Chirp: tagged}, map[string]int{topicTimeline: 1, topicTag: 1}, true},
+		{"restored", events.Event{Type: events.TypeChirpRestored, Chirp: tagged}, map[string]int{topicTimeline: 1, topicTag: 1}, true},
+		{"deleted", events.Event{Type: events.TypeChirpDeleted, Chirp: models.Chirp{Id: 1, AuthorId: 2}}, map[string]int{topicTimeline: 1, topicTag: 2}, false},
+		{"not followed", events.Event{Type: events.TypeChirpCreated, Chirp: models.Chirp{Id: 3, AuthorId: 4}}, map[string]int{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &wsClient{send: make(chan []byte, 8), topics: map[wsTopic]bool{}, followees: map[int]bool{2: true}}
+			for _, topic := range topics {
+				client.topics[topic] = true
+			}
+			client.dispatch(tt.event)
+			close(client.send)
+
+			frames := map[string]int{}
+			for data := range client.send {
+				frame := struct {
+					Type  string                     `json:"type"`
+					Topic string                     `json:"topic"`
+					Chirp map[string]json.RawMessage `json:"chirp"`
+				}{}
+				err := json.Unmarshal(data, &frame)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if frame.Type != tt.event.Type {
+					t.Errorf("frame type = %s, want %s", frame.Type, tt.event.Type)
+				}
+				if _, ok := frame.Chirp["body"]; ok != tt.body {
+					t.Errorf("frame %s has a body: %t, want %t", data, ok, tt.body)
+				}
+				frames[frame.Topic]++
+			}
+			if !maps.Equal(frames, tt.frames) {
+				t.Errorf("frames per topic = %v, want %v", frames, tt.frames)
+			}
+		})
+	}
+}
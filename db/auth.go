@@ -0,0 +1,161 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ortin779/chirpy/helpers"
+	"github.com/ortin779/chirpy/models"
+)
+
+// createAccessToken signs a new access token for the user and returns it
+// with its jti, under which it can be put on the denylist.
+func createAccessToken(userId string) (token string, jti string, err error) {
+	accessTokenClaims := &jwt.RegisteredClaims{
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(accessTokenExpiry)),
+		Issuer:    "chirpy-access",
+		Subject:   userId,
+		ID:        randomId(),
+	}
+
+	accessToken, err := helpers.CreateToken(accessTokenClaims)
+	if err != nil {
+		return "", "", errors.New("error while signing the token")
+	}
+	return accessToken, accessTokenClaims.ID, nil
+}
+
+// TokenLifetimes returns how long the tokens of each issuer live.
+func TokenLifetimes() map[string]time.Duration {
+	return map[string]time.Duration{
+		"chirpy-access":  accessTokenExpiry,
+		"chirpy-refresh": refreshTokenExpiry,
+	}
+}
+
+// accessTokenExpiresAt returns when the access token handed out with the
+// refresh token expires. The access token is signed first, so it expires
+// no later than this.
+func accessTokenExpiresAt(rToken models.RefreshToken) time.Time {
+	return rToken.CreatedAt.Add(accessTokenExpiry)
+}
+
+// createRefreshToken signs a new refresh token for the user and returns it
+// with the record to store for it.
+func createRefreshToken(userId int) (string, models.RefreshToken, error) {
+	now := time.Now().UTC()
+	refreshTokenClaims := &jwt.RegisteredClaims{
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(refreshTokenExpiry)),
+		Issuer:    "chirpy-refresh",
+		Subject:   strconv.Itoa(userId),
+		// Tokens issued to the same user within a second would otherwise
+		// be identical.
+		ID: randomId(),
+	}
+
+	refreshToken, err := helpers.CreateToken(refreshTokenClaims)
+	if err != nil {
+		return "", models.RefreshToken{}, errors.New("error while signing the token")
+	}
+	return refreshToken, models.RefreshToken{
+		Id:        hashToken(refreshToken),
+		UserId:    userId,
+		CreatedAt: now,
+		ExpiresAt: now.Add(refreshTokenExpiry),
+	}, nil
+}
+
+// hashToken returns the hex encoded SHA-256 hash under which a refresh
+// token is stored.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// legacyRefreshToken returns the record for a refresh token stored before
+// tokens were hashed, read from its claims. ok is false if the token
+// cannot be decoded.
+func legacyRefreshToken(token string) (rToken models.RefreshToken, ok bool) {
+	claims := &jwt.RegisteredClaims{}
+	_, _, err := jwt.NewParser().ParseUnverified(token, claims)
+	if err != nil || claims.IssuedAt == nil || claims.ExpiresAt == nil {
+		return models.RefreshToken{}, false
+	}
+	userId, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return models.RefreshToken{}, false
+	}
+	return models.RefreshToken{
+		Id:        hashToken(token),
+		UserId:    userId,
+		CreatedAt: claims.IssuedAt.UTC(),
+		ExpiresAt: claims.ExpiresAt.UTC(),
+	}, true
+}
+
+// sweepable reports whether a refresh token can be deleted: it expired, or
+// it was revoked and its family is over. Rotated tokens of live families
+// are kept until they expire so their reuse is still detected.
+func sweepable(rToken models.RefreshToken, familyRevoked bool, now time.Time) bool {
+	if !rToken.ExpiresAt.After(now) {
+		return true
+	}
+	return rToken.HasRevoked && (rToken.FamilyId == "" || familyRevoked)
+}
+
+// parseRefreshToken validates the signature and issuer of an
+// "Authorization: Bearer <token>" header value.
+func parseRefreshToken(token string) (*jwt.Token, error) {
+	parsedToken, err := helpers.ValidateToken(token)
+
+	if err != nil {
+		return nil, AuthenticationError{message: err.Error()}
+	}
+	if !parsedToken.Valid {
+		return nil, AuthenticationError{message: "invalid refresh token"}
+	}
+	issuer, err := parsedToken.Claims.GetIssuer()
+	if err != nil {
+		return nil, err
+	}
+	if issuer != "chirpy-refresh" {
+		return nil, AuthenticationError{message: "invalid refresh token issuer"}
+	}
+	return parsedToken, nil
+}
+
+// randomId returns a random 128 bit identifier, hex encoded.
+func randomId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func toSession(family models.TokenFamily, current models.RefreshToken) models.Session {
+	return models.Session{
+		Id:         family.Id,
+		UserAgent:  family.UserAgent,
+		IP:         family.IP,
+		CreatedAt:  family.CreatedAt,
+		LastUsedAt: family.LastUsedAt,
+		ExpiresAt:  current.ExpiresAt,
+	}
+}
+
+func sortSessions(sessions []models.Session) {
+	slices.SortFunc(sessions, func(a, b models.Session) int {
+		if c := b.LastUsedAt.Compare(a.LastUsedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Id, b.Id)
+	})
+}
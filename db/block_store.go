@@ -0,0 +1,94 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	. "github.com/ortin779/chirpy/models"
+)
+
+func blockKey(blockerId int, blockedId int) string {
+	return fmt.Sprintf("%d:%d", blockerId, blockedId)
+}
+
+func checkBlock(blockerId int, blockedId int) error {
+	if blockerId == blockedId {
+		return ValidationError{message: "you cannot block yourself"}
+	}
+	return nil
+}
+
+// BlockUser blocks the user for the blocker. Blocking a user twice changes
+// nothing.
+func (db *DB) BlockUser(blockerId int, blockedId int) (Block, error) {
+	block := Block{}
+
+	err := db.Update(func(tx *Tx) error {
+		err := checkBlock(blockerId, blockedId)
+		if err != nil {
+			return err
+		}
+		if _, ok := tx.Users[blockedId]; !ok {
+			return NotFoundError{}
+		}
+
+		key := blockKey(blockerId, blockedId)
+		existing, ok := tx.Blocks[key]
+		if ok {
+			block = existing
+			return nil
+		}
+
+		block = Block{BlockerId: blockerId, BlockedId: blockedId, CreatedAt: time.Now().UTC()}
+		tx.Blocks[key] = block
+		tx.Touch("blocks", key)
+		return nil
+	})
+	if err != nil {
+		return Block{}, err
+	}
+	return block, nil
+}
+
+// UnblockUser removes the block, if there is one.
+func (db *DB) UnblockUser(blockerId int, blockedId int) error {
+	return db.Update(func(tx *Tx) error {
+		if _, ok := tx.Users[blockedId]; !ok {
+			return NotFoundError{}
+		}
+
+		key := blockKey(blockerId, blockedId)
+		if _, ok := tx.Blocks[key]; !ok {
+			return nil
+		}
+		delete(tx.Blocks, key)
+		tx.Touch("blocks", key)
+		return nil
+	})
+}
+
+// GetBlocks returns the blocks of the user, most recent first.
+func (db *DB) GetBlocks(userId int) ([]Block, error) {
+	blocks := []Block{}
+
+	err := db.View(func(dbstruct *DBStructure) error {
+		for _, block := range dbstruct.Blocks {
+			if block.BlockerId == userId {
+				blocks = append(blocks, block)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(blocks, func(a, b Block) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return b.BlockedId - a.BlockedId
+	})
+	return blocks, nil
+}
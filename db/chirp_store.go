@@ -2,106 +2,472 @@ package db
 
 import (
 	"slices"
-	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ortin779/chirpy/entities"
 
 	. "github.com/ortin779/chirpy/models"
 )
 
-func (db *DB) CreateChirp(body string, authorId int) (Chirp, error) {
-	dbstruct, err := db.loadDB()
+// CreateChirp stores chirp under a new id. Only the body, author, flag and
+// references of the given chirp are used. A chirp can only reply to, quote
+// or rechirp a chirp that exists and has not been deleted, and a user
+// rechirps a chirp at most once.
+func (db *DB) CreateChirp(chirp Chirp) (Chirp, error) {
+	newChirp := storedChirp(chirp)
+
+	err := db.Update(func(tx *Tx) error {
+		err := checkReferences(&newChirp, func(id int) (Chirp, error) {
+			return liveChirp(tx.DBStructure, id)
+		})
+		if err != nil {
+			return err
+		}
+
+		newChirp.Entities, err = parseEntities(newChirp.Body, tx.mentionedUser)
+		if err != nil {
+			return err
+		}
+
+		if newChirp.RechirpOf != 0 && hasRechirped(tx.DBStructure, newChirp) {
+			return alreadyRechirpedError
+		}
+
+		adjustReplyCount(tx, newChirp.ReplyTo, 1)
+
+		nextIndex := tx.nextId("chirps")
+		now := time.Now().UTC()
+
+		newChirp.Id = nextIndex
+		newChirp.CreatedAt = now
+		newChirp.UpdatedAt = now
+		tx.Chirps[nextIndex] = newChirp
+		tx.Touch("chirps", nextIndex)
+		tx.indexChirp(newChirp)
+		original := tx.Chirps[max(newChirp.QuoteOf, newChirp.RechirpOf)]
+		tx.notify(chirpNotifications(newChirp, tx.Chirps[newChirp.ReplyTo].AuthorId, original.AuthorId)...)
+		return nil
+	})
 	if err != nil {
 		return Chirp{}, err
 	}
+	return newChirp, nil
+}
 
-	nextIndex := 1
-
-	if len(dbstruct.Chirps) > 0 {
-		keys := getSortedKeys(dbstruct.Chirps)
-		nextIndex = keys[0] + 1
+func (db *DB) GetChirps(query ChirpQuery) (ChirpPage, error) {
+	after, err := DecodeCursor(query.Cursor)
+	if err != nil {
+		return ChirpPage{}, err
 	}
+	order := chirpOrder(query.Sort)
+
+	chirps := []Chirp{}
+	err = db.View(func(dbstruct *DBStructure) error {
+		followees := followeeSet(dbstruct, query.FollowedBy)
 
-	newChirp := Chirp{
-		Id:       nextIndex,
-		Body:     body,
-		AuthorId: authorId,
+		matches := func(chirp Chirp) bool {
+			if chirp.DeletedAt != nil {
+				return false
+			}
+			if query.AuthorId != 0 && chirp.AuthorId != query.AuthorId {
+				return false
+			}
+			if query.ReplyTo != 0 && chirp.ReplyTo != query.ReplyTo {
+				return false
+			}
+			if followees != nil && !followees[chirp.AuthorId] {
+				return false
+			}
+			if query.FlaggedOnly && !chirp.Flagged {
+				return false
+			}
+			if !inTimeRange(chirp, query) {
+				return false
+			}
+			return after == nil || order(*after, chirp) < 0
+		}
+
+		// With a tag only the chirps in its index are considered.
+		each := func(fn func(chirp Chirp)) {
+			if query.Tag == "" {
+				for _, chirp := range dbstruct.Chirps {
+					fn(chirp)
+				}
+				return
+			}
+			for id := range dbstruct.tags[query.Tag] {
+				fn(dbstruct.Chirps[id])
+			}
+		}
+
+		if query.Limit == 0 {
+			each(func(chirp Chirp) {
+				if matches(chirp) {
+					chirps = append(chirps, chirp)
+				}
+			})
+			slices.SortFunc(chirps, order)
+			return nil
+		}
+
+		collector := &pageCollector{n: query.Limit + 1, order: order}
+		each(func(chirp Chirp) {
+			if matches(chirp) {
+				collector.add(chirp)
+			}
+		})
+		chirps = collector.sorted()
+		return nil
+	})
+	if err != nil {
+		return ChirpPage{}, err
 	}
-	dbstruct.Chirps[nextIndex] = newChirp
-	err = db.writeDB(dbstruct)
+
+	return toPage(chirps, query.Limit), nil
+}
+
+func (db *DB) GetChirp(id int) (Chirp, error) {
+	chirp := Chirp{}
+
+	err := db.View(func(dbstruct *DBStructure) error {
+		var err error
+		chirp, err = liveChirp(dbstruct, id)
+		return err
+	})
 	if err != nil {
 		return Chirp{}, err
 	}
-	return newChirp, nil
+	return chirp, nil
 }
 
-func (db *DB) GetChirps(authorId string, sort string) ([]Chirp, error) {
-	dbstruct, err := db.loadDB()
+// GetChirpsByIds returns the chirps with the given ids that exist and have
+// not been deleted.
+func (db *DB) GetChirpsByIds(ids []int) (map[int]Chirp, error) {
+	chirps := make(map[int]Chirp)
+
+	err := db.View(func(dbstruct *DBStructure) error {
+		for _, id := range ids {
+			chirp, ok := dbstruct.Chirps[id]
+			if ok && chirp.DeletedAt == nil {
+				chirps[id] = chirp
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return []Chirp{}, err
+		return nil, err
 	}
+	return chirps, nil
+}
 
-	keys := getSortedKeys(dbstruct.Chirps)
-	chirps := make([]Chirp, 0, len(keys))
+// DeleteChirp turns the chirp into a tombstone, it is removed for good by
+// PurgeDeletedChirps.
+func (db *DB) DeleteChirp(id int, authorId int) (Chirp, error) {
+	chirp := Chirp{}
 
-	if authorId == "" {
-		for _, key := range keys {
-			chirps = append(chirps, dbstruct.Chirps[key])
-		}
-	} else {
-		parsedId, err := strconv.Atoi(authorId)
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		chirp, err = liveChirp(tx.DBStructure, id)
 		if err != nil {
-			return []Chirp{}, err
+			return err
 		}
-		for _, v := range keys {
-			chirp := dbstruct.Chirps[v]
-			if chirp.AuthorId == parsedId {
-				chirps = append(chirps, chirp)
-			}
+
+		if chirp.AuthorId != authorId {
+			return AuthorizationError{message: "you are not the author"}
 		}
+
+		deletedAt := time.Now().UTC()
+		chirp.DeletedAt = &deletedAt
+		tx.Chirps[id] = chirp
+		tx.Touch("chirps", id)
+		adjustReplyCount(tx, chirp.ReplyTo, -1)
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
 	}
+	return chirp, nil
+}
+
+// UpdateChirp replaces the body of a chirp and records the previous body in
+// its history. Only the author may edit a chirp.
+func (db *DB) UpdateChirp(id int, authorId int, body string, flagged bool) (Chirp, error) {
+	chirp := Chirp{}
+
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		chirp, err = liveChirp(tx.DBStructure, id)
+		if err != nil {
+			return err
+		}
 
-	slices.SortFunc(chirps, func(a, b Chirp) int {
-		if sort == "asc" {
-			return a.Id - b.Id
-		} else {
-			return b.Id - a.Id
+		if chirp.AuthorId != authorId {
+			return AuthorizationError{message: "you are not the author"}
+		}
+
+		if chirp.RechirpOf != 0 {
+			return ValidationError{message: "rechirps cannot be edited"}
+		}
+
+		found, err := parseEntities(body, tx.mentionedUser)
+		if err != nil {
+			return err
 		}
+
+		// Readers may still hold the old slice, so never append to it in
+		// place.
+		history := slices.Clone(tx.ChirpHistory[id])
+		tx.ChirpHistory[id] = append(history, ChirpVersion{
+			Version:   len(history) + 1,
+			Body:      chirp.Body,
+			CreatedAt: chirp.UpdatedAt,
+		})
+		tx.Touch("chirp_history", id)
+
+		tx.unindexChirp(chirp)
+		chirp.Body = body
+		chirp.Entities = found
+		chirp.Flagged = flagged
+		chirp.UpdatedAt = time.Now().UTC()
+		tx.Chirps[id] = chirp
+		tx.Touch("chirps", id)
+		tx.indexChirp(chirp)
+		return nil
 	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
 
-	return chirps, nil
+// GetChirpHistory returns every version of a chirp, oldest first. The last
+// entry is the current body.
+func (db *DB) GetChirpHistory(id int) ([]ChirpVersion, error) {
+	versions := []ChirpVersion{}
+
+	err := db.View(func(dbstruct *DBStructure) error {
+		chirp, err := liveChirp(dbstruct, id)
+		if err != nil {
+			return err
+		}
 
+		history := dbstruct.ChirpHistory[id]
+		versions = append(slices.Clone(history), ChirpVersion{
+			Version:   len(history) + 1,
+			Body:      chirp.Body,
+			CreatedAt: chirp.UpdatedAt,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
 }
 
-func (db *DB) GetChirp(id int) (Chirp, error) {
-	dbstruct, err := db.loadDB()
+// RestoreChirp brings back a deleted chirp if it was deleted less than
+// window ago. Only the author may restore a chirp.
+func (db *DB) RestoreChirp(id int, authorId int, window time.Duration) (Chirp, error) {
+	chirp := Chirp{}
+
+	err := db.Update(func(tx *Tx) error {
+		var ok bool
+		chirp, ok = tx.Chirps[id]
+
+		if !ok {
+			return NotFoundError{}
+		}
+
+		if chirp.AuthorId != authorId {
+			return AuthorizationError{message: "you are not the author"}
+		}
+
+		err := checkRestorable(chirp, window)
+		if err != nil {
+			return err
+		}
+		// The user may have rechirped the same chirp again in the meantime.
+		if chirp.RechirpOf != 0 && hasRechirped(tx.DBStructure, chirp) {
+			return alreadyRechirpedError
+		}
+
+		chirp.DeletedAt = nil
+		tx.Chirps[id] = chirp
+		tx.Touch("chirps", id)
+		adjustReplyCount(tx, chirp.ReplyTo, 1)
+		return nil
+	})
 	if err != nil {
 		return Chirp{}, err
 	}
+	return chirp, nil
+}
 
-	chirp, ok := dbstruct.Chirps[id]
-	if !ok {
-		return Chirp{}, NotFoundError{}
+// PurgeDeletedChirps removes the chirps deleted before the given time along
+// with their history and likes and returns how many were removed.
+func (db *DB) PurgeDeletedChirps(before time.Time) (int, error) {
+	purged := 0
+
+	err := db.Update(func(tx *Tx) error {
+		for id, chirp := range tx.Chirps {
+			if chirp.DeletedAt == nil || !chirp.DeletedAt.Before(before) {
+				continue
+			}
+
+			delete(tx.Chirps, id)
+			tx.Touch("chirps", id)
+			tx.unindexChirp(chirp)
+			delete(tx.ChirpHistory, id)
+			tx.Touch("chirp_history", id)
+			purged++
+		}
+		if purged == 0 {
+			return nil
+		}
+
+		for key, like := range tx.Likes {
+			if _, ok := tx.Chirps[like.ChirpId]; !ok {
+				delete(tx.Likes, key)
+				tx.Touch("likes", key)
+			}
+		}
+		for id, notification := range tx.Notifications {
+			if _, ok := tx.Chirps[notification.ChirpId]; notification.ChirpId != 0 && !ok {
+				delete(tx.Notifications, id)
+				tx.Touch("notifications", id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
 	}
-	return chirp, nil
+	return purged, nil
 }
 
-func (db *DB) DeleteChirp(id int, authorId int) (Chirp, error) {
-	dbstruct, err := db.loadDB()
+// GetThread returns the chirp with the given id, the chirps it replies to
+// and every reply below it.
+func (db *DB) GetThread(id int) (ChirpThread, error) {
+	thread := ChirpThread{}
+
+	err := db.View(func(dbstruct *DBStructure) error {
+		chirp, err := liveChirp(dbstruct, id)
+		if err != nil {
+			return err
+		}
+
+		ancestors := []Chirp{}
+		for parentId := chirp.ReplyTo; parentId != 0; {
+			parent, ok := dbstruct.Chirps[parentId]
+			if !ok {
+				break
+			}
+			ancestors = append(ancestors, parent)
+			parentId = parent.ReplyTo
+		}
+
+		replies := make(map[int][]Chirp)
+		for _, reply := range dbstruct.Chirps {
+			if reply.ReplyTo != 0 {
+				replies[reply.ReplyTo] = append(replies[reply.ReplyTo], reply)
+			}
+		}
+		descendants := []Chirp{}
+		queue := []int{id}
+		for len(queue) > 0 {
+			for _, reply := range replies[queue[0]] {
+				descendants = append(descendants, reply)
+				queue = append(queue, reply.Id)
+			}
+			queue = queue[1:]
+		}
+
+		thread = buildThread(chirp, ancestors, descendants)
+		return nil
+	})
 	if err != nil {
-		return Chirp{}, err
+		return ChirpThread{}, err
 	}
+	return thread, nil
+}
 
-	chirp, ok := dbstruct.Chirps[id]
+// GetTrendingTags returns the hashtags used by the most chirps created since
+// the given time, ties in alphabetical order.
+func (db *DB) GetTrendingTags(since time.Time, limit int) ([]TagCount, error) {
+	counts := make(map[string]int)
 
-	if !ok {
-		return Chirp{}, NotFoundError{}
+	err := db.View(func(dbstruct *DBStructure) error {
+		for _, chirp := range dbstruct.Chirps {
+			if chirp.DeletedAt != nil || chirp.CreatedAt.Before(since) {
+				continue
+			}
+			for _, tag := range entities.Tags(chirp.Entities) {
+				counts[tag]++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	if chirp.AuthorId != authorId {
-		return Chirp{}, AuthorizationError{message: "you are not the author"}
+	trending := make([]TagCount, 0, len(counts))
+	for tag, count := range counts {
+		trending = append(trending, TagCount{Tag: tag, Count: count})
 	}
+	slices.SortFunc(trending, func(a, b TagCount) int {
+		if a.Count != b.Count {
+			return b.Count - a.Count
+		}
+		return strings.Compare(a.Tag, b.Tag)
+	})
+	return trending[:min(len(trending), limit)], nil
+}
 
-	delete(dbstruct.Chirps, id)
+// adjustReplyCount adds delta to the reply count of the chirp with the
+// given id, if it still exists.
+func adjustReplyCount(tx *Tx, id int, delta int) {
+	parent, ok := tx.Chirps[id]
+	if id == 0 || !ok {
+		return
+	}
+	parent.ReplyCount += delta
+	tx.Chirps[id] = parent
+	tx.Touch("chirps", id)
+}
 
-	db.writeDB(dbstruct)
+// liveChirp returns the chirp with the given id unless it does not exist or
+// has been deleted.
+func liveChirp(dbstruct *DBStructure, id int) (Chirp, error) {
+	chirp, ok := dbstruct.Chirps[id]
+	if !ok {
+		return Chirp{}, NotFoundError{}
+	}
+	if chirp.DeletedAt != nil {
+		return Chirp{}, GoneError{message: "chirp has been deleted"}
+	}
 	return chirp, nil
 }
+
+// hasRechirped reports whether the author of the rechirp has another live
+// rechirp of the same chirp.
+func hasRechirped(dbstruct *DBStructure, rechirp Chirp) bool {
+	for _, existing := range dbstruct.Chirps {
+		if existing.Id != rechirp.Id && existing.AuthorId == rechirp.AuthorId &&
+			existing.RechirpOf == rechirp.RechirpOf && existing.DeletedAt == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func checkRestorable(chirp Chirp, window time.Duration) error {
+	if chirp.DeletedAt == nil {
+		return ValidationError{message: "chirp is not deleted"}
+	}
+	if time.Since(*chirp.DeletedAt) > window {
+		return GoneError{message: "restore window has expired"}
+	}
+	return nil
+}
@@ -0,0 +1,380 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ortin779/chirpy/models"
+)
+
+func TestCreateChirpNeverReusesPurgedIds(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store, reopen func() Store) {
+		for i := 1; i <= 3; i++ {
+			mustCreateChirp(t, store, models.Chirp{Body: "hello", AuthorId: 1})
+		}
+		_, err := store.DeleteChirp(3, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		purged, err := store.PurgeDeletedChirps(time.Now().Add(time.Minute))
+		if err != nil || purged != 1 {
+			t.Fatalf("PurgeDeletedChirps() = %d, %v, want 1", purged, err)
+		}
+
+		if chirp := mustCreateChirp(t, store, models.Chirp{Body: "after purge", AuthorId: 1}); chirp.Id != 4 {
+			t.Errorf("chirp created after the purge got id %d, want 4", chirp.Id)
+		}
+		store = reopen()
+		if chirp := mustCreateChirp(t, store, models.Chirp{Body: "after reopen", AuthorId: 1}); chirp.Id != 5 {
+			t.Errorf("chirp created after reopening got id %d, want 5", chirp.Id)
+		}
+	})
+}
+
+func TestRestoreChirpKeepsOneRechirpPerUser(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store, _ func() Store) {
+		original := mustCreateChirp(t, store, models.Chirp{Body: "original", AuthorId: 1})
+		first := mustCreateChirp(t, store, models.Chirp{AuthorId: 2, RechirpOf: original.Id})
+		_, err := store.DeleteChirp(first.Id, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mustCreateChirp(t, store, models.Chirp{AuthorId: 2, RechirpOf: original.Id})
+
+		_, err = store.RestoreChirp(first.Id, 2, time.Hour)
+		if !errors.As(err, &ValidationError{}) {
+			t.Fatalf("RestoreChirp() error = %v, want a ValidationError", err)
+		}
+		if _, err = store.GetChirp(first.Id); !errors.As(err, &GoneError{}) {
+			t.Errorf("GetChirp() error = %v, want the rechirp to stay deleted", err)
+		}
+	})
+}
+
+func TestGetChirpsPagesWithCursor(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store, _ func() Store) {
+		for i := 1; i <= 5; i++ {
+			mustCreateChirp(t, store, models.Chirp{Body: "hello", AuthorId: 1})
+		}
+		mustCreateChirp(t, store, models.Chirp{Body: "other author", AuthorId: 2})
+
+		for _, sort := range []string{"asc", "desc", "created_at", "-created_at"} {
+			got := allPages(t, store, models.ChirpQuery{AuthorId: 1, Sort: sort, Limit: 2})
+			want := [][]int{{1, 2}, {3, 4}, {5}}
+			if sort == "desc" || sort == "-created_at" {
+				want = [][]int{{5, 4}, {3, 2}, {1}}
+			}
+			if !slices.EqualFunc(got, want, slices.Equal) {
+				t.Errorf("pages sorted by %s = %v, want %v", sort, got, want)
+			}
+		}
+
+		// Chirps written after the first page was read do not shift the
+		// pages that follow it.
+		first, err := store.GetChirps(models.ChirpQuery{AuthorId: 1, Sort: "desc", Limit: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mustCreateChirp(t, store, models.Chirp{Body: "newer", AuthorId: 1})
+		second, err := store.GetChirps(models.ChirpQuery{AuthorId: 1, Sort: "desc", Limit: 2, Cursor: first.NextCursor})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := chirpIds(second.Chirps); !slices.Equal(got, []int{3, 2}) {
+			t.Errorf("second page = %v, want [3 2]", got)
+		}
+
+		// Without a limit every chirp is returned on a single page.
+		all, err := store.GetChirps(models.ChirpQuery{Sort: "asc"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all.Chirps) != 7 || all.NextCursor != "" {
+			t.Errorf("unlimited page = %v, cursor %q, want all 7 chirps", chirpIds(all.Chirps), all.NextCursor)
+		}
+
+		for _, cursor := range []string{"not base64!", "e30"} {
+			_, err = store.GetChirps(models.ChirpQuery{Sort: "asc", Limit: 2, Cursor: cursor})
+			if !errors.As(err, &ValidationError{}) {
+				t.Errorf("GetChirps() with cursor %q error = %v, want a ValidationError", cursor, err)
+			}
+		}
+	})
+}
+
+func TestGetChirpsFiltersByTimeRange(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store, reopen func() Store) {
+		created := []models.Chirp{}
+		for i := 0; i < 3; i++ {
+			created = append(created, mustCreateChirp(t, store, models.Chirp{Body: "hello", AuthorId: 1}))
+			time.Sleep(time.Millisecond)
+		}
+		first, second, third := created[0].CreatedAt, created[1].CreatedAt, created[2].CreatedAt
+
+		tests := []struct {
+			name  string
+			since time.Time
+			until time.Time
+			want  []int
+		}{
+			{name: "open", want: []int{1, 2, 3}},
+			{name: "since is inclusive", since: second, want: []int{2, 3}},
+			{name: "until is exclusive", until: second, want: []int{1}},
+			{name: "both", since: first, until: third, want: []int{1, 2}},
+			{name: "empty", since: second, until: second, want: []int{}},
+			{name: "in the future", since: third.Add(time.Hour), want: []int{}},
+		}
+		// The timestamps are read back from disk unchanged.
+		store = reopen()
+		for _, tt := range tests {
+			page, err := store.GetChirps(models.ChirpQuery{Since: tt.since, Until: tt.until, Sort: "created_at"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := chirpIds(page.Chirps); !slices.Equal(got, tt.want) {
+				t.Errorf("%s: GetChirps() = %v, want %v", tt.name, got, tt.want)
+			}
+		}
+
+		got := allPages(t, store, models.ChirpQuery{Since: second, Sort: "-created_at", Limit: 1})
+		if want := [][]int{{3}, {2}}; !slices.EqualFunc(got, want, slices.Equal) {
+			t.Errorf("pages since the second chirp = %v, want %v", got, want)
+		}
+	})
+}
+
+func TestUpdateChirpKeepsHistory(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store, reopen func() Store) {
+		chirp := mustCreateChirp(t, store, models.Chirp{Body: "first", AuthorId: 1})
+		for _, body := range []string{"second", "third"} {
+			updated, err := store.UpdateChirp(chirp.Id, 1, body, body == "third")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if updated.Body != body || !updated.UpdatedAt.After(updated.CreatedAt) {
+				t.Errorf("UpdateChirp() = %q updated at %v, want %q updated after %v", updated.Body, updated.UpdatedAt, body, updated.CreatedAt)
+			}
+			chirp = updated
+		}
+		if !chirp.Flagged {
+			t.Error("edit did not set the flag")
+		}
+
+		_, err := store.UpdateChirp(chirp.Id, 2, "not mine", false)
+		if !errors.As(err, &AuthorizationError{}) {
+			t.Errorf("UpdateChirp() by another user error = %v, want an AuthorizationError", err)
+		}
+		rechirp := mustCreateChirp(t, store, models.Chirp{AuthorId: 1, RechirpOf: chirp.Id})
+		_, err = store.UpdateChirp(rechirp.Id, 1, "body", false)
+		if !errors.As(err, &ValidationError{}) {
+			t.Errorf("UpdateChirp() of a rechirp error = %v, want a ValidationError", err)
+		}
+
+		store = reopen()
+		versions, err := store.GetChirpHistory(chirp.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodies := []string{}
+		for i, version := range versions {
+			if version.Version != i+1 {
+				t.Errorf("version %d is numbered %d", i+1, version.Version)
+			}
+			if i > 0 && !version.CreatedAt.After(versions[i-1].CreatedAt) {
+				t.Errorf("version %d was written at %v, not after the version before it", version.Version, version.CreatedAt)
+			}
+			bodies = append(bodies, version.Body)
+		}
+		if want := []string{"first", "second", "third"}; !slices.Equal(bodies, want) {
+			t.Fatalf("history = %v, want %v", bodies, want)
+		}
+		if !versions[0].CreatedAt.Equal(chirp.CreatedAt) || !versions[2].CreatedAt.Equal(chirp.UpdatedAt) {
+			t.Errorf("history runs from %v to %v, want %v to %v", versions[0].CreatedAt, versions[2].CreatedAt, chirp.CreatedAt, chirp.UpdatedAt)
+		}
+
+		_, err = store.DeleteChirp(chirp.Id, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = store.GetChirpHistory(chirp.Id)
+		if !errors.As(err, &GoneError{}) {
+			t.Errorf("GetChirpHistory() of a deleted chirp error = %v, want a GoneError", err)
+		}
+		_, err = store.GetChirpHistory(100)
+		if !errors.Is(err, NotFoundError{}) {
+			t.Errorf("GetChirpHistory() of a missing chirp error = %v, want NotFoundError", err)
+		}
+	})
+}
+
+func TestGetThread(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store, _ func() Store) {
+		// 2 and 3 reply to 1, 4 to 2, 5 to 4 and 6 to 3.
+		for _, replyTo := range []int{0, 1, 1, 2, 4, 3} {
+			mustCreateChirp(t, store, models.Chirp{Body: "hello", AuthorId: 1, ReplyTo: replyTo})
+		}
+
+		tests := []struct {
+			id        int
+			ancestors []int
+			shape     string
+		}{
+			{id: 1, ancestors: []int{}, shape: "1(2(4(5)) 3(6))"},
+			{id: 4, ancestors: []int{1, 2}, shape: "4(5)"},
+			{id: 5, ancestors: []int{1, 2, 4}, shape: "5"},
+		}
+		for _, tt := range tests {
+			thread, err := store.GetThread(tt.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := chirpIds(thread.Ancestors); !slices.Equal(got, tt.ancestors) {
+				t.Errorf("ancestors of %d = %v, want %v", tt.id, got, tt.ancestors)
+			}
+			if got := threadShape(thread.Chirp); got != tt.shape {
+				t.Errorf("thread of %d = %s, want %s", tt.id, got, tt.shape)
+			}
+		}
+
+		// The replies of a deleted chirp move up to its parent.
+		_, err := store.DeleteChirp(2, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		thread, err := store.GetThread(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := threadShape(thread.Chirp); got != "1(3(6) 4(5))" {
+			t.Errorf("thread after deleting 2 = %s, want 1(3(6) 4(5))", got)
+		}
+		if thread.Chirp.ReplyCount != 1 {
+			t.Errorf("reply count after deleting a reply = %d, want 1", thread.Chirp.ReplyCount)
+		}
+		thread, err = store.GetThread(5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := chirpIds(thread.Ancestors); !slices.Equal(got, []int{1, 4}) {
+			t.Errorf("ancestors of 5 after deleting 2 = %v, want [1 4]", got)
+		}
+
+		_, err = store.GetThread(2)
+		if !errors.As(err, &GoneError{}) {
+			t.Errorf("GetThread() of a deleted chirp error = %v, want a GoneError", err)
+		}
+		_, err = store.CreateChirp(models.Chirp{Body: "late reply", AuthorId: 1, ReplyTo: 2})
+		if !errors.As(err, &ValidationError{}) {
+			t.Errorf("replying to a deleted chirp error = %v, want a ValidationError", err)
+		}
+	})
+}
+
+func TestRechirpsAndQuotes(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store, _ func() Store) {
+		original := mustCreateChirp(t, store, models.Chirp{Body: "original", AuthorId: 1})
+		rechirp := mustCreateChirp(t, store, models.Chirp{AuthorId: 2, RechirpOf: original.Id})
+
+		// Rechirps and quotes of a rechirp refer to its original.
+		again := mustCreateChirp(t, store, models.Chirp{AuthorId: 3, RechirpOf: rechirp.Id})
+		quote := mustCreateChirp(t, store, models.Chirp{Body: "quoting", AuthorId: 3, QuoteOf: rechirp.Id})
+		if again.RechirpOf != original.Id || quote.QuoteOf != original.Id {
+			t.Errorf("rechirp of a rechirp refers to %d and quote to %d, want %d", again.RechirpOf, quote.QuoteOf, original.Id)
+		}
+
+		invalid := []struct {
+			name  string
+			chirp models.Chirp
+		}{
+			{name: "rechirp with a body", chirp: models.Chirp{Body: "body", AuthorId: 4, RechirpOf: original.Id}},
+			{name: "rechirp with a quote", chirp: models.Chirp{AuthorId: 4, RechirpOf: original.Id, QuoteOf: original.Id}},
+			{name: "rechirp of a missing chirp", chirp: models.Chirp{AuthorId: 4, RechirpOf: 100}},
+			{name: "quote of a missing chirp", chirp: models.Chirp{Body: "quoting", AuthorId: 4, QuoteOf: 100}},
+			{name: "second rechirp", chirp: models.Chirp{AuthorId: 2, RechirpOf: original.Id}},
+			{name: "second rechirp through a rechirp", chirp: models.Chirp{AuthorId: 2, RechirpOf: again.Id}},
+		}
+		for _, tt := range invalid {
+			_, err := store.CreateChirp(tt.chirp)
+			if !errors.As(err, &ValidationError{}) {
+				t.Errorf("%s: CreateChirp() error = %v, want a ValidationError", tt.name, err)
+			}
+		}
+
+		// Deleting the rechirp allows rechirping again.
+		_, err := store.DeleteChirp(rechirp.Id, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mustCreateChirp(t, store, models.Chirp{AuthorId: 2, RechirpOf: original.Id})
+
+		// Once the original is deleted it is left out of the originals and
+		// cannot be shared anymore.
+		_, err = store.DeleteChirp(original.Id, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		originals, err := store.GetChirpsByIds([]int{original.Id, quote.Id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := originals[original.Id]; ok || len(originals) != 1 {
+			t.Errorf("GetChirpsByIds() = %v, want only the quote", originals)
+		}
+		_, err = store.CreateChirp(models.Chirp{Body: "quoting", AuthorId: 4, QuoteOf: original.Id})
+		if !errors.As(err, &ValidationError{}) {
+			t.Errorf("quoting a deleted chirp error = %v, want a ValidationError", err)
+		}
+	})
+}
+
+func mustCreateChirp(t *testing.T, store Store, chirp models.Chirp) models.Chirp {
+	t.Helper()
+	created, err := store.CreateChirp(chirp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return created
+}
+
+// allPages follows the cursors of query to the last page and returns the
+// ids of the chirps on every page.
+func allPages(t *testing.T, store Store, query models.ChirpQuery) [][]int {
+	t.Helper()
+	pages := [][]int{}
+	for {
+		page, err := store.GetChirps(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, chirpIds(page.Chirps))
+		if page.NextCursor == "" {
+			return pages
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
+func chirpIds(chirps []models.Chirp) []int {
+	ids := make([]int, 0, len(chirps))
+	for _, chirp := range chirps {
+		ids = append(ids, chirp.Id)
+	}
+	return ids
+}
+
+// threadShape writes the ids of a thread as "id(reply reply)".
+func threadShape(node models.ChirpNode) string {
+	if len(node.Replies) == 0 {
+		return strconv.Itoa(node.Id)
+	}
+	replies := []string{}
+	for _, reply := range node.Replies {
+		replies = append(replies, threadShape(reply))
+	}
+	return fmt.Sprintf("%d(%s)", node.Id, strings.Join(replies, " "))
+}
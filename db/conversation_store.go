@@ -0,0 +1,355 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	. "github.com/ortin779/chirpy/models"
+)
+
+const (
+	maxConversationMembers = 50
+	maxMessageLength       = 1000
+)
+
+// conversationMembers returns the sorted, distinct ids of the members of a
+// new conversation, the creator included.
+func conversationMembers(creatorId int, memberIds []int) ([]int, error) {
+	members := append([]int{creatorId}, memberIds...)
+	slices.Sort(members)
+	members = slices.Compact(members)
+
+	if len(members) < 2 {
+		return nil, ValidationError{message: "a conversation needs at least one other member"}
+	}
+	if len(members) > maxConversationMembers {
+		return nil, ValidationError{message: fmt.Sprintf("a conversation can have at most %d members", maxConversationMembers)}
+	}
+	return members, nil
+}
+
+func checkMessageBody(body string) error {
+	if strings.TrimSpace(body) == "" {
+		return ValidationError{message: "message is empty"}
+	}
+	if utf8.RuneCountInString(body) > maxMessageLength {
+		return ValidationError{message: fmt.Sprintf("message is longer than %d characters", maxMessageLength)}
+	}
+	return nil
+}
+
+// memberIndex returns the index of the user among the members of the
+// conversation, or an AuthorizationError if they are not a member.
+func memberIndex(conversation Conversation, userId int) (int, error) {
+	idx := slices.IndexFunc(conversation.Members, func(member ConversationMember) bool {
+		return member.UserId == userId
+	})
+	if idx == -1 {
+		return -1, AuthorizationError{message: "you are not a member of this conversation"}
+	}
+	return idx, nil
+}
+
+// blockedError is returned when a block between the user and another
+// member prevents messaging.
+func blockedError(userId int) error {
+	return AuthorizationError{message: fmt.Sprintf("you cannot message user %d", userId)}
+}
+
+// membersBlockedError is returned when two members of a new conversation
+// other than its creator blocked one another. It does not say which, the
+// creator is not told about blocks between others.
+var membersBlockedError = AuthorizationError{message: "these users cannot be in a conversation together"}
+
+func (dbstruct *DBStructure) blocked(a int, b int) bool {
+	_, ab := dbstruct.Blocks[blockKey(a, b)]
+	_, ba := dbstruct.Blocks[blockKey(b, a)]
+	return ab || ba
+}
+
+// checkBlocks fails if the user and any of the other members blocked one
+// another.
+func (dbstruct *DBStructure) checkBlocks(userId int, memberIds []int) error {
+	for _, memberId := range memberIds {
+		if memberId != userId && dbstruct.blocked(userId, memberId) {
+			return blockedError(memberId)
+		}
+	}
+	return nil
+}
+
+// checkMemberBlocks fails if any two members of a new conversation blocked
+// one another.
+func (dbstruct *DBStructure) checkMemberBlocks(creatorId int, members []int) error {
+	err := dbstruct.checkBlocks(creatorId, members)
+	if err != nil {
+		return err
+	}
+	for i, a := range members {
+		for _, b := range members[i+1:] {
+			if dbstruct.blocked(a, b) {
+				return membersBlockedError
+			}
+		}
+	}
+	return nil
+}
+
+// memberConversation returns the conversation if the user is a member.
+func (dbstruct *DBStructure) memberConversation(id int, userId int) (Conversation, int, error) {
+	conversation, ok := dbstruct.Conversations[id]
+	if !ok {
+		return Conversation{}, -1, NotFoundError{}
+	}
+	idx, err := memberIndex(conversation, userId)
+	if err != nil {
+		return Conversation{}, -1, err
+	}
+	return conversation, idx, nil
+}
+
+// describeConversations fills in the last message and unread count of the
+// conversations for the user.
+func (dbstruct *DBStructure) describeConversations(conversations []Conversation, userId int) {
+	positions := make(map[int]int, len(conversations))
+	lastRead := make(map[int]int, len(conversations))
+	for i, conversation := range conversations {
+		positions[conversation.Id] = i
+		if idx, err := memberIndex(conversation, userId); err == nil {
+			lastRead[conversation.Id] = conversation.Members[idx].LastReadMessageId
+		}
+	}
+
+	for _, message := range dbstruct.Messages {
+		i, ok := positions[message.ConversationId]
+		if !ok {
+			continue
+		}
+		conversation := &conversations[i]
+		if conversation.LastMessage == nil || message.Id > conversation.LastMessage.Id {
+			last := message
+			conversation.LastMessage = &last
+		}
+		if message.Id > lastRead[message.ConversationId] && message.SenderId != userId {
+			conversation.UnreadCount++
+		}
+	}
+}
+
+// CreateConversation starts a conversation between the creator and the
+// members. A conversation between two users is only started once, starting
+// it again returns the existing one and created is false.
+func (db *DB) CreateConversation(creatorId int, memberIds []int) (conversation Conversation, created bool, err error) {
+	members, err := conversationMembers(creatorId, memberIds)
+	if err != nil {
+		return Conversation{}, false, err
+	}
+
+	err = db.Update(func(tx *Tx) error {
+		for _, memberId := range members {
+			if _, ok := tx.Users[memberId]; !ok {
+				return ValidationError{message: fmt.Sprintf("user %d does not exist", memberId)}
+			}
+		}
+		err := tx.checkMemberBlocks(creatorId, members)
+		if err != nil {
+			return err
+		}
+
+		if len(members) == 2 {
+			for _, existing := range tx.Conversations {
+				if len(existing.Members) == 2 &&
+					existing.Members[0].UserId == members[0] && existing.Members[1].UserId == members[1] {
+					conversation = existing
+					return nil
+				}
+			}
+		}
+
+		now := time.Now().UTC()
+		conversation = Conversation{
+			Id:        tx.nextId("conversations"),
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		for _, memberId := range members {
+			conversation.Members = append(conversation.Members, ConversationMember{UserId: memberId, JoinedAt: now})
+		}
+		tx.Conversations[conversation.Id] = conversation
+		tx.Touch("conversations", conversation.Id)
+		created = true
+		return nil
+	})
+	if err != nil {
+		return Conversation{}, false, err
+	}
+
+	if !created {
+		conversation, err = db.GetConversation(conversation.Id, creatorId)
+	}
+	return conversation, created, err
+}
+
+// GetConversations returns the conversations of the user, the one with the
+// most recent message first.
+func (db *DB) GetConversations(userId int) ([]Conversation, error) {
+	conversations := []Conversation{}
+
+	err := db.View(func(dbstruct *DBStructure) error {
+		for _, conversation := range dbstruct.Conversations {
+			if _, err := memberIndex(conversation, userId); err == nil {
+				conversations = append(conversations, conversation)
+			}
+		}
+		dbstruct.describeConversations(conversations, userId)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(conversations, func(a, b Conversation) int {
+		if c := b.UpdatedAt.Compare(a.UpdatedAt); c != 0 {
+			return c
+		}
+		return b.Id - a.Id
+	})
+	return conversations, nil
+}
+
+func (db *DB) GetConversation(id int, userId int) (Conversation, error) {
+	conversation := Conversation{}
+
+	err := db.View(func(dbstruct *DBStructure) error {
+		var err error
+		conversation, _, err = dbstruct.memberConversation(id, userId)
+		if err != nil {
+			return err
+		}
+		conversations := []Conversation{conversation}
+		dbstruct.describeConversations(conversations, userId)
+		conversation = conversations[0]
+		return nil
+	})
+	if err != nil {
+		return Conversation{}, err
+	}
+	return conversation, nil
+}
+
+// SendMessage adds a message from the sender to the conversation. Sending
+// a message marks the conversation as read for the sender.
+func (db *DB) SendMessage(conversationId int, senderId int, body string) (Message, error) {
+	err := checkMessageBody(body)
+	if err != nil {
+		return Message{}, err
+	}
+
+	message := Message{}
+	err = db.Update(func(tx *Tx) error {
+		conversation, idx, err := tx.memberConversation(conversationId, senderId)
+		if err != nil {
+			return err
+		}
+		for _, member := range conversation.Members {
+			if member.UserId != senderId && tx.blocked(senderId, member.UserId) {
+				return blockedError(member.UserId)
+			}
+		}
+
+		now := time.Now().UTC()
+		message = Message{
+			Id:             tx.nextId("messages"),
+			ConversationId: conversationId,
+			SenderId:       senderId,
+			Body:           body,
+			CreatedAt:      now,
+		}
+		tx.Messages[message.Id] = message
+		tx.Touch("messages", message.Id)
+
+		conversation.Members = slices.Clone(conversation.Members)
+		conversation.Members[idx].LastReadMessageId = message.Id
+		conversation.Members[idx].ReadAt = &now
+		conversation.UpdatedAt = now
+		tx.Conversations[conversationId] = conversation
+		tx.Touch("conversations", conversationId)
+		return nil
+	})
+	if err != nil {
+		return Message{}, err
+	}
+	return message, nil
+}
+
+// GetMessages returns a page of the messages of the conversation, newest
+// first.
+func (db *DB) GetMessages(conversationId int, userId int, query MessageQuery) (MessagePage, error) {
+	before, err := decodeMessageCursor(query.Cursor)
+	if err != nil {
+		return MessagePage{}, err
+	}
+
+	messages := []Message{}
+	err = db.View(func(dbstruct *DBStructure) error {
+		_, _, err := dbstruct.memberConversation(conversationId, userId)
+		if err != nil {
+			return err
+		}
+		for _, message := range dbstruct.Messages {
+			if message.ConversationId == conversationId && (before == 0 || message.Id < before) {
+				messages = append(messages, message)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return MessagePage{}, err
+	}
+
+	slices.SortFunc(messages, func(a, b Message) int { return b.Id - a.Id })
+	if len(messages) > query.Limit+1 {
+		messages = messages[:query.Limit+1]
+	}
+	return toMessagePage(messages, query.Limit), nil
+}
+
+// MarkConversationRead moves the read receipt of the user up to the
+// message, or the newest message if messageId is zero. Receipts never move
+// back.
+func (db *DB) MarkConversationRead(conversationId int, userId int, messageId int) (Conversation, error) {
+	err := db.Update(func(tx *Tx) error {
+		conversation, idx, err := tx.memberConversation(conversationId, userId)
+		if err != nil {
+			return err
+		}
+
+		if messageId == 0 {
+			for _, message := range tx.Messages {
+				if message.ConversationId == conversationId && message.Id > messageId {
+					messageId = message.Id
+				}
+			}
+		} else if message, ok := tx.Messages[messageId]; !ok || message.ConversationId != conversationId {
+			return ValidationError{message: fmt.Sprintf("message %d is not part of this conversation", messageId)}
+		}
+
+		if messageId <= conversation.Members[idx].LastReadMessageId {
+			return nil
+		}
+		now := time.Now().UTC()
+		conversation.Members = slices.Clone(conversation.Members)
+		conversation.Members[idx].LastReadMessageId = messageId
+		conversation.Members[idx].ReadAt = &now
+		tx.Conversations[conversationId] = conversation
+		tx.Touch("conversations", conversationId)
+		return nil
+	})
+	if err != nil {
+		return Conversation{}, err
+	}
+	return db.GetConversation(conversationId, userId)
+}
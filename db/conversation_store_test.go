@@ -0,0 +1,41 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ortin779/chirpy/models"
+)
+
+func TestCreateConversationChecksEveryPairOfMembers(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store, _ func() Store) {
+		for i := 1; i <= 4; i++ {
+			mustCreateUser(t, store, models.UserRequestBody{Email: fmt.Sprintf("user%d@example.com", i), Password: "secret"})
+		}
+		_, err := store.BlockUser(3, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			name    string
+			creator int
+			members []int
+			blocked bool
+		}{
+			{"members blocking one another", 1, []int{2, 3}, true},
+			{"creator blocked by a member", 2, []int{3, 4}, true},
+			{"creator blocking a member", 3, []int{1, 2}, true},
+			{"no blocks", 1, []int{2, 4}, false},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, _, err := store.CreateConversation(tt.creator, tt.members)
+				if blocked := errors.As(err, &AuthorizationError{}); blocked != tt.blocked || (!tt.blocked && err != nil) {
+					t.Errorf("CreateConversation(%d, %v) error = %v, want blocked %t", tt.creator, tt.members, err, tt.blocked)
+				}
+			})
+		}
+	})
+}
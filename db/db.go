@@ -2,7 +2,10 @@ package db
 
 import (
 	"encoding/json"
+	"log"
 	"os"
+	"path/filepath"
+	"reflect"
 	"slices"
 	"sync"
 	"time"
@@ -15,15 +18,53 @@ const (
 	refreshTokenExpiry = time.Hour * 24 * 6
 )
 
+// DB is the JSON file backed Store. The whole database is kept in memory,
+// reads are served from it and every mutation is appended to a write-ahead
+// log next to the snapshot file. The log is periodically compacted into
+// the snapshot.
 type DB struct {
 	path string
 	mx   *sync.RWMutex
+	data DBStructure
+
+	wal        *os.File
+	walEntries int
+	compactMx  *sync.Mutex
+	compact    chan struct{}
+	done       chan struct{}
+	closed     sync.WaitGroup
 }
 
 type DBStructure struct {
-	Chirps       map[int]Chirp           `json:"chirps"`
-	Users        map[int]User            `json:"users"`
-	RefreshToken map[string]RefreshToken `json:"revoked_tokens"`
+	SchemaVersion int                     `json:"schema_version"`
+	Chirps        map[int]Chirp           `json:"chirps"`
+	Users         map[int]User            `json:"users"`
+	RefreshToken  map[string]RefreshToken `json:"refresh_tokens"`
+	// TokenFamilies group the refresh tokens rotated from a single login.
+	TokenFamilies map[string]TokenFamily `json:"token_families"`
+	// RevokedAccessTokens is the denylist of access tokens, keyed by jti.
+	RevokedAccessTokens map[string]RevokedAccessToken `json:"revoked_access_tokens"`
+	// ChirpHistory holds the previous versions of every edited chirp,
+	// oldest first.
+	ChirpHistory map[int][]ChirpVersion `json:"chirp_history"`
+	// Likes are keyed by likeKey.
+	Likes map[string]Like `json:"likes"`
+	// Follows are keyed by followKey.
+	Follows map[string]Follow `json:"follows"`
+	// Notifications are kept until the chirp they are about is purged.
+	Notifications map[int]Notification `json:"notifications"`
+	// Conversations hold their members and read receipts.
+	Conversations map[int]Conversation `json:"conversations"`
+	Messages      map[int]Message      `json:"messages"`
+	// Blocks are keyed by blockKey.
+	Blocks map[string]Block `json:"blocks"`
+	// Sequences hold the last id handed out for each table whose ids are
+	// taken from a sequence, keyed by the json name of the table.
+	Sequences map[string]int `json:"sequences"`
+
+	// tags indexes the ids of the chirps using each normalized hashtag.
+	// It is rebuilt whenever the database is loaded.
+	tags map[string]map[int]bool
 }
 
 type NotFoundError struct{}
@@ -48,68 +89,216 @@ func (aerr AuthorizationError) Error() string {
 	return aerr.message
 }
 
+// GoneError is returned for chirps that have been deleted.
+type GoneError struct {
+	message string
+}
+
+func (gerr GoneError) Error() string {
+	return gerr.message
+}
+
+// ValidationError is returned when the input of a request is rejected.
+type ValidationError struct {
+	message string
+}
+
+func (verr ValidationError) Error() string {
+	return verr.message
+}
+
 func NewDB(path string) (*DB, error) {
 
 	db := &DB{
-		path: path,
-		mx:   &sync.RWMutex{},
+		path:      path,
+		mx:        &sync.RWMutex{},
+		compactMx: &sync.Mutex{},
+		compact:   make(chan struct{}, 1),
+		done:      make(chan struct{}),
 	}
 	err := db.ensureDB()
 	if err != nil {
 		return nil, err
 	}
+
+	// Bring the file up to the current schema and fold in the log left by
+	// the previous run, so the log only ever holds current schema records.
+	doc, replayed, err := loadDocument(db.path, db.walPath())
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := migrate(doc)
+	if err != nil {
+		return nil, err
+	}
+	for _, migration := range migrations {
+		log.Printf("db: migrated %s to schema version %d: %s", db.path, migration.Version, migration.Description)
+	}
+	if replayed > 0 || len(migrations) > 0 {
+		err = saveDocument(db.path, doc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	db.data, err = doc.decode()
+	if err != nil {
+		return nil, err
+	}
+	err = db.openWAL()
+	if err != nil {
+		return nil, err
+	}
+
+	db.closed.Add(1)
+	go db.compactLoop()
 	return db, nil
 }
 
+// Close stops the background compaction, folds the write-ahead log into the
+// snapshot and closes the log file.
+func (db *DB) Close() error {
+	close(db.done)
+	db.closed.Wait()
+
+	err := db.Compact()
+	if err != nil {
+		return err
+	}
+	return db.wal.Close()
+}
+
 func (db *DB) ensureDB() error {
-	_, err := os.OpenFile(db.path, os.O_RDONLY|os.O_CREATE, 0666)
+	file, err := os.OpenFile(db.path, os.O_RDONLY|os.O_CREATE, 0666)
 	if err != nil {
 		return err
 	}
-	return nil
+	return file.Close()
 }
 
-func (db *DB) loadDB() (DBStructure, error) {
+// View passes the in-memory database to fn under a read lock. fn must not
+// modify dbstruct.
+func (db *DB) View(fn func(dbstruct *DBStructure) error) error {
+	db.mx.RLock()
+	defer db.mx.RUnlock()
+
+	return fn(&db.data)
+}
+
+// Update runs fn as a single transaction under the write lock. fn mutates
+// the in-memory database through tx and must call tx.Touch for every entry
+// it adds, changes or deletes; the touched entries are appended to the
+// write-ahead log before Update returns. If fn returns an error after
+// touching entries, the in-memory state is reloaded from disk so the
+// partial change is discarded.
+func (db *DB) Update(fn func(tx *Tx) error) error {
 	db.mx.Lock()
 	defer db.mx.Unlock()
 
-	file, err := os.ReadFile(db.path)
+	tx := &Tx{DBStructure: &db.data}
+	err := fn(tx)
 	if err != nil {
-		return DBStructure{}, err
+		if len(tx.touched) > 0 {
+			db.reload()
+		}
+		return err
+	}
+	if len(tx.touched) == 0 {
+		return nil
 	}
 
-	if len(file) == 0 {
-		return DBStructure{
-			Chirps:       make(map[int]Chirp),
-			Users:        make(map[int]User),
-			RefreshToken: make(map[string]RefreshToken),
-		}, nil
+	err = db.appendWAL(tx)
+	if err != nil {
+		db.reload()
+		return err
 	}
+	return nil
+}
 
-	dbStructure := DBStructure{}
-	err = json.Unmarshal(file, &dbStructure)
+// reload rebuilds the in-memory state from the snapshot and the log. It is
+// called with the write lock held.
+func (db *DB) reload() {
+	data, err := db.loadDB()
+	if err != nil {
+		log.Printf("db: reloading %s: %v", db.path, err)
+		return
+	}
+	db.data = data
+}
+
+func (db *DB) loadDB() (DBStructure, error) {
+	doc, _, err := loadDocument(db.path, db.walPath())
 	if err != nil {
 		return DBStructure{}, err
 	}
-	return dbStructure, nil
+	return doc.decode()
 }
 
-func (db *DB) writeDB(dbStructure DBStructure) error {
-	db.mx.Lock()
-	defer db.mx.Unlock()
+// initTables allocates every collection of dbStructure that is missing
+// from the file.
+func initTables(dbStructure *DBStructure) {
+	structValue := reflect.ValueOf(dbStructure).Elem()
+	for i := 0; i < structValue.NumField(); i++ {
+		field := structValue.Field(i)
+		if field.Kind() == reflect.Map && field.IsNil() && field.CanSet() {
+			field.Set(reflect.MakeMap(field.Type()))
+		}
+	}
+}
 
+// writeDB replaces the database file atomically. The data is written to a
+// temp file in the same directory, synced, and renamed over the old file,
+// so a crash mid write leaves either the old or the new content on disk.
+func (db *DB) writeDB(dbStructure DBStructure) error {
 	data, err := json.Marshal(dbStructure)
 	if err != nil {
 		return err
 	}
 
-	err = os.WriteFile(db.path, data, 0644)
+	return writeFileAtomic(db.path, data, 0644)
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
 
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
 	if err != nil {
 		return err
 	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
 
-	return nil
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Sync()
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(tmpPath, perm)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		return err
+	}
+
+	// Sync the directory so the rename itself survives a crash.
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
 }
 
 func getSortedKeys[T any](m map[int]T) []int {
@@ -122,6 +311,15 @@ func getSortedKeys[T any](m map[int]T) []int {
 	return keys
 }
 
+// nextId returns the id for a new entry of the table with the given json
+// name, one past the last id handed out for it. Ids are never reused, not
+// even once the entry holding the largest one has been purged.
+func (tx *Tx) nextId(table string) int {
+	tx.Sequences[table]++
+	tx.Touch("sequences", table)
+	return tx.Sequences[table]
+}
+
 func findUser(email string, users map[int]User) *User {
 	for _, usr := range users {
 		if usr.Email == email {
@@ -0,0 +1,305 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ortin779/chirpy/models"
+)
+
+func TestReplayAfterCrash(t *testing.T) {
+	db := newTestDB(t)
+	for i := 0; i < 3; i++ {
+		mustCreateChirp(t, db, models.Chirp{Body: "hello", AuthorId: 1})
+	}
+	_, err := db.DeleteChirp(2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recovered := openCrashImage(t, db)
+	assertLiveChirps(t, recovered, 1, 3)
+	if _, err = recovered.GetChirp(2); !errors.As(err, &GoneError{}) {
+		t.Errorf("GetChirp(2) error = %v, want the deletion to be replayed", err)
+	}
+}
+
+func TestReplayDropsTornRecord(t *testing.T) {
+	db := newTestDB(t)
+	mustCreateChirp(t, db, models.Chirp{Body: "complete", AuthorId: 1})
+
+	path := crashImage(t, db)
+	wal, err := os.OpenFile(path+".wal", os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = wal.WriteString(`[{"table":"chirps","key":"2","value":{"id":2,"body":"to`)
+	wal.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recovered, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB() with a torn log: %v", err)
+	}
+	assertLiveChirps(t, recovered, 1)
+
+	// The torn record must not corrupt the log written after it.
+	mustCreateChirp(t, recovered, models.Chirp{Body: "after recovery", AuthorId: 1})
+	assertLiveChirps(t, openCrashImage(t, recovered), 1, 2)
+	recovered.Close()
+}
+
+func TestCompactDuringWrites(t *testing.T) {
+	db := newTestDB(t)
+
+	const writers, perWriter = 4, 50
+	done := make(chan struct{})
+	compacted := make(chan struct{})
+	go func() {
+		defer close(compacted)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			err := db.Compact()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	wg := sync.WaitGroup{}
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				_, err := db.CreateChirp(models.Chirp{Body: "hello", AuthorId: 1})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	<-compacted
+
+	ids := make([]int, 0, writers*perWriter)
+	for id := 1; id <= writers*perWriter; id++ {
+		ids = append(ids, id)
+	}
+	assertLiveChirps(t, openCrashImage(t, db), ids...)
+}
+
+func TestFailedUpdateLeavesNoTrace(t *testing.T) {
+	db := newTestDB(t)
+	mustCreateChirp(t, db, models.Chirp{Body: "original", AuthorId: 1})
+	snapshot, wal := readFiles(t, db)
+
+	failure := errors.New("rejected")
+	err := db.Update(func(tx *Tx) error {
+		chirp := tx.Chirps[1]
+		chirp.Body = "changed"
+		tx.Chirps[1] = chirp
+		tx.Touch("chirps", 1)
+		tx.Chirps[2] = models.Chirp{Id: 2, Body: "added", AuthorId: 1}
+		tx.Touch("chirps", 2)
+		tx.nextId("chirps")
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("Update() error = %v, want %v", err, failure)
+	}
+
+	assertUntouched := func(t *testing.T, db *DB) {
+		t.Helper()
+		assertLiveChirps(t, db, 1)
+		chirp, err := db.GetChirp(1)
+		if err != nil || chirp.Body != "original" {
+			t.Errorf("GetChirp(1) = %q, %v, want the original body", chirp.Body, err)
+		}
+		if chirp := mustCreateChirp(t, db, models.Chirp{Body: "next", AuthorId: 1}); chirp.Id != 2 {
+			t.Errorf("next chirp got id %d, want 2", chirp.Id)
+		}
+	}
+	afterSnapshot, afterWAL := readFiles(t, db)
+	if !bytes.Equal(snapshot, afterSnapshot) || !bytes.Equal(wal, afterWAL) {
+		t.Error("failed update changed the files on disk")
+	}
+	t.Run("memory", func(t *testing.T) { assertUntouched(t, db) })
+	t.Run("disk", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "chirpy.json")
+		writeFile(t, path, snapshot)
+		writeFile(t, path+".wal", wal)
+		db, err := NewDB(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		assertUntouched(t, db)
+	})
+}
+
+func BenchmarkReads100k(b *testing.B) {
+	const chirps = 100_000
+	db := newBenchmarkDB(b, chirps)
+
+	b.Run("GetChirp", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			id := 0
+			for pb.Next() {
+				id = id%chirps + 1
+				_, err := db.GetChirp(id)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+	b.Run("GetChirps", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				_, err := db.GetChirps(models.ChirpQuery{Sort: "desc", Limit: 20})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+	b.Run("GetChirpsByAuthor", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				_, err := db.GetChirps(models.ChirpQuery{AuthorId: 7, Limit: 20})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+}
+
+// newBenchmarkDB opens a database whose snapshot holds n chirps by 100
+// authors, written directly rather than through n logged transactions.
+func newBenchmarkDB(b *testing.B, n int) *DB {
+	b.Helper()
+	data := DBStructure{SchemaVersion: currentSchemaVersion}
+	initTables(&data)
+	now := time.Now().UTC()
+	for id := 1; id <= n; id++ {
+		data.Chirps[id] = models.Chirp{
+			Id:        id,
+			Body:      "a chirp of average length for benchmarking reads",
+			AuthorId:  id%100 + 1,
+			Entities:  []models.Entity{},
+			CreatedAt: now.Add(time.Duration(id) * time.Millisecond),
+			UpdatedAt: now.Add(time.Duration(id) * time.Millisecond),
+		}
+	}
+	data.Sequences["chirps"] = n
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	path := filepath.Join(b.TempDir(), "chirpy.json")
+	err = os.WriteFile(path, encoded, 0644)
+	if err != nil {
+		b.Fatal(err)
+	}
+	db, err := NewDB(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { db.Close() })
+	return db
+}
+
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := NewDB(filepath.Join(t.TempDir(), "chirpy.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// crashImage copies the snapshot and write-ahead log of db as they are on
+// disk, as if the process had died, and returns the path of the copy.
+func crashImage(t *testing.T, db *DB) string {
+	t.Helper()
+	snapshot, wal := readFiles(t, db)
+	path := filepath.Join(t.TempDir(), "chirpy.json")
+	writeFile(t, path, snapshot)
+	writeFile(t, path+".wal", wal)
+	return path
+}
+
+func openCrashImage(t *testing.T, db *DB) *DB {
+	t.Helper()
+	recovered, err := NewDB(crashImage(t, db))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { recovered.Close() })
+	return recovered
+}
+
+func readFiles(t *testing.T, db *DB) (snapshot []byte, wal []byte) {
+	t.Helper()
+	db.mx.RLock()
+	defer db.mx.RUnlock()
+
+	snapshot, err := os.ReadFile(db.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wal, err = os.ReadFile(db.walPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snapshot, wal
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	err := os.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// assertLiveChirps checks that the chirps of db that have not been deleted
+// are exactly the ones with the given ids.
+func assertLiveChirps(t *testing.T, db *DB, ids ...int) {
+	t.Helper()
+	page, err := db.GetChirps(models.ChirpQuery{Sort: "asc", Limit: len(ids) + 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []int{}
+	for _, chirp := range page.Chirps {
+		got = append(got, chirp.Id)
+	}
+	if len(got) != len(ids) {
+		t.Fatalf("live chirps = %v, want %v", got, ids)
+	}
+	for i := range ids {
+		if got[i] != ids[i] {
+			t.Fatalf("live chirps = %v, want %v", got, ids)
+		}
+	}
+}
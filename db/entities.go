@@ -0,0 +1,81 @@
+package db
+
+import (
+	"strings"
+
+	"github.com/ortin779/chirpy/entities"
+	. "github.com/ortin779/chirpy/models"
+)
+
+// parseEntities finds the entities of body. mentioned returns the id of the
+// user a handle refers to, or zero.
+func parseEntities(body string, mentioned func(handle string) (int, error)) ([]Entity, error) {
+	found := entities.Parse(body)
+	for i, entity := range found {
+		if entity.Type != entities.TypeMention {
+			continue
+		}
+		userId, err := mentioned(entity.Text)
+		if err != nil {
+			return nil, err
+		}
+		found[i].UserId = userId
+	}
+	return found, nil
+}
+
+// unresolved leaves every mention without a user. It parses chirps written
+// before users had handles.
+func unresolved(handle string) (int, error) {
+	return 0, nil
+}
+
+// checkHandle validates the handle a user picked, an empty handle means
+// the user cannot be mentioned.
+func checkHandle(handle string) error {
+	if handle != "" && !entities.ValidHandle(handle) {
+		return ValidationError{message: "handle can only contain letters, digits, _ and, not at the end, . - +"}
+	}
+	return nil
+}
+
+// handleTakenError is returned when another user has the handle.
+var handleTakenError = ValidationError{message: "handle is already taken"}
+
+// mentionedUser returns the id of the user with the handle, zero if there
+// is none.
+func (dbstruct *DBStructure) mentionedUser(handle string) (int, error) {
+	for id, user := range dbstruct.Users {
+		if user.Handle != "" && strings.EqualFold(user.Handle, handle) {
+			return id, nil
+		}
+	}
+	return 0, nil
+}
+
+func (dbstruct *DBStructure) indexTags() {
+	dbstruct.tags = make(map[string]map[int]bool)
+	for _, chirp := range dbstruct.Chirps {
+		dbstruct.indexChirp(chirp)
+	}
+}
+
+func (dbstruct *DBStructure) indexChirp(chirp Chirp) {
+	for _, tag := range entities.Tags(chirp.Entities) {
+		ids, ok := dbstruct.tags[tag]
+		if !ok {
+			ids = make(map[int]bool)
+			dbstruct.tags[tag] = ids
+		}
+		ids[chirp.Id] = true
+	}
+}
+
+func (dbstruct *DBStructure) unindexChirp(chirp Chirp) {
+	for _, tag := range entities.Tags(chirp.Entities) {
+		delete(dbstruct.tags[tag], chirp.Id)
+		if len(dbstruct.tags[tag]) == 0 {
+			delete(dbstruct.tags, tag)
+		}
+	}
+}
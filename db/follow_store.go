@@ -0,0 +1,130 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	. "github.com/ortin779/chirpy/models"
+)
+
+func followKey(followerId int, followeeId int) string {
+	return fmt.Sprintf("%d:%d", followerId, followeeId)
+}
+
+// FollowUser makes the follower follow the followee. Following a user twice
+// changes nothing.
+func (db *DB) FollowUser(followerId int, followeeId int) (Follow, error) {
+	follow := Follow{}
+
+	err := db.Update(func(tx *Tx) error {
+		err := checkFollow(followerId, followeeId)
+		if err != nil {
+			return err
+		}
+		if _, ok := tx.Users[followeeId]; !ok {
+			return NotFoundError{}
+		}
+
+		key := followKey(followerId, followeeId)
+		existing, ok := tx.Follows[key]
+		if ok {
+			follow = existing
+			return nil
+		}
+
+		follow = Follow{FollowerId: followerId, FolloweeId: followeeId, CreatedAt: time.Now().UTC()}
+		tx.Follows[key] = follow
+		tx.Touch("follows", key)
+		tx.notify(Notification{UserId: followeeId, Type: NotificationFollow, ActorId: followerId})
+		return nil
+	})
+	if err != nil {
+		return Follow{}, err
+	}
+	return follow, nil
+}
+
+// UnfollowUser removes the follow, if there is one.
+func (db *DB) UnfollowUser(followerId int, followeeId int) error {
+	return db.Update(func(tx *Tx) error {
+		if _, ok := tx.Users[followeeId]; !ok {
+			return NotFoundError{}
+		}
+
+		key := followKey(followerId, followeeId)
+		if _, ok := tx.Follows[key]; !ok {
+			return nil
+		}
+		delete(tx.Follows, key)
+		tx.Touch("follows", key)
+		tx.unnotify(followeeId, NotificationFollow, followerId, 0)
+		return nil
+	})
+}
+
+// GetFollowers returns the users following the user, most recent first.
+func (db *DB) GetFollowers(userId int) ([]FollowUser, error) {
+	return db.followList(userId, func(follow Follow) (int, bool) {
+		return follow.FollowerId, follow.FolloweeId == userId
+	})
+}
+
+// GetFollowing returns the users the user follows, most recent first.
+func (db *DB) GetFollowing(userId int) ([]FollowUser, error) {
+	return db.followList(userId, func(follow Follow) (int, bool) {
+		return follow.FolloweeId, follow.FollowerId == userId
+	})
+}
+
+// followList collects the follows for which other reports ok, as the id of
+// the other user of each follow.
+func (db *DB) followList(userId int, other func(follow Follow) (id int, ok bool)) ([]FollowUser, error) {
+	users := []FollowUser{}
+
+	err := db.View(func(dbstruct *DBStructure) error {
+		if _, ok := dbstruct.Users[userId]; !ok {
+			return NotFoundError{}
+		}
+
+		for _, follow := range dbstruct.Follows {
+			if id, ok := other(follow); ok {
+				users = append(users, FollowUser{Id: id, FollowedAt: follow.CreatedAt})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(users, func(a, b FollowUser) int {
+		if c := b.FollowedAt.Compare(a.FollowedAt); c != 0 {
+			return c
+		}
+		return b.Id - a.Id
+	})
+	return users, nil
+}
+
+// followeeSet returns the ids of the users the user follows, or nil for a
+// zero user id.
+func followeeSet(dbstruct *DBStructure, userId int) map[int]bool {
+	if userId == 0 {
+		return nil
+	}
+	followees := make(map[int]bool)
+	for _, follow := range dbstruct.Follows {
+		if follow.FollowerId == userId {
+			followees[follow.FolloweeId] = true
+		}
+	}
+	return followees
+}
+
+func checkFollow(followerId int, followeeId int) error {
+	if followerId == followeeId {
+		return ValidationError{message: "you cannot follow yourself"}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/ortin779/chirpy/models"
+)
+
+func TestFollowTimeline(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store, reopen func() Store) {
+		users := []models.UserResponse{}
+		for _, email := range []string{"jane@example.com", "doe@example.com", "sam@example.com"} {
+			users = append(users, mustCreateUser(t, store, models.UserRequestBody{Email: email, Password: "secret"}))
+		}
+		jane, doe, sam := users[0].Id, users[1].Id, users[2].Id
+
+		for _, followeeId := range []int{doe, sam, doe} {
+			follow, err := store.FollowUser(jane, followeeId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if follow.FollowerId != jane || follow.FolloweeId != followeeId {
+				t.Errorf("FollowUser() = %+v", follow)
+			}
+		}
+		_, err := store.FollowUser(jane, jane)
+		if !errors.As(err, &ValidationError{}) {
+			t.Errorf("following yourself error = %v, want a ValidationError", err)
+		}
+		_, err = store.FollowUser(jane, 100)
+		if !errors.Is(err, NotFoundError{}) {
+			t.Errorf("following a missing user error = %v, want NotFoundError", err)
+		}
+
+		fromDoe := mustCreateChirp(t, store, models.Chirp{Body: "doe", AuthorId: doe})
+		fromSam := mustCreateChirp(t, store, models.Chirp{Body: "sam", AuthorId: sam})
+		mustCreateChirp(t, store, models.Chirp{Body: "jane", AuthorId: jane})
+
+		store = reopen()
+		following, err := store.GetFollowing(jane)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(following) != 2 {
+			t.Errorf("GetFollowing() = %+v, want doe and sam once each", following)
+		}
+		followers, err := store.GetFollowers(doe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(followers) != 1 || followers[0].Id != jane {
+			t.Errorf("GetFollowers() = %+v, want jane", followers)
+		}
+		timeline := homeTimeline(t, store, jane)
+		if want := []int{fromSam.Id, fromDoe.Id}; !slices.Equal(timeline, want) {
+			t.Errorf("home timeline = %v, want %v", timeline, want)
+		}
+
+		err = store.UnfollowUser(jane, sam)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Unfollowing twice changes nothing.
+		err = store.UnfollowUser(jane, sam)
+		if err != nil {
+			t.Fatal(err)
+		}
+		timeline = homeTimeline(t, store, jane)
+		if want := []int{fromDoe.Id}; !slices.Equal(timeline, want) {
+			t.Errorf("home timeline after unfollowing = %v, want %v", timeline, want)
+		}
+		// Nobody follows doe, doe's timeline is empty.
+		if timeline := homeTimeline(t, store, doe); len(timeline) != 0 {
+			t.Errorf("home timeline without followees = %v, want none", timeline)
+		}
+	})
+}
+
+// homeTimeline returns the ids of the chirps on the first page of the home
+// timeline of the user.
+func homeTimeline(t *testing.T, store Store, userId int) []int {
+	t.Helper()
+	page, err := store.GetChirps(models.ChirpQuery{FollowedBy: userId, Sort: "-created_at", Limit: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chirpIds(page.Chirps)
+}
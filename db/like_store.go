@@ -0,0 +1,133 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	. "github.com/ortin779/chirpy/models"
+)
+
+func likeKey(chirpId int, userId int) string {
+	return fmt.Sprintf("%d:%d", chirpId, userId)
+}
+
+// LikeChirp records that the user likes the chirp. Liking a chirp twice
+// changes nothing.
+func (db *DB) LikeChirp(chirpId int, userId int) (Chirp, error) {
+	chirp := Chirp{}
+
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		chirp, err = liveChirp(tx.DBStructure, chirpId)
+		if err != nil {
+			return err
+		}
+
+		key := likeKey(chirpId, userId)
+		if _, ok := tx.Likes[key]; ok {
+			return nil
+		}
+		tx.Likes[key] = Like{ChirpId: chirpId, UserId: userId, CreatedAt: time.Now().UTC()}
+		tx.Touch("likes", key)
+
+		chirp.LikeCount++
+		tx.Chirps[chirpId] = chirp
+		tx.Touch("chirps", chirpId)
+
+		if chirp.AuthorId != userId {
+			tx.notify(Notification{UserId: chirp.AuthorId, Type: NotificationLike, ActorId: userId, ChirpId: chirpId})
+		}
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp.LikedByMe = true
+	return chirp, nil
+}
+
+// UnlikeChirp removes the like of the user from the chirp, if there is one.
+func (db *DB) UnlikeChirp(chirpId int, userId int) (Chirp, error) {
+	chirp := Chirp{}
+
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		chirp, err = liveChirp(tx.DBStructure, chirpId)
+		if err != nil {
+			return err
+		}
+
+		key := likeKey(chirpId, userId)
+		if _, ok := tx.Likes[key]; !ok {
+			return nil
+		}
+		delete(tx.Likes, key)
+		tx.Touch("likes", key)
+
+		chirp.LikeCount--
+		tx.Chirps[chirpId] = chirp
+		tx.Touch("chirps", chirpId)
+		tx.unnotify(chirp.AuthorId, NotificationLike, userId, chirpId)
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// GetLikedChirps returns the chirps the user liked that have not been
+// deleted, most recently liked first.
+func (db *DB) GetLikedChirps(userId int) ([]Chirp, error) {
+	chirps := []Chirp{}
+
+	err := db.View(func(dbstruct *DBStructure) error {
+		if _, ok := dbstruct.Users[userId]; !ok {
+			return NotFoundError{}
+		}
+
+		likes := []Like{}
+		for _, like := range dbstruct.Likes {
+			if like.UserId == userId {
+				likes = append(likes, like)
+			}
+		}
+		slices.SortFunc(likes, func(a, b Like) int {
+			if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+				return c
+			}
+			return b.ChirpId - a.ChirpId
+		})
+
+		for _, like := range likes {
+			chirp, ok := dbstruct.Chirps[like.ChirpId]
+			if ok && chirp.DeletedAt == nil {
+				chirps = append(chirps, chirp)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return chirps, nil
+}
+
+// HasLiked reports which of the given chirps the user liked.
+func (db *DB) HasLiked(userId int, chirpIds []int) (map[int]bool, error) {
+	liked := make(map[int]bool)
+
+	err := db.View(func(dbstruct *DBStructure) error {
+		for _, chirpId := range chirpIds {
+			if _, ok := dbstruct.Likes[likeKey(chirpId, userId)]; ok {
+				liked[chirpId] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return liked, nil
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/ortin779/chirpy/models"
+)
+
+func TestLikesAreIdempotent(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store, reopen func() Store) {
+		jane := mustCreateUser(t, store, models.UserRequestBody{Email: "jane@example.com", Password: "secret"})
+		doe := mustCreateUser(t, store, models.UserRequestBody{Email: "doe@example.com", Password: "secret"})
+		first := mustCreateChirp(t, store, models.Chirp{Body: "first", AuthorId: jane.Id})
+		second := mustCreateChirp(t, store, models.Chirp{Body: "second", AuthorId: jane.Id})
+
+		steps := []struct {
+			name   string
+			call   func(chirpId int, userId int) (models.Chirp, error)
+			userId int
+			count  int
+		}{
+			{name: "like", call: store.LikeChirp, userId: doe.Id, count: 1},
+			{name: "like again", call: store.LikeChirp, userId: doe.Id, count: 1},
+			{name: "like by the author", call: store.LikeChirp, userId: jane.Id, count: 2},
+			{name: "unlike", call: store.UnlikeChirp, userId: jane.Id, count: 1},
+			{name: "unlike again", call: store.UnlikeChirp, userId: jane.Id, count: 1},
+		}
+		for _, step := range steps {
+			chirp, err := step.call(first.Id, step.userId)
+			if err != nil {
+				t.Fatalf("%s: %v", step.name, err)
+			}
+			if chirp.LikeCount != step.count {
+				t.Errorf("%s: like count = %d, want %d", step.name, chirp.LikeCount, step.count)
+			}
+		}
+		_, err := store.LikeChirp(second.Id, doe.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		store = reopen()
+		chirp, err := store.GetChirp(first.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if chirp.LikeCount != 1 {
+			t.Errorf("like count after reopening = %d, want 1", chirp.LikeCount)
+		}
+		liked, err := store.HasLiked(doe.Id, []int{first.Id, second.Id})
+		if err != nil || !liked[first.Id] || !liked[second.Id] {
+			t.Errorf("HasLiked() = %v, %v, want both chirps", liked, err)
+		}
+		liked, err = store.HasLiked(jane.Id, []int{first.Id, second.Id})
+		if err != nil || len(liked) != 0 {
+			t.Errorf("HasLiked() after unliking = %v, %v, want none", liked, err)
+		}
+
+		// Deleted chirps drop out of the liked chirps and cannot be liked.
+		_, err = store.DeleteChirp(first.Id, jane.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chirps, err := store.GetLikedChirps(doe.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := chirpIds(chirps); !slices.Equal(got, []int{second.Id}) {
+			t.Errorf("GetLikedChirps() = %v, want [%d]", got, second.Id)
+		}
+		_, err = store.LikeChirp(first.Id, jane.Id)
+		if !errors.As(err, &GoneError{}) {
+			t.Errorf("LikeChirp() of a deleted chirp error = %v, want a GoneError", err)
+		}
+		_, err = store.GetLikedChirps(100)
+		if !errors.Is(err, NotFoundError{}) {
+			t.Errorf("GetLikedChirps() of a missing user error = %v, want NotFoundError", err)
+		}
+	})
+}
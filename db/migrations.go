@@ -0,0 +1,454 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+const schemaVersionKey = "schema_version"
+
+// document is the database file decoded only down to the entries of each
+// collection, so migrations can reshape it without depending on the
+// current DBStructure.
+type document struct {
+	version int
+	tables  map[string]map[string]json.RawMessage
+}
+
+// jsonMigration upgrades a document from the previous schema version and
+// returns a human readable line for every change it made.
+type jsonMigration struct {
+	description string
+	up          func(doc *document) ([]string, error)
+}
+
+// jsonMigrations are applied in order, the schema version of a file is the
+// number of migrations it has been through.
+var jsonMigrations = []jsonMigration{
+	{
+		description: "rename revoked_tokens to refresh_tokens",
+		up: func(doc *document) ([]string, error) {
+			return doc.renameTable("revoked_tokens", "refresh_tokens"), nil
+		},
+	},
+	{
+		// Entries written before timestamps existed are dated to the time
+		// of the migration, their ids still give their relative order.
+		description: "backfill created_at and updated_at on chirps and users",
+		up: func(doc *document) ([]string, error) {
+			now := time.Now().UTC()
+			changes := []string{}
+			for _, table := range []string{"chirps", "users"} {
+				for _, field := range []string{"created_at", "updated_at"} {
+					change, err := doc.backfill(table, field, now)
+					if err != nil {
+						return nil, err
+					}
+					changes = append(changes, change...)
+				}
+			}
+			return changes, nil
+		},
+	},
+	{
+		description: "extract hashtags and mentions from chirp bodies",
+		up: func(doc *document) ([]string, error) {
+			return doc.backfillEntities()
+		},
+	},
+	{
+		description: "store refresh tokens hashed, with their owner and expiry",
+		up: func(doc *document) ([]string, error) {
+			return doc.hashRefreshTokens()
+		},
+	},
+	{
+		description: "backfill last_used_at on token_families",
+		up: func(doc *document) ([]string, error) {
+			return doc.copyField("token_families", "created_at", "last_used_at")
+		},
+	},
+	{
+		// Ids of chirps purged before sequences existed cannot be told
+		// apart, the sequence starts at the largest remaining id.
+		description: "seed the chirps id sequence from the largest id",
+		up: func(doc *document) ([]string, error) {
+			return doc.seedSequences("chirps")
+		},
+	},
+	{
+		description: "seed the users id sequence from the largest id",
+		up: func(doc *document) ([]string, error) {
+			return doc.seedSequences("users")
+		},
+	},
+	{
+		description: "seed the conversations and messages id sequences from the largest ids",
+		up: func(doc *document) ([]string, error) {
+			return doc.seedSequences("conversations", "messages")
+		},
+	},
+	{
+		description: "seed the notifications id sequence from the largest id",
+		up: func(doc *document) ([]string, error) {
+			return doc.seedSequences("notifications")
+		},
+	},
+}
+
+var currentSchemaVersion = len(jsonMigrations)
+
+// Migration describes a migration that was, or in a dry run would be,
+// applied to a database file.
+type Migration struct {
+	Version     int
+	Description string
+	Changes     []string
+}
+
+// MigrateFile upgrades the JSON database at path to the current schema
+// version, folding any pending write-ahead log into the file. With dryRun
+// set nothing is written and the returned migrations report what would
+// change. It must not be run against a database a server has open.
+func MigrateFile(path string, dryRun bool) ([]Migration, error) {
+	doc, replayed, err := loadDocument(path, path+".wal")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, err := migrate(doc)
+	if err != nil {
+		return nil, err
+	}
+	if dryRun || (replayed == 0 && len(migrations) == 0) {
+		return migrations, nil
+	}
+
+	err = saveDocument(path, doc)
+	if err != nil {
+		return nil, err
+	}
+	return migrations, nil
+}
+
+func migrate(doc *document) ([]Migration, error) {
+	if doc.version > currentSchemaVersion {
+		return nil, fmt.Errorf("database schema version %d is newer than the supported version %d", doc.version, currentSchemaVersion)
+	}
+
+	applied := []Migration{}
+	for doc.version < currentSchemaVersion {
+		migration := jsonMigrations[doc.version]
+		changes, err := migration.up(doc)
+		if err != nil {
+			return nil, fmt.Errorf("migration %d (%s): %w", doc.version+1, migration.description, err)
+		}
+		doc.version++
+		applied = append(applied, Migration{
+			Version:     doc.version,
+			Description: migration.description,
+			Changes:     changes,
+		})
+	}
+	return applied, nil
+}
+
+// loadDocument reads the snapshot at path and replays the write-ahead log
+// at walPath onto it. It returns the number of replayed transactions.
+func loadDocument(path string, walPath string) (*document, int, error) {
+	file, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, 0, err
+	}
+
+	doc := &document{tables: make(map[string]map[string]json.RawMessage)}
+	if len(file) > 0 {
+		raw := map[string]json.RawMessage{}
+		err = json.Unmarshal(file, &raw)
+		if err != nil {
+			return nil, 0, err
+		}
+		for key, value := range raw {
+			if key == schemaVersionKey {
+				err = json.Unmarshal(value, &doc.version)
+			} else {
+				table := map[string]json.RawMessage{}
+				err = json.Unmarshal(value, &table)
+				doc.tables[key] = table
+			}
+			if err != nil {
+				return nil, 0, fmt.Errorf("decoding %s: %w", key, err)
+			}
+		}
+	}
+
+	replayed, err := replayWAL(walPath, doc)
+	if err != nil {
+		return nil, 0, err
+	}
+	return doc, replayed, nil
+}
+
+// saveDocument atomically replaces the snapshot at path with doc and
+// removes the write-ahead log, which doc already includes.
+func saveDocument(path string, doc *document) error {
+	data, err := doc.encode()
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(path, data, 0644)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path + ".wal")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (doc *document) encode() ([]byte, error) {
+	raw := make(map[string]any, len(doc.tables)+1)
+	for name, table := range doc.tables {
+		raw[name] = table
+	}
+	raw[schemaVersionKey] = doc.version
+	return json.Marshal(raw)
+}
+
+func (doc *document) decode() (DBStructure, error) {
+	data, err := doc.encode()
+	if err != nil {
+		return DBStructure{}, err
+	}
+
+	dbStructure := DBStructure{}
+	err = json.Unmarshal(data, &dbStructure)
+	if err != nil {
+		return DBStructure{}, err
+	}
+	initTables(&dbStructure)
+	dbStructure.indexTags()
+	return dbStructure, nil
+}
+
+func (doc *document) apply(record walRecord) {
+	table, ok := doc.tables[record.Table]
+	if !ok {
+		table = make(map[string]json.RawMessage)
+		doc.tables[record.Table] = table
+	}
+	if record.Value == nil {
+		delete(table, record.Key)
+		return
+	}
+	table[record.Key] = record.Value
+}
+
+func (doc *document) renameTable(from string, to string) []string {
+	table, ok := doc.tables[from]
+	if !ok {
+		return nil
+	}
+	delete(doc.tables, from)
+	doc.tables[to] = table
+	return []string{fmt.Sprintf("renamed %s to %s (%d entries)", from, to, len(table))}
+}
+
+// backfill sets field to value on every entry of table that does not have
+// it yet.
+func (doc *document) backfill(table string, field string, value any) ([]string, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	count := 0
+	for key, raw := range doc.tables[table] {
+		entry := map[string]json.RawMessage{}
+		err = json.Unmarshal(raw, &entry)
+		if err != nil {
+			return nil, fmt.Errorf("decoding %s %s: %w", table, key, err)
+		}
+		if _, ok := entry[field]; ok {
+			continue
+		}
+		entry[field] = encoded
+
+		raw, err = json.Marshal(entry)
+		if err != nil {
+			return nil, err
+		}
+		doc.tables[table][key] = raw
+		count++
+	}
+
+	if count == 0 {
+		return nil, nil
+	}
+	return []string{fmt.Sprintf("set %s on %d %s to %s", field, count, table, encoded)}, nil
+}
+
+// copyField sets field to the value of from on every entry of table that
+// does not have it yet.
+func (doc *document) copyField(table string, from string, field string) ([]string, error) {
+	count := 0
+	for key, raw := range doc.tables[table] {
+		entry := map[string]json.RawMessage{}
+		err := json.Unmarshal(raw, &entry)
+		if err != nil {
+			return nil, fmt.Errorf("decoding %s %s: %w", table, key, err)
+		}
+		if _, ok := entry[field]; ok {
+			continue
+		}
+		entry[field] = entry[from]
+
+		raw, err = json.Marshal(entry)
+		if err != nil {
+			return nil, err
+		}
+		doc.tables[table][key] = raw
+		count++
+	}
+
+	if count == 0 {
+		return nil, nil
+	}
+	return []string{fmt.Sprintf("set %s on %d %s to their %s", field, count, table, from)}, nil
+}
+
+// seedSequences sets the sequence of every given table that does not have
+// one yet to its largest id.
+func (doc *document) seedSequences(tables ...string) ([]string, error) {
+	sequences, ok := doc.tables["sequences"]
+	if !ok {
+		sequences = make(map[string]json.RawMessage)
+		doc.tables["sequences"] = sequences
+	}
+
+	changes := []string{}
+	for _, table := range tables {
+		if _, ok := sequences[table]; ok {
+			continue
+		}
+		last := 0
+		for key := range doc.tables[table] {
+			id, err := strconv.Atoi(key)
+			if err != nil {
+				return nil, fmt.Errorf("decoding %s %s: %w", table, key, err)
+			}
+			last = max(last, id)
+		}
+		sequences[table] = json.RawMessage(strconv.Itoa(last))
+		changes = append(changes, fmt.Sprintf("set the %s sequence to %d", table, last))
+	}
+	return changes, nil
+}
+
+// backfillEntities sets entities on every chirp that does not have them
+// yet. Users had no handles, the mentions are left unresolved.
+func (doc *document) backfillEntities() ([]string, error) {
+	count := 0
+	for key, raw := range doc.tables["chirps"] {
+		entry := map[string]json.RawMessage{}
+		err := json.Unmarshal(raw, &entry)
+		if err != nil {
+			return nil, fmt.Errorf("decoding chirps %s: %w", key, err)
+		}
+		if _, ok := entry["entities"]; ok {
+			continue
+		}
+
+		var body string
+		err = json.Unmarshal(entry["body"], &body)
+		if err != nil {
+			return nil, fmt.Errorf("decoding chirps %s: %w", key, err)
+		}
+		found, err := parseEntities(body, unresolved)
+		if err != nil {
+			return nil, err
+		}
+		entry["entities"], err = json.Marshal(found)
+		if err != nil {
+			return nil, err
+		}
+
+		raw, err = json.Marshal(entry)
+		if err != nil {
+			return nil, err
+		}
+		doc.tables["chirps"][key] = raw
+		count++
+	}
+
+	if count == 0 {
+		return nil, nil
+	}
+	return []string{fmt.Sprintf("set entities on %d chirps", count)}, nil
+}
+
+// hashRefreshTokens rekeys every refresh token by its hash and fills in its
+// owner, creation and expiry from its claims. Tokens that cannot be decoded
+// could never be used and are dropped.
+func (doc *document) hashRefreshTokens() ([]string, error) {
+	table := doc.tables["refresh_tokens"]
+	if len(table) == 0 {
+		return nil, nil
+	}
+
+	hashed := make(map[string]json.RawMessage, len(table))
+	dropped := 0
+	for key, raw := range table {
+		entry := map[string]json.RawMessage{}
+		err := json.Unmarshal(raw, &entry)
+		if err != nil {
+			return nil, fmt.Errorf("decoding refresh_tokens %s: %w", key, err)
+		}
+
+		rToken, ok := legacyRefreshToken(key)
+		if !ok {
+			dropped++
+			continue
+		}
+		fields := map[string]any{
+			"id":         rToken.Id,
+			"user_id":    rToken.UserId,
+			"created_at": rToken.CreatedAt,
+			"expires_at": rToken.ExpiresAt,
+		}
+		var replacedBy string
+		if value, ok := entry["replaced_by"]; ok {
+			err = json.Unmarshal(value, &replacedBy)
+			if err != nil {
+				return nil, fmt.Errorf("decoding refresh_tokens %s: %w", key, err)
+			}
+		}
+		if replacedBy != "" {
+			fields["replaced_by"] = hashToken(replacedBy)
+		}
+		for field, value := range fields {
+			entry[field], err = json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		raw, err = json.Marshal(entry)
+		if err != nil {
+			return nil, err
+		}
+		hashed[rToken.Id] = raw
+	}
+	doc.tables["refresh_tokens"] = hashed
+
+	changes := []string{fmt.Sprintf("hashed %d refresh_tokens", len(hashed))}
+	if dropped > 0 {
+		changes = append(changes, fmt.Sprintf("dropped %d undecodable refresh_tokens", dropped))
+	}
+	return changes, nil
+}
@@ -0,0 +1,159 @@
+package db
+
+import (
+	"slices"
+	"time"
+
+	. "github.com/ortin779/chirpy/models"
+)
+
+// chirpNotifications returns the notifications a new chirp sends: one to
+// the author of the chirp it replies to, parentAuthor, one to the author of
+// the chirp it quotes or rechirps, originalAuthor, and one to every user it
+// mentions. Nobody is notified twice or about their own chirp.
+func chirpNotifications(chirp Chirp, parentAuthor int, originalAuthor int) []Notification {
+	notifications := []Notification{}
+	notified := map[int]bool{chirp.AuthorId: true}
+
+	if chirp.ReplyTo != 0 && !notified[parentAuthor] {
+		notified[parentAuthor] = true
+		notifications = append(notifications, Notification{
+			UserId:  parentAuthor,
+			Type:    NotificationReply,
+			ActorId: chirp.AuthorId,
+			ChirpId: chirp.Id,
+		})
+	}
+	if (chirp.QuoteOf != 0 || chirp.RechirpOf != 0) && !notified[originalAuthor] {
+		notified[originalAuthor] = true
+		notificationType := NotificationQuote
+		if chirp.RechirpOf != 0 {
+			notificationType = NotificationRechirp
+		}
+		notifications = append(notifications, Notification{
+			UserId:  originalAuthor,
+			Type:    notificationType,
+			ActorId: chirp.AuthorId,
+			ChirpId: chirp.Id,
+		})
+	}
+	for _, entity := range chirp.Entities {
+		if entity.UserId == 0 || notified[entity.UserId] {
+			continue
+		}
+		notified[entity.UserId] = true
+		notifications = append(notifications, Notification{
+			UserId:  entity.UserId,
+			Type:    NotificationMention,
+			ActorId: chirp.AuthorId,
+			ChirpId: chirp.Id,
+		})
+	}
+	return notifications
+}
+
+// notify stores new notifications.
+func (tx *Tx) notify(notifications ...Notification) {
+	now := time.Now().UTC()
+	for _, notification := range notifications {
+		notification.Id = tx.nextId("notifications")
+		notification.CreatedAt = now
+		tx.Notifications[notification.Id] = notification
+		tx.Touch("notifications", notification.Id)
+	}
+}
+
+// unnotify deletes the unread notification of the given kind, when the like
+// or follow it is about is taken back before it was seen.
+func (tx *Tx) unnotify(userId int, notificationType string, actorId int, chirpId int) {
+	for id, notification := range tx.Notifications {
+		if notification.UserId == userId && notification.Type == notificationType &&
+			notification.ActorId == actorId && notification.ChirpId == chirpId && notification.ReadAt == nil {
+			delete(tx.Notifications, id)
+			tx.Touch("notifications", id)
+		}
+	}
+}
+
+// visibleNotification reports whether the chirp the notification is about,
+// if any, has not been deleted.
+func visibleNotification(dbstruct *DBStructure, notification Notification) bool {
+	if notification.ChirpId == 0 {
+		return true
+	}
+	chirp, ok := dbstruct.Chirps[notification.ChirpId]
+	return ok && chirp.DeletedAt == nil
+}
+
+// GetNotifications returns the newest notifications of the user, up to
+// limit, newest first. Notifications about deleted chirps are left out.
+func (db *DB) GetNotifications(userId int, limit int) ([]Notification, error) {
+	notifications := []Notification{}
+
+	err := db.View(func(dbstruct *DBStructure) error {
+		for _, notification := range dbstruct.Notifications {
+			if notification.UserId == userId && visibleNotification(dbstruct, notification) {
+				notifications = append(notifications, notification)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(notifications, func(a, b Notification) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return b.Id - a.Id
+	})
+	if len(notifications) > limit {
+		notifications = notifications[:limit]
+	}
+	return notifications, nil
+}
+
+func (db *DB) CountUnreadNotifications(userId int) (int, error) {
+	count := 0
+
+	err := db.View(func(dbstruct *DBStructure) error {
+		for _, notification := range dbstruct.Notifications {
+			if notification.UserId == userId && notification.ReadAt == nil && visibleNotification(dbstruct, notification) {
+				count++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// MarkNotificationsRead marks the given notifications of the user as read,
+// or all of them if ids is empty. It returns how many were unread.
+func (db *DB) MarkNotificationsRead(userId int, ids []int) (int, error) {
+	marked := 0
+
+	err := db.Update(func(tx *Tx) error {
+		now := time.Now().UTC()
+		for id, notification := range tx.Notifications {
+			if notification.UserId != userId || notification.ReadAt != nil {
+				continue
+			}
+			if len(ids) > 0 && !slices.Contains(ids, id) {
+				continue
+			}
+			notification.ReadAt = &now
+			tx.Notifications[id] = notification
+			tx.Touch("notifications", id)
+			marked++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return marked, nil
+}
@@ -0,0 +1,48 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/ortin779/chirpy/models"
+)
+
+func TestQuotesAndRechirpsNotify(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store, _ func() Store) {
+		original := mustCreateChirp(t, store, models.Chirp{Body: "original", AuthorId: 1})
+		quote := mustCreateChirp(t, store, models.Chirp{Body: "quoting", AuthorId: 2, QuoteOf: original.Id})
+		rechirp := mustCreateChirp(t, store, models.Chirp{AuthorId: 3, RechirpOf: original.Id})
+		// Rechirping a rechirp notifies the author of the original.
+		mustCreateChirp(t, store, models.Chirp{AuthorId: 4, RechirpOf: rechirp.Id})
+		// Nobody is notified about their own chirp.
+		mustCreateChirp(t, store, models.Chirp{Body: "self", AuthorId: 1, QuoteOf: original.Id})
+
+		notifications, err := store.GetNotifications(1, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []struct {
+			notificationType string
+			actorId          int
+		}{
+			{models.NotificationRechirp, 4},
+			{models.NotificationRechirp, 3},
+			{models.NotificationQuote, 2},
+		}
+		if len(notifications) != len(want) {
+			t.Fatalf("notifications = %+v, want %d", notifications, len(want))
+		}
+		for i, w := range want {
+			got := notifications[i]
+			if got.Type != w.notificationType || got.ActorId != w.actorId {
+				t.Errorf("notification %d = %s by %d, want %s by %d", i, got.Type, got.ActorId, w.notificationType, w.actorId)
+			}
+		}
+		if notifications[2].ChirpId != quote.Id || notifications[1].ChirpId != rechirp.Id {
+			t.Errorf("notifications are about chirps %d and %d, want the quote %d and the rechirp %d",
+				notifications[2].ChirpId, notifications[1].ChirpId, quote.Id, rechirp.Id)
+		}
+		if notifications[0].Id <= notifications[1].Id || notifications[1].Id <= notifications[2].Id {
+			t.Errorf("notification ids %d, %d, %d do not grow", notifications[2].Id, notifications[1].Id, notifications[0].Id)
+		}
+	})
+}
@@ -0,0 +1,178 @@
+package db
+
+import (
+	"container/heap"
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+	"time"
+
+	. "github.com/ortin779/chirpy/models"
+)
+
+// chirpCursor is the position of the last chirp of a page. It is handed to
+// clients base64 encoded so they treat it as opaque.
+type chirpCursor struct {
+	Id        int       `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// EncodeCursor returns the cursor of a page that ends with chirp.
+func EncodeCursor(chirp Chirp) string {
+	data, _ := json.Marshal(chirpCursor{Id: chirp.Id, CreatedAt: chirp.CreatedAt})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor returns the chirp a page has to start after, or nil for the
+// first page.
+func DecodeCursor(cursor string) (*Chirp, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ValidationError{message: "invalid cursor"}
+	}
+	parsed := chirpCursor{}
+	err = json.Unmarshal(data, &parsed)
+	if err != nil || parsed.Id <= 0 {
+		return nil, ValidationError{message: "invalid cursor"}
+	}
+	return &Chirp{Id: parsed.Id, CreatedAt: parsed.CreatedAt}, nil
+}
+
+// chirpOrder returns the comparison for the requested sort order, ties are
+// broken by id so every chirp has a unique position. Unknown orders sort
+// by id, newest first.
+func chirpOrder(sort string) func(a, b Chirp) int {
+	switch sort {
+	case "asc":
+		return func(a, b Chirp) int { return a.Id - b.Id }
+	case "created_at":
+		return func(a, b Chirp) int {
+			if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+				return c
+			}
+			return a.Id - b.Id
+		}
+	case "-created_at":
+		return func(a, b Chirp) int {
+			if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+				return c
+			}
+			return b.Id - a.Id
+		}
+	default:
+		return func(a, b Chirp) int { return b.Id - a.Id }
+	}
+}
+
+// inTimeRange reports whether the chirp was created within the range of
+// the query.
+func inTimeRange(chirp Chirp, query ChirpQuery) bool {
+	if !query.Since.IsZero() && chirp.CreatedAt.Before(query.Since) {
+		return false
+	}
+	if !query.Until.IsZero() && !chirp.CreatedAt.Before(query.Until) {
+		return false
+	}
+	return true
+}
+
+// pageCollector keeps the first n chirps by order out of everything passed
+// to add, without holding on to the rest.
+type pageCollector struct {
+	n      int
+	order  func(a, b Chirp) int
+	chirps []Chirp
+}
+
+func (pc *pageCollector) Len() int           { return len(pc.chirps) }
+func (pc *pageCollector) Less(i, j int) bool { return pc.order(pc.chirps[i], pc.chirps[j]) > 0 }
+func (pc *pageCollector) Swap(i, j int)      { pc.chirps[i], pc.chirps[j] = pc.chirps[j], pc.chirps[i] }
+func (pc *pageCollector) Push(x any)         { pc.chirps = append(pc.chirps, x.(Chirp)) }
+func (pc *pageCollector) Pop() any {
+	last := pc.chirps[len(pc.chirps)-1]
+	pc.chirps = pc.chirps[:len(pc.chirps)-1]
+	return last
+}
+
+func (pc *pageCollector) add(chirp Chirp) {
+	if pc.Len() < pc.n {
+		heap.Push(pc, chirp)
+		return
+	}
+	// The root is the last chirp kept so far.
+	if pc.order(chirp, pc.chirps[0]) < 0 {
+		pc.chirps[0] = chirp
+		heap.Fix(pc, 0)
+	}
+}
+
+func (pc *pageCollector) sorted() []Chirp {
+	slices.SortFunc(pc.chirps, pc.order)
+	return pc.chirps
+}
+
+// toPage trims chirps, fetched with one extra entry, down to limit and sets
+// the cursor if there is a next page.
+func toPage(chirps []Chirp, limit int) ChirpPage {
+	if chirps == nil {
+		chirps = []Chirp{}
+	}
+	if limit == 0 || len(chirps) <= limit {
+		return ChirpPage{Chirps: chirps}
+	}
+	chirps = chirps[:limit]
+	return ChirpPage{
+		Chirps:     chirps,
+		NextCursor: EncodeCursor(chirps[limit-1]),
+	}
+}
+
+// messageCursor is the id of the last message of a page, pages of messages
+// go from newest to oldest.
+type messageCursor struct {
+	Id int `json:"id"`
+}
+
+func encodeMessageCursor(id int) string {
+	data, _ := json.Marshal(messageCursor{Id: id})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeMessageCursor returns the id the page has to continue below, zero
+// for the first page.
+func decodeMessageCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ValidationError{message: "invalid cursor"}
+	}
+	parsed := messageCursor{}
+	err = json.Unmarshal(data, &parsed)
+	if err != nil || parsed.Id <= 0 {
+		return 0, ValidationError{message: "invalid cursor"}
+	}
+	return parsed.Id, nil
+}
+
+// toMessagePage trims messages, fetched with one extra entry, down to limit
+// and sets the cursor if there is a next page.
+func toMessagePage(messages []Message, limit int) MessagePage {
+	if messages == nil {
+		messages = []Message{}
+	}
+	if len(messages) <= limit {
+		return MessagePage{Messages: messages}
+	}
+	messages = messages[:limit]
+	return MessagePage{
+		Messages:   messages,
+		NextCursor: encodeMessageCursor(messages[limit-1].Id),
+	}
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// RunPurger permanently removes chirps that were deleted longer than window
+// ago, checking every interval until ctx is cancelled.
+func RunPurger(ctx context.Context, store Store, window time.Duration, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		purged, err := store.PurgeDeletedChirps(time.Now().UTC().Add(-window))
+		if err != nil {
+			log.Printf("db: purging deleted chirps: %v", err)
+			continue
+		}
+		if purged > 0 {
+			log.Printf("db: purged %d deleted chirps", purged)
+		}
+	}
+}
+
+// RunTokenSweeper deletes the refresh tokens that expired or were revoked,
+// and the denylisted access tokens that expired, checking every interval
+// until ctx is cancelled.
+func RunTokenSweeper(ctx context.Context, store Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		purged, err := store.PurgeRefreshTokens(time.Now().UTC())
+		if err != nil {
+			log.Printf("db: sweeping refresh tokens: %v", err)
+			continue
+		}
+		if purged > 0 {
+			log.Printf("db: swept %d refresh tokens", purged)
+		}
+	}
+}
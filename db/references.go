@@ -0,0 +1,70 @@
+package db
+
+import (
+	"fmt"
+
+	. "github.com/ortin779/chirpy/models"
+)
+
+// alreadyRechirpedError is returned when a user would end up with two live
+// rechirps of the same chirp.
+var alreadyRechirpedError = ValidationError{message: "you already rechirped this chirp"}
+
+// referenceError turns the error of looking up a chirp referenced by a new
+// chirp into a ValidationError, the reference comes from the request body.
+func referenceError(id int, err error) error {
+	switch err.(type) {
+	case NotFoundError:
+		return ValidationError{message: fmt.Sprintf("chirp %d does not exist", id)}
+	case GoneError:
+		return ValidationError{message: fmt.Sprintf("chirp %d has been deleted", id)}
+	}
+	return err
+}
+
+// checkReferences validates the chirps a new chirp replies to, quotes or
+// rechirps, get looks up a chirp that has not been deleted. Quoting or
+// rechirping a rechirp refers to its original instead.
+func checkReferences(chirp *Chirp, get func(id int) (Chirp, error)) error {
+	if chirp.RechirpOf != 0 && (chirp.Body != "" || chirp.ReplyTo != 0 || chirp.QuoteOf != 0) {
+		return ValidationError{message: "a rechirp cannot have a body, reply or quote"}
+	}
+
+	if chirp.ReplyTo != 0 {
+		_, err := get(chirp.ReplyTo)
+		if err != nil {
+			return referenceError(chirp.ReplyTo, err)
+		}
+	}
+
+	for _, ref := range []*int{&chirp.QuoteOf, &chirp.RechirpOf} {
+		if *ref == 0 {
+			continue
+		}
+		original, err := get(*ref)
+		if err != nil {
+			return referenceError(*ref, err)
+		}
+		if original.RechirpOf != 0 {
+			*ref = original.RechirpOf
+			_, err = get(*ref)
+			if err != nil {
+				return referenceError(*ref, err)
+			}
+		}
+	}
+	return nil
+}
+
+// storedChirp returns the fields of chirp that are stored for a new chirp,
+// everything else is derived or filled in per request.
+func storedChirp(chirp Chirp) Chirp {
+	return Chirp{
+		Body:      chirp.Body,
+		AuthorId:  chirp.AuthorId,
+		Flagged:   chirp.Flagged,
+		ReplyTo:   chirp.ReplyTo,
+		QuoteOf:   chirp.QuoteOf,
+		RechirpOf: chirp.RechirpOf,
+	}
+}
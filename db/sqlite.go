@@ -0,0 +1,243 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteDB is a Store backed by an embedded SQLite database.
+type SQLiteDB struct {
+	conn *sql.DB
+}
+
+// sqliteMigrations are applied in order, PRAGMA user_version records how
+// many of them have already run against the database file.
+var sqliteMigrations = []string{
+	`CREATE TABLE users (
+		id            INTEGER PRIMARY KEY AUTOINCREMENT,
+		email         TEXT    NOT NULL UNIQUE,
+		password      TEXT    NOT NULL,
+		is_chirpy_red INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE TABLE chirps (
+		id        INTEGER PRIMARY KEY AUTOINCREMENT,
+		body      TEXT    NOT NULL,
+		author_id INTEGER NOT NULL
+	);
+	CREATE INDEX idx_chirps_author_id ON chirps(author_id);
+	CREATE TABLE refresh_tokens (
+		id          TEXT    PRIMARY KEY,
+		has_revoked INTEGER NOT NULL DEFAULT 0
+	);`,
+	// Timestamps are stored as unix nanoseconds, existing rows are dated
+	// to the time of the migration.
+	`ALTER TABLE chirps ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE chirps ADD COLUMN updated_at INTEGER NOT NULL DEFAULT 0;
+	UPDATE chirps SET created_at = unixepoch() * 1000000000, updated_at = unixepoch() * 1000000000;
+	CREATE INDEX idx_chirps_created_at ON chirps(created_at, id);
+	ALTER TABLE users ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE users ADD COLUMN updated_at INTEGER NOT NULL DEFAULT 0;
+	UPDATE users SET created_at = unixepoch() * 1000000000, updated_at = unixepoch() * 1000000000;`,
+	`ALTER TABLE chirps ADD COLUMN flagged INTEGER NOT NULL DEFAULT 0;`,
+	`CREATE TABLE chirp_versions (
+		chirp_id   INTEGER NOT NULL,
+		version    INTEGER NOT NULL,
+		body       TEXT    NOT NULL,
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY (chirp_id, version)
+	);`,
+	`ALTER TABLE chirps ADD COLUMN deleted_at INTEGER;
+	CREATE INDEX idx_chirps_deleted_at ON chirps(deleted_at);`,
+	`ALTER TABLE chirps ADD COLUMN reply_to INTEGER;
+	CREATE INDEX idx_chirps_reply_to ON chirps(reply_to);`,
+	`CREATE TABLE likes (
+		chirp_id   INTEGER NOT NULL,
+		user_id    INTEGER NOT NULL,
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY (chirp_id, user_id)
+	);
+	CREATE INDEX idx_likes_user_id ON likes(user_id, created_at);`,
+	`ALTER TABLE chirps ADD COLUMN quote_of INTEGER;
+	ALTER TABLE chirps ADD COLUMN rechirp_of INTEGER;
+	CREATE INDEX idx_chirps_rechirp_of ON chirps(rechirp_of);`,
+	`CREATE TABLE follows (
+		follower_id INTEGER NOT NULL,
+		followee_id INTEGER NOT NULL,
+		created_at  INTEGER NOT NULL,
+		PRIMARY KEY (follower_id, followee_id)
+	);
+	CREATE INDEX idx_follows_followee_id ON follows(followee_id, created_at);`,
+	`ALTER TABLE chirps ADD COLUMN entities TEXT NOT NULL DEFAULT '[]';
+	CREATE TABLE chirp_tags (
+		tag        TEXT    NOT NULL,
+		chirp_id   INTEGER NOT NULL,
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY (tag, chirp_id)
+	);
+	CREATE INDEX idx_chirp_tags_chirp_id ON chirp_tags(chirp_id);
+	CREATE INDEX idx_chirp_tags_created_at ON chirp_tags(created_at);
+	ALTER TABLE users ADD COLUMN handle TEXT NOT NULL DEFAULT '';
+	CREATE UNIQUE INDEX idx_users_handle ON users(lower(handle)) WHERE handle != '';`,
+	`CREATE TABLE notifications (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id    INTEGER NOT NULL,
+		type       TEXT    NOT NULL,
+		actor_id   INTEGER NOT NULL,
+		chirp_id   INTEGER,
+		created_at INTEGER NOT NULL,
+		read_at    INTEGER
+	);
+	CREATE INDEX idx_notifications_user_id ON notifications(user_id, created_at);
+	CREATE INDEX idx_notifications_chirp_id ON notifications(chirp_id);`,
+	`CREATE TABLE conversations (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+	CREATE TABLE conversation_members (
+		conversation_id      INTEGER NOT NULL,
+		user_id              INTEGER NOT NULL,
+		joined_at            INTEGER NOT NULL,
+		last_read_message_id INTEGER NOT NULL DEFAULT 0,
+		read_at              INTEGER,
+		PRIMARY KEY (conversation_id, user_id)
+	);
+	CREATE INDEX idx_conversation_members_user_id ON conversation_members(user_id);
+	CREATE TABLE messages (
+		id              INTEGER PRIMARY KEY AUTOINCREMENT,
+		conversation_id INTEGER NOT NULL,
+		sender_id       INTEGER NOT NULL,
+		body            TEXT    NOT NULL,
+		created_at      INTEGER NOT NULL
+	);
+	CREATE INDEX idx_messages_conversation_id ON messages(conversation_id, id);
+	CREATE TABLE blocks (
+		blocker_id INTEGER NOT NULL,
+		blocked_id INTEGER NOT NULL,
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY (blocker_id, blocked_id)
+	);
+	CREATE INDEX idx_blocks_blocked_id ON blocks(blocked_id);`,
+	// Tokens issued before rotation have no family until their first
+	// refresh.
+	`CREATE TABLE token_families (
+		id         TEXT    PRIMARY KEY,
+		user_id    INTEGER NOT NULL,
+		created_at INTEGER NOT NULL,
+		revoked_at INTEGER
+	);
+	CREATE INDEX idx_token_families_user_id ON token_families(user_id);
+	ALTER TABLE refresh_tokens ADD COLUMN family_id TEXT NOT NULL DEFAULT '';
+	ALTER TABLE refresh_tokens ADD COLUMN replaced_by TEXT NOT NULL DEFAULT '';
+	CREATE INDEX idx_refresh_tokens_family_id ON refresh_tokens(family_id);`,
+	// Tokens are rekeyed by their hash by hashRefreshTokens.
+	`ALTER TABLE refresh_tokens ADD COLUMN user_id INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE refresh_tokens ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE refresh_tokens ADD COLUMN expires_at INTEGER NOT NULL DEFAULT 0;
+	CREATE INDEX idx_refresh_tokens_expires_at ON refresh_tokens(expires_at);`,
+	`ALTER TABLE token_families ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+	ALTER TABLE token_families ADD COLUMN ip TEXT NOT NULL DEFAULT '';
+	ALTER TABLE token_families ADD COLUMN last_used_at INTEGER NOT NULL DEFAULT 0;
+	UPDATE token_families SET last_used_at = created_at;
+	CREATE INDEX idx_refresh_tokens_user_id ON refresh_tokens(user_id);`,
+	`ALTER TABLE refresh_tokens ADD COLUMN access_token_id TEXT NOT NULL DEFAULT '';
+	CREATE TABLE revoked_access_tokens (
+		id         TEXT    PRIMARY KEY,
+		user_id    INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL
+	);
+	CREATE INDEX idx_revoked_access_tokens_expires_at ON revoked_access_tokens(expires_at);
+	ALTER TABLE users ADD COLUMN suspended_at INTEGER;`,
+}
+
+// sqliteBackfills fill in data that SQL alone cannot derive, keyed by the
+// index of the migration they complete. They run in the same transaction.
+var sqliteBackfills = map[int]func(tx *sql.Tx) error{
+	9:  backfillEntities,
+	13: hashRefreshTokens,
+}
+
+func NewSQLiteDB(path string) (*SQLiteDB, error) {
+	conn, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite only allows a single writer, a single connection keeps
+	// concurrent requests from failing with SQLITE_BUSY.
+	conn.SetMaxOpenConns(1)
+
+	db := &SQLiteDB{conn: conn}
+	err = db.migrate()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *SQLiteDB) Close() error {
+	return db.conn.Close()
+}
+
+func (db *SQLiteDB) migrate() error {
+	var version int
+	err := db.conn.QueryRow("PRAGMA user_version").Scan(&version)
+	if err != nil {
+		return err
+	}
+
+	for i := version; i < len(sqliteMigrations); i++ {
+		tx, err := db.conn.Begin()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(sqliteMigrations[i])
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("sqlite migration %d: %w", i+1, err)
+		}
+		if backfill, ok := sqliteBackfills[i]; ok {
+			err = backfill(tx)
+			if err != nil {
+				tx.Rollback()
+				return fmt.Errorf("sqlite migration %d: %w", i+1, err)
+			}
+		}
+		_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1))
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func fromUnixNano(nanos int64) time.Time {
+	return time.Unix(0, nanos).UTC()
+}
+
+// idBatchSize bounds the number of ids bound to a single IN clause.
+const idBatchSize = 500
+
+func idBatches(ids []int) [][]int {
+	batches := [][]int{}
+	for len(ids) > 0 {
+		batch := ids[:min(len(ids), idBatchSize)]
+		ids = ids[len(batch):]
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// placeholders returns n comma separated bind parameters.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
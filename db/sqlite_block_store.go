@@ -0,0 +1,82 @@
+package db
+
+import (
+	"time"
+
+	. "github.com/ortin779/chirpy/models"
+)
+
+func (db *SQLiteDB) BlockUser(blockerId int, blockedId int) (Block, error) {
+	err := checkBlock(blockerId, blockedId)
+	if err != nil {
+		return Block{}, err
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Block{}, err
+	}
+	defer tx.Rollback()
+
+	err = userExists(tx, blockedId)
+	if err != nil {
+		return Block{}, err
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO blocks (blocker_id, blocked_id, created_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+		blockerId, blockedId, time.Now().UTC().UnixNano(),
+	)
+	if err != nil {
+		return Block{}, err
+	}
+
+	block := Block{BlockerId: blockerId, BlockedId: blockedId}
+	var createdAt int64
+	err = tx.QueryRow(
+		"SELECT created_at FROM blocks WHERE blocker_id = ? AND blocked_id = ?",
+		blockerId, blockedId,
+	).Scan(&createdAt)
+	if err != nil {
+		return Block{}, err
+	}
+	block.CreatedAt = fromUnixNano(createdAt)
+	return block, tx.Commit()
+}
+
+func (db *SQLiteDB) UnblockUser(blockerId int, blockedId int) error {
+	err := userExists(db.conn, blockedId)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.conn.Exec("DELETE FROM blocks WHERE blocker_id = ? AND blocked_id = ?", blockerId, blockedId)
+	return err
+}
+
+func (db *SQLiteDB) GetBlocks(userId int) ([]Block, error) {
+	rows, err := db.conn.Query(
+		"SELECT blocked_id, created_at FROM blocks WHERE blocker_id = ? ORDER BY created_at DESC, blocked_id DESC",
+		userId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blocks := []Block{}
+	for rows.Next() {
+		block := Block{BlockerId: userId}
+		var createdAt int64
+		err = rows.Scan(&block.BlockedId, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+		block.CreatedAt = fromUnixNano(createdAt)
+		blocks = append(blocks, block)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
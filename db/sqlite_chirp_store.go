@@ -0,0 +1,520 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	. "github.com/ortin779/chirpy/models"
+)
+
+// chirpColumns selects a chirp from a table named chirps, the reply and
+// like counts are derived from its replies and likes.
+const chirpColumns = `id, body, author_id, flagged, entities, created_at, updated_at, deleted_at, reply_to, quote_of, rechirp_of,
+	(SELECT COUNT(*) FROM chirps AS replies WHERE replies.reply_to = chirps.id AND replies.deleted_at IS NULL),
+	(SELECT COUNT(*) FROM likes WHERE likes.chirp_id = chirps.id)`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// queryer is implemented by both *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryRow(query string, args ...any) *sql.Row
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func scanChirp(row rowScanner) (Chirp, error) {
+	chirp := Chirp{}
+	var createdAt, updatedAt int64
+	var deletedAt, replyTo, quoteOf, rechirpOf sql.NullInt64
+	var encodedEntities string
+	err := row.Scan(
+		&chirp.Id, &chirp.Body, &chirp.AuthorId, &chirp.Flagged, &encodedEntities, &createdAt, &updatedAt, &deletedAt,
+		&replyTo, &quoteOf, &rechirpOf, &chirp.ReplyCount, &chirp.LikeCount,
+	)
+	if err != nil {
+		return Chirp{}, err
+	}
+	err = json.Unmarshal([]byte(encodedEntities), &chirp.Entities)
+	chirp.CreatedAt = fromUnixNano(createdAt)
+	chirp.UpdatedAt = fromUnixNano(updatedAt)
+	if deletedAt.Valid {
+		deleted := fromUnixNano(deletedAt.Int64)
+		chirp.DeletedAt = &deleted
+	}
+	chirp.ReplyTo = int(replyTo.Int64)
+	chirp.QuoteOf = int(quoteOf.Int64)
+	chirp.RechirpOf = int(rechirpOf.Int64)
+	return chirp, err
+}
+
+// nullId stores a zero id reference as NULL.
+func nullId(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+func selectChirp(q queryer, id int) (Chirp, error) {
+	chirp, err := scanChirp(q.QueryRow("SELECT "+chirpColumns+" FROM chirps WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chirp{}, NotFoundError{}
+	}
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// selectLiveChirp is selectChirp for chirps that have not been deleted.
+func selectLiveChirp(q queryer, id int) (Chirp, error) {
+	chirp, err := selectChirp(q, id)
+	if err != nil {
+		return Chirp{}, err
+	}
+	if chirp.DeletedAt != nil {
+		return Chirp{}, GoneError{message: "chirp has been deleted"}
+	}
+	return chirp, nil
+}
+
+func (db *SQLiteDB) CreateChirp(draft Chirp) (Chirp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	chirp := storedChirp(draft)
+	err = checkReferences(&chirp, func(id int) (Chirp, error) {
+		return selectLiveChirp(tx, id)
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	chirp.Entities, err = sqliteEntities(tx, chirp.Body)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	if chirp.RechirpOf != 0 {
+		rechirped, err := sqliteHasRechirped(tx, chirp)
+		if err != nil {
+			return Chirp{}, err
+		}
+		if rechirped {
+			return Chirp{}, alreadyRechirpedError
+		}
+	}
+
+	now := time.Now().UTC()
+	res, err := tx.Exec(
+		`INSERT INTO chirps (body, author_id, flagged, created_at, updated_at, reply_to, quote_of, rechirp_of)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		chirp.Body, chirp.AuthorId, chirp.Flagged, now.UnixNano(), now.UnixNano(),
+		nullId(chirp.ReplyTo), nullId(chirp.QuoteOf), nullId(chirp.RechirpOf),
+	)
+	if err != nil {
+		return Chirp{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp.Id = int(id)
+	chirp.CreatedAt = now
+	chirp.UpdatedAt = now
+
+	err = writeEntities(tx, chirp)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	parentAuthor, originalAuthor := 0, 0
+	if chirp.ReplyTo != 0 {
+		err = tx.QueryRow("SELECT author_id FROM chirps WHERE id = ?", chirp.ReplyTo).Scan(&parentAuthor)
+		if err != nil {
+			return Chirp{}, err
+		}
+	}
+	if original := max(chirp.QuoteOf, chirp.RechirpOf); original != 0 {
+		err = tx.QueryRow("SELECT author_id FROM chirps WHERE id = ?", original).Scan(&originalAuthor)
+		if err != nil {
+			return Chirp{}, err
+		}
+	}
+	err = insertNotifications(tx, chirpNotifications(chirp, parentAuthor, originalAuthor)...)
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, tx.Commit()
+}
+
+func (db *SQLiteDB) GetChirps(query ChirpQuery) (ChirpPage, error) {
+	after, err := DecodeCursor(query.Cursor)
+	if err != nil {
+		return ChirpPage{}, err
+	}
+
+	orderBy := "id DESC"
+	cursorCondition := "id < ?"
+	byCreatedAt := false
+	switch query.Sort {
+	case "asc":
+		orderBy = "id ASC"
+		cursorCondition = "id > ?"
+	case "created_at":
+		orderBy = "created_at ASC, id ASC"
+		cursorCondition = "(created_at, id) > (?, ?)"
+		byCreatedAt = true
+	case "-created_at":
+		orderBy = "created_at DESC, id DESC"
+		cursorCondition = "(created_at, id) < (?, ?)"
+		byCreatedAt = true
+	}
+
+	conditions := []string{"deleted_at IS NULL"}
+	args := []any{}
+	if query.AuthorId != 0 {
+		conditions = append(conditions, "author_id = ?")
+		args = append(args, query.AuthorId)
+	}
+	if query.ReplyTo != 0 {
+		conditions = append(conditions, "reply_to = ?")
+		args = append(args, query.ReplyTo)
+	}
+	if query.Tag != "" {
+		conditions = append(conditions, "id IN (SELECT chirp_id FROM chirp_tags WHERE tag = ?)")
+		args = append(args, query.Tag)
+	}
+	if query.FollowedBy != 0 {
+		conditions = append(conditions, "author_id IN (SELECT followee_id FROM follows WHERE follower_id = ?)")
+		args = append(args, query.FollowedBy)
+	}
+	if query.FlaggedOnly {
+		conditions = append(conditions, "flagged = 1")
+	}
+	if !query.Since.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, query.Since.UnixNano())
+	}
+	if !query.Until.IsZero() {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, query.Until.UnixNano())
+	}
+	if after != nil {
+		conditions = append(conditions, cursorCondition)
+		if byCreatedAt {
+			args = append(args, after.CreatedAt.UnixNano())
+		}
+		args = append(args, after.Id)
+	}
+
+	stmt := "SELECT " + chirpColumns + " FROM chirps WHERE " + strings.Join(conditions, " AND ")
+	stmt += " ORDER BY " + orderBy
+	if query.Limit > 0 {
+		stmt += " LIMIT ?"
+		args = append(args, query.Limit+1)
+	}
+
+	rows, err := db.conn.Query(stmt, args...)
+	if err != nil {
+		return ChirpPage{}, err
+	}
+	defer rows.Close()
+
+	chirps := []Chirp{}
+	for rows.Next() {
+		chirp, err := scanChirp(rows)
+		if err != nil {
+			return ChirpPage{}, err
+		}
+		chirps = append(chirps, chirp)
+	}
+	if err = rows.Err(); err != nil {
+		return ChirpPage{}, err
+	}
+	return toPage(chirps, query.Limit), nil
+}
+
+func (db *SQLiteDB) GetChirp(id int) (Chirp, error) {
+	return selectLiveChirp(db.conn, id)
+}
+
+func (db *SQLiteDB) GetChirpsByIds(ids []int) (map[int]Chirp, error) {
+	chirps := make(map[int]Chirp)
+
+	for _, batch := range idBatches(ids) {
+		args := make([]any, 0, len(batch))
+		for _, id := range batch {
+			args = append(args, id)
+		}
+		rows, err := db.conn.Query(
+			"SELECT "+chirpColumns+" FROM chirps WHERE deleted_at IS NULL AND id IN ("+placeholders(len(batch))+")",
+			args...,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			chirp, err := scanChirp(rows)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			chirps[chirp.Id] = chirp
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return chirps, nil
+}
+
+func (db *SQLiteDB) DeleteChirp(id int, authorId int) (Chirp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	chirp, err := selectLiveChirp(tx, id)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	if chirp.AuthorId != authorId {
+		return Chirp{}, AuthorizationError{message: "you are not the author"}
+	}
+
+	deletedAt := time.Now().UTC()
+	chirp.DeletedAt = &deletedAt
+	_, err = tx.Exec("UPDATE chirps SET deleted_at = ? WHERE id = ?", deletedAt.UnixNano(), id)
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, tx.Commit()
+}
+
+func (db *SQLiteDB) UpdateChirp(id int, authorId int, body string, flagged bool) (Chirp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	chirp, err := selectLiveChirp(tx, id)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	if chirp.AuthorId != authorId {
+		return Chirp{}, AuthorizationError{message: "you are not the author"}
+	}
+
+	if chirp.RechirpOf != 0 {
+		return Chirp{}, ValidationError{message: "rechirps cannot be edited"}
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO chirp_versions (chirp_id, version, body, created_at)
+		SELECT ?, COUNT(*) + 1, ?, ? FROM chirp_versions WHERE chirp_id = ?`,
+		id, chirp.Body, chirp.UpdatedAt.UnixNano(), id,
+	)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	chirp.Body = body
+	chirp.Flagged = flagged
+	chirp.UpdatedAt = time.Now().UTC()
+	_, err = tx.Exec(
+		"UPDATE chirps SET body = ?, flagged = ?, updated_at = ? WHERE id = ?",
+		chirp.Body, chirp.Flagged, chirp.UpdatedAt.UnixNano(), id,
+	)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	chirp.Entities, err = sqliteEntities(tx, body)
+	if err != nil {
+		return Chirp{}, err
+	}
+	err = writeEntities(tx, chirp)
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, tx.Commit()
+}
+
+func (db *SQLiteDB) GetChirpHistory(id int) ([]ChirpVersion, error) {
+	chirp, err := selectLiveChirp(db.conn, id)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.conn.Query("SELECT version, body, created_at FROM chirp_versions WHERE chirp_id = ? ORDER BY version", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []ChirpVersion{}
+	for rows.Next() {
+		version := ChirpVersion{}
+		var createdAt int64
+		err = rows.Scan(&version.Version, &version.Body, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+		version.CreatedAt = fromUnixNano(createdAt)
+		versions = append(versions, version)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return append(versions, ChirpVersion{
+		Version:   len(versions) + 1,
+		Body:      chirp.Body,
+		CreatedAt: chirp.UpdatedAt,
+	}), nil
+}
+
+func (db *SQLiteDB) RestoreChirp(id int, authorId int, window time.Duration) (Chirp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	chirp, err := selectChirp(tx, id)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	if chirp.AuthorId != authorId {
+		return Chirp{}, AuthorizationError{message: "you are not the author"}
+	}
+
+	err = checkRestorable(chirp, window)
+	if err != nil {
+		return Chirp{}, err
+	}
+	// The user may have rechirped the same chirp again in the meantime.
+	if chirp.RechirpOf != 0 {
+		rechirped, err := sqliteHasRechirped(tx, chirp)
+		if err != nil {
+			return Chirp{}, err
+		}
+		if rechirped {
+			return Chirp{}, alreadyRechirpedError
+		}
+	}
+
+	chirp.DeletedAt = nil
+	_, err = tx.Exec("UPDATE chirps SET deleted_at = NULL WHERE id = ?", id)
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, tx.Commit()
+}
+
+// sqliteHasRechirped reports whether the author of the rechirp has another
+// live rechirp of the same chirp.
+func sqliteHasRechirped(q queryer, rechirp Chirp) (bool, error) {
+	var exists bool
+	err := q.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM chirps
+		WHERE id != ? AND author_id = ? AND rechirp_of = ? AND deleted_at IS NULL)`,
+		rechirp.Id, rechirp.AuthorId, rechirp.RechirpOf,
+	).Scan(&exists)
+	return exists, err
+}
+
+func (db *SQLiteDB) PurgeDeletedChirps(before time.Time) (int, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"chirp_versions", "likes", "chirp_tags", "notifications"} {
+		_, err = tx.Exec(
+			"DELETE FROM "+table+" WHERE chirp_id IN (SELECT id FROM chirps WHERE deleted_at < ?)",
+			before.UnixNano(),
+		)
+		if err != nil {
+			return 0, err
+		}
+	}
+	res, err := tx.Exec("DELETE FROM chirps WHERE deleted_at < ?", before.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	purged, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(purged), tx.Commit()
+}
+
+func (db *SQLiteDB) GetThread(id int) (ChirpThread, error) {
+	tx, err := db.conn.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return ChirpThread{}, err
+	}
+	defer tx.Rollback()
+
+	chirp, err := selectLiveChirp(tx, id)
+	if err != nil {
+		return ChirpThread{}, err
+	}
+
+	ancestors := []Chirp{}
+	for parentId := chirp.ReplyTo; parentId != 0; {
+		parent, err := selectChirp(tx, parentId)
+		if errors.Is(err, NotFoundError{}) {
+			break
+		}
+		if err != nil {
+			return ChirpThread{}, err
+		}
+		ancestors = append(ancestors, parent)
+		parentId = parent.ReplyTo
+	}
+
+	rows, err := tx.Query(
+		`WITH RECURSIVE descendants(id) AS (
+			SELECT id FROM chirps WHERE reply_to = ?
+			UNION ALL
+			SELECT chirps.id FROM chirps JOIN descendants ON chirps.reply_to = descendants.id
+		)
+		SELECT `+chirpColumns+` FROM chirps WHERE id IN (SELECT id FROM descendants)`,
+		id,
+	)
+	if err != nil {
+		return ChirpThread{}, err
+	}
+	defer rows.Close()
+
+	descendants := []Chirp{}
+	for rows.Next() {
+		reply, err := scanChirp(rows)
+		if err != nil {
+			return ChirpThread{}, err
+		}
+		descendants = append(descendants, reply)
+	}
+	if err = rows.Err(); err != nil {
+		return ChirpThread{}, err
+	}
+
+	return buildThread(chirp, ancestors, descendants), nil
+}
@@ -0,0 +1,422 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	. "github.com/ortin779/chirpy/models"
+)
+
+const messageColumns = "id, conversation_id, sender_id, body, created_at"
+
+func scanMessage(row rowScanner) (Message, error) {
+	message := Message{}
+	var createdAt int64
+	err := row.Scan(&message.Id, &message.ConversationId, &message.SenderId, &message.Body, &createdAt)
+	if err != nil {
+		return Message{}, err
+	}
+	message.CreatedAt = fromUnixNano(createdAt)
+	return message, nil
+}
+
+// selectConversation returns the conversation with its last message and
+// unread count for the user, and the index of the user among its members.
+func selectConversation(q queryer, id int, userId int) (Conversation, int, error) {
+	conversation := Conversation{Id: id, Members: []ConversationMember{}}
+	var createdAt, updatedAt int64
+	err := q.QueryRow("SELECT created_at, updated_at FROM conversations WHERE id = ?", id).Scan(&createdAt, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Conversation{}, -1, NotFoundError{}
+	}
+	if err != nil {
+		return Conversation{}, -1, err
+	}
+	conversation.CreatedAt = fromUnixNano(createdAt)
+	conversation.UpdatedAt = fromUnixNano(updatedAt)
+
+	rows, err := q.Query(
+		`SELECT user_id, joined_at, last_read_message_id, read_at FROM conversation_members
+		WHERE conversation_id = ? ORDER BY user_id`,
+		id,
+	)
+	if err != nil {
+		return Conversation{}, -1, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		member := ConversationMember{}
+		var joinedAt int64
+		var readAt sql.NullInt64
+		err = rows.Scan(&member.UserId, &joinedAt, &member.LastReadMessageId, &readAt)
+		if err != nil {
+			return Conversation{}, -1, err
+		}
+		member.JoinedAt = fromUnixNano(joinedAt)
+		if readAt.Valid {
+			read := fromUnixNano(readAt.Int64)
+			member.ReadAt = &read
+		}
+		conversation.Members = append(conversation.Members, member)
+	}
+	if err = rows.Err(); err != nil {
+		return Conversation{}, -1, err
+	}
+
+	idx, err := memberIndex(conversation, userId)
+	if err != nil {
+		return Conversation{}, -1, err
+	}
+
+	last, err := scanMessage(q.QueryRow(
+		"SELECT "+messageColumns+" FROM messages WHERE conversation_id = ? ORDER BY id DESC LIMIT 1",
+		id,
+	))
+	if err == nil {
+		conversation.LastMessage = &last
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return Conversation{}, -1, err
+	}
+
+	err = q.QueryRow(
+		"SELECT COUNT(*) FROM messages WHERE conversation_id = ? AND id > ? AND sender_id != ?",
+		id, conversation.Members[idx].LastReadMessageId, userId,
+	).Scan(&conversation.UnreadCount)
+	if err != nil {
+		return Conversation{}, -1, err
+	}
+	return conversation, idx, nil
+}
+
+// sqliteCheckBlocks fails if the user and any of the other members blocked
+// one another.
+func sqliteCheckBlocks(q queryer, userId int, memberIds []int) error {
+	for _, memberId := range memberIds {
+		if memberId == userId {
+			continue
+		}
+		var blocked int
+		err := q.QueryRow(
+			`SELECT 1 FROM blocks
+			WHERE (blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?)`,
+			userId, memberId, memberId, userId,
+		).Scan(&blocked)
+		if err == nil {
+			return blockedError(memberId)
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+	}
+	return nil
+}
+
+// sqliteCheckMemberBlocks fails if any two members of a new conversation
+// blocked one another.
+func sqliteCheckMemberBlocks(q queryer, creatorId int, members []int) error {
+	err := sqliteCheckBlocks(q, creatorId, members)
+	if err != nil {
+		return err
+	}
+
+	args := make([]any, 0, 2*len(members))
+	for range 2 {
+		for _, memberId := range members {
+			args = append(args, memberId)
+		}
+	}
+	var blocked int
+	err = q.QueryRow(
+		"SELECT 1 FROM blocks WHERE blocker_id IN ("+placeholders(len(members))+") AND blocked_id IN ("+placeholders(len(members))+") LIMIT 1",
+		args...,
+	).Scan(&blocked)
+	if err == nil {
+		return membersBlockedError
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	return nil
+}
+
+func (db *SQLiteDB) CreateConversation(creatorId int, memberIds []int) (Conversation, bool, error) {
+	members, err := conversationMembers(creatorId, memberIds)
+	if err != nil {
+		return Conversation{}, false, err
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Conversation{}, false, err
+	}
+	defer tx.Rollback()
+
+	for _, memberId := range members {
+		err = userExists(tx, memberId)
+		if errors.Is(err, NotFoundError{}) {
+			return Conversation{}, false, ValidationError{message: fmt.Sprintf("user %d does not exist", memberId)}
+		}
+		if err != nil {
+			return Conversation{}, false, err
+		}
+	}
+	err = sqliteCheckMemberBlocks(tx, creatorId, members)
+	if err != nil {
+		return Conversation{}, false, err
+	}
+
+	if len(members) == 2 {
+		var existing int
+		err = tx.QueryRow(
+			`SELECT conversation_id FROM conversation_members
+			GROUP BY conversation_id
+			HAVING COUNT(*) = 2 AND SUM(user_id IN (?, ?)) = 2`,
+			members[0], members[1],
+		).Scan(&existing)
+		if err == nil {
+			conversation, _, err := selectConversation(tx, existing, creatorId)
+			return conversation, false, err
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return Conversation{}, false, err
+		}
+	}
+
+	now := time.Now().UTC()
+	res, err := tx.Exec("INSERT INTO conversations (created_at, updated_at) VALUES (?, ?)", now.UnixNano(), now.UnixNano())
+	if err != nil {
+		return Conversation{}, false, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Conversation{}, false, err
+	}
+	for _, memberId := range members {
+		_, err = tx.Exec(
+			"INSERT INTO conversation_members (conversation_id, user_id, joined_at) VALUES (?, ?, ?)",
+			id, memberId, now.UnixNano(),
+		)
+		if err != nil {
+			return Conversation{}, false, err
+		}
+	}
+
+	conversation, _, err := selectConversation(tx, int(id), creatorId)
+	if err != nil {
+		return Conversation{}, false, err
+	}
+	return conversation, true, tx.Commit()
+}
+
+func (db *SQLiteDB) GetConversations(userId int) ([]Conversation, error) {
+	tx, err := db.conn.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		`SELECT id FROM conversations
+		JOIN conversation_members ON conversation_members.conversation_id = conversations.id
+		WHERE conversation_members.user_id = ?
+		ORDER BY conversations.updated_at DESC, conversations.id DESC`,
+		userId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	conversations := make([]Conversation, 0, len(ids))
+	for _, id := range ids {
+		conversation, _, err := selectConversation(tx, id, userId)
+		if err != nil {
+			return nil, err
+		}
+		conversations = append(conversations, conversation)
+	}
+	return conversations, nil
+}
+
+func (db *SQLiteDB) GetConversation(id int, userId int) (Conversation, error) {
+	tx, err := db.conn.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return Conversation{}, err
+	}
+	defer tx.Rollback()
+
+	conversation, _, err := selectConversation(tx, id, userId)
+	return conversation, err
+}
+
+func (db *SQLiteDB) SendMessage(conversationId int, senderId int, body string) (Message, error) {
+	err := checkMessageBody(body)
+	if err != nil {
+		return Message{}, err
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Message{}, err
+	}
+	defer tx.Rollback()
+
+	conversation, _, err := selectConversation(tx, conversationId, senderId)
+	if err != nil {
+		return Message{}, err
+	}
+	memberIds := make([]int, 0, len(conversation.Members))
+	for _, member := range conversation.Members {
+		memberIds = append(memberIds, member.UserId)
+	}
+	err = sqliteCheckBlocks(tx, senderId, memberIds)
+	if err != nil {
+		return Message{}, err
+	}
+
+	now := time.Now().UTC()
+	res, err := tx.Exec(
+		"INSERT INTO messages (conversation_id, sender_id, body, created_at) VALUES (?, ?, ?, ?)",
+		conversationId, senderId, body, now.UnixNano(),
+	)
+	if err != nil {
+		return Message{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Message{}, err
+	}
+
+	_, err = tx.Exec("UPDATE conversations SET updated_at = ? WHERE id = ?", now.UnixNano(), conversationId)
+	if err != nil {
+		return Message{}, err
+	}
+	_, err = tx.Exec(
+		"UPDATE conversation_members SET last_read_message_id = ?, read_at = ? WHERE conversation_id = ? AND user_id = ?",
+		id, now.UnixNano(), conversationId, senderId,
+	)
+	if err != nil {
+		return Message{}, err
+	}
+
+	message := Message{
+		Id:             int(id),
+		ConversationId: conversationId,
+		SenderId:       senderId,
+		Body:           body,
+		CreatedAt:      now,
+	}
+	return message, tx.Commit()
+}
+
+func (db *SQLiteDB) GetMessages(conversationId int, userId int, query MessageQuery) (MessagePage, error) {
+	before, err := decodeMessageCursor(query.Cursor)
+	if err != nil {
+		return MessagePage{}, err
+	}
+
+	var member int
+	err = db.conn.QueryRow(
+		"SELECT 1 FROM conversation_members WHERE conversation_id = ? AND user_id = ?",
+		conversationId, userId,
+	).Scan(&member)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Tell a missing conversation apart from one the user is not in.
+		_, _, err = selectConversation(db.conn, conversationId, userId)
+		return MessagePage{}, err
+	}
+	if err != nil {
+		return MessagePage{}, err
+	}
+
+	condition := ""
+	args := []any{conversationId}
+	if before != 0 {
+		condition = " AND id < ?"
+		args = append(args, before)
+	}
+	args = append(args, query.Limit+1)
+
+	rows, err := db.conn.Query(
+		"SELECT "+messageColumns+" FROM messages WHERE conversation_id = ?"+condition+" ORDER BY id DESC LIMIT ?",
+		args...,
+	)
+	if err != nil {
+		return MessagePage{}, err
+	}
+	defer rows.Close()
+
+	messages := []Message{}
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			return MessagePage{}, err
+		}
+		messages = append(messages, message)
+	}
+	if err = rows.Err(); err != nil {
+		return MessagePage{}, err
+	}
+	return toMessagePage(messages, query.Limit), nil
+}
+
+func (db *SQLiteDB) MarkConversationRead(conversationId int, userId int, messageId int) (Conversation, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Conversation{}, err
+	}
+	defer tx.Rollback()
+
+	conversation, _, err := selectConversation(tx, conversationId, userId)
+	if err != nil {
+		return Conversation{}, err
+	}
+
+	if messageId == 0 {
+		if conversation.LastMessage == nil {
+			return conversation, nil
+		}
+		messageId = conversation.LastMessage.Id
+	} else {
+		var exists int
+		err = tx.QueryRow("SELECT 1 FROM messages WHERE id = ? AND conversation_id = ?", messageId, conversationId).Scan(&exists)
+		if errors.Is(err, sql.ErrNoRows) {
+			return Conversation{}, ValidationError{message: fmt.Sprintf("message %d is not part of this conversation", messageId)}
+		}
+		if err != nil {
+			return Conversation{}, err
+		}
+	}
+
+	_, err = tx.Exec(
+		`UPDATE conversation_members SET last_read_message_id = ?, read_at = ?
+		WHERE conversation_id = ? AND user_id = ? AND last_read_message_id < ?`,
+		messageId, time.Now().UTC().UnixNano(), conversationId, userId, messageId,
+	)
+	if err != nil {
+		return Conversation{}, err
+	}
+
+	conversation, _, err = selectConversation(tx, conversationId, userId)
+	if err != nil {
+		return Conversation{}, err
+	}
+	return conversation, tx.Commit()
+}
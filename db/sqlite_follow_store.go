@@ -0,0 +1,126 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	. "github.com/ortin779/chirpy/models"
+)
+
+func (db *SQLiteDB) FollowUser(followerId int, followeeId int) (Follow, error) {
+	err := checkFollow(followerId, followeeId)
+	if err != nil {
+		return Follow{}, err
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Follow{}, err
+	}
+	defer tx.Rollback()
+
+	err = userExists(tx, followeeId)
+	if err != nil {
+		return Follow{}, err
+	}
+
+	res, err := tx.Exec(
+		"INSERT INTO follows (follower_id, followee_id, created_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+		followerId, followeeId, time.Now().UTC().UnixNano(),
+	)
+	if err != nil {
+		return Follow{}, err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return Follow{}, err
+	}
+	if inserted > 0 {
+		err = insertNotifications(tx, Notification{UserId: followeeId, Type: NotificationFollow, ActorId: followerId})
+		if err != nil {
+			return Follow{}, err
+		}
+	}
+
+	follow := Follow{FollowerId: followerId, FolloweeId: followeeId}
+	var createdAt int64
+	err = tx.QueryRow(
+		"SELECT created_at FROM follows WHERE follower_id = ? AND followee_id = ?",
+		followerId, followeeId,
+	).Scan(&createdAt)
+	if err != nil {
+		return Follow{}, err
+	}
+	follow.CreatedAt = fromUnixNano(createdAt)
+	return follow, tx.Commit()
+}
+
+func (db *SQLiteDB) UnfollowUser(followerId int, followeeId int) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = userExists(tx, followeeId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM follows WHERE follower_id = ? AND followee_id = ?", followerId, followeeId)
+	if err != nil {
+		return err
+	}
+	err = deleteUnreadNotification(tx, followeeId, NotificationFollow, followerId, 0)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *SQLiteDB) GetFollowers(userId int) ([]FollowUser, error) {
+	return db.followList(userId, "SELECT follower_id, created_at FROM follows WHERE followee_id = ? ORDER BY created_at DESC, follower_id DESC")
+}
+
+func (db *SQLiteDB) GetFollowing(userId int) ([]FollowUser, error) {
+	return db.followList(userId, "SELECT followee_id, created_at FROM follows WHERE follower_id = ? ORDER BY created_at DESC, followee_id DESC")
+}
+
+func (db *SQLiteDB) followList(userId int, query string) ([]FollowUser, error) {
+	err := userExists(db.conn, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.conn.Query(query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []FollowUser{}
+	for rows.Next() {
+		user := FollowUser{}
+		var followedAt int64
+		err = rows.Scan(&user.Id, &followedAt)
+		if err != nil {
+			return nil, err
+		}
+		user.FollowedAt = fromUnixNano(followedAt)
+		users = append(users, user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func userExists(q queryer, userId int) error {
+	var exists int
+	err := q.QueryRow("SELECT 1 FROM users WHERE id = ?", userId).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return NotFoundError{}
+	}
+	return err
+}
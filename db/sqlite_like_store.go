@@ -0,0 +1,139 @@
+package db
+
+import (
+	"time"
+
+	. "github.com/ortin779/chirpy/models"
+)
+
+func (db *SQLiteDB) LikeChirp(chirpId int, userId int) (Chirp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	liked, err := selectLiveChirp(tx, chirpId)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	res, err := tx.Exec(
+		"INSERT INTO likes (chirp_id, user_id, created_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+		chirpId, userId, time.Now().UTC().UnixNano(),
+	)
+	if err != nil {
+		return Chirp{}, err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return Chirp{}, err
+	}
+	if inserted > 0 && liked.AuthorId != userId {
+		err = insertNotifications(tx, Notification{UserId: liked.AuthorId, Type: NotificationLike, ActorId: userId, ChirpId: chirpId})
+		if err != nil {
+			return Chirp{}, err
+		}
+	}
+
+	chirp, err := selectChirp(tx, chirpId)
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp.LikedByMe = true
+	return chirp, tx.Commit()
+}
+
+func (db *SQLiteDB) UnlikeChirp(chirpId int, userId int) (Chirp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	liked, err := selectLiveChirp(tx, chirpId)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	_, err = tx.Exec("DELETE FROM likes WHERE chirp_id = ? AND user_id = ?", chirpId, userId)
+	if err != nil {
+		return Chirp{}, err
+	}
+	err = deleteUnreadNotification(tx, liked.AuthorId, NotificationLike, userId, chirpId)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	chirp, err := selectChirp(tx, chirpId)
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, tx.Commit()
+}
+
+func (db *SQLiteDB) GetLikedChirps(userId int) ([]Chirp, error) {
+	err := userExists(db.conn, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.conn.Query(
+		`SELECT `+chirpColumns+` FROM chirps
+		JOIN (SELECT chirp_id, created_at AS liked_at FROM likes WHERE user_id = ?) AS liked ON liked.chirp_id = chirps.id
+		WHERE deleted_at IS NULL
+		ORDER BY liked.liked_at DESC, liked.chirp_id DESC`,
+		userId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chirps := []Chirp{}
+	for rows.Next() {
+		chirp, err := scanChirp(rows)
+		if err != nil {
+			return nil, err
+		}
+		chirps = append(chirps, chirp)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return chirps, nil
+}
+
+func (db *SQLiteDB) HasLiked(userId int, chirpIds []int) (map[int]bool, error) {
+	liked := make(map[int]bool)
+
+	for _, batch := range idBatches(chirpIds) {
+		args := []any{userId}
+		for _, chirpId := range batch {
+			args = append(args, chirpId)
+		}
+		rows, err := db.conn.Query(
+			"SELECT chirp_id FROM likes WHERE user_id = ? AND chirp_id IN ("+placeholders(len(batch))+")",
+			args...,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var chirpId int
+			err = rows.Scan(&chirpId)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			liked[chirpId] = true
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return liked, nil
+}
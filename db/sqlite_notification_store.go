@@ -0,0 +1,131 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	. "github.com/ortin779/chirpy/models"
+)
+
+// visibleNotifications is the condition leaving out notifications about
+// deleted chirps.
+const visibleNotifications = `(chirp_id IS NULL OR EXISTS (
+	SELECT 1 FROM chirps WHERE chirps.id = notifications.chirp_id AND chirps.deleted_at IS NULL
+))`
+
+func insertNotifications(tx *sql.Tx, notifications ...Notification) error {
+	now := time.Now().UTC().UnixNano()
+	for _, notification := range notifications {
+		_, err := tx.Exec(
+			"INSERT INTO notifications (user_id, type, actor_id, chirp_id, created_at) VALUES (?, ?, ?, ?, ?)",
+			notification.UserId, notification.Type, notification.ActorId, nullId(notification.ChirpId), now,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteUnreadNotification(tx *sql.Tx, userId int, notificationType string, actorId int, chirpId int) error {
+	_, err := tx.Exec(
+		`DELETE FROM notifications
+		WHERE user_id = ? AND type = ? AND actor_id = ? AND IFNULL(chirp_id, 0) = ? AND read_at IS NULL`,
+		userId, notificationType, actorId, chirpId,
+	)
+	return err
+}
+
+func (db *SQLiteDB) GetNotifications(userId int, limit int) ([]Notification, error) {
+	rows, err := db.conn.Query(
+		`SELECT id, user_id, type, actor_id, chirp_id, created_at, read_at FROM notifications
+		WHERE user_id = ? AND `+visibleNotifications+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?`,
+		userId, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []Notification{}
+	for rows.Next() {
+		notification := Notification{}
+		var chirpId, readAt sql.NullInt64
+		var createdAt int64
+		err = rows.Scan(
+			&notification.Id, &notification.UserId, &notification.Type, &notification.ActorId,
+			&chirpId, &createdAt, &readAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		notification.ChirpId = int(chirpId.Int64)
+		notification.CreatedAt = fromUnixNano(createdAt)
+		if readAt.Valid {
+			read := fromUnixNano(readAt.Int64)
+			notification.ReadAt = &read
+		}
+		notifications = append(notifications, notification)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return notifications, nil
+}
+
+func (db *SQLiteDB) CountUnreadNotifications(userId int) (int, error) {
+	var count int
+	err := db.conn.QueryRow(
+		"SELECT COUNT(*) FROM notifications WHERE user_id = ? AND read_at IS NULL AND "+visibleNotifications,
+		userId,
+	).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (db *SQLiteDB) MarkNotificationsRead(userId int, ids []int) (int, error) {
+	now := time.Now().UTC().UnixNano()
+
+	if len(ids) == 0 {
+		res, err := db.conn.Exec(
+			"UPDATE notifications SET read_at = ? WHERE user_id = ? AND read_at IS NULL",
+			now, userId,
+		)
+		if err != nil {
+			return 0, err
+		}
+		marked, err := res.RowsAffected()
+		return int(marked), err
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	marked := 0
+	for _, batch := range idBatches(ids) {
+		args := []any{now, userId}
+		for _, id := range batch {
+			args = append(args, id)
+		}
+		res, err := tx.Exec(
+			"UPDATE notifications SET read_at = ? WHERE user_id = ? AND read_at IS NULL AND id IN ("+placeholders(len(batch))+")",
+			args...,
+		)
+		if err != nil {
+			return 0, err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		marked += int(affected)
+	}
+	return marked, tx.Commit()
+}
@@ -0,0 +1,120 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/ortin779/chirpy/entities"
+	. "github.com/ortin779/chirpy/models"
+)
+
+func (db *SQLiteDB) GetTrendingTags(since time.Time, limit int) ([]TagCount, error) {
+	rows, err := db.conn.Query(
+		`SELECT chirp_tags.tag, COUNT(*) AS uses FROM chirp_tags
+		JOIN chirps ON chirps.id = chirp_tags.chirp_id
+		WHERE chirp_tags.created_at >= ? AND chirps.deleted_at IS NULL
+		GROUP BY chirp_tags.tag
+		ORDER BY uses DESC, chirp_tags.tag ASC
+		LIMIT ?`,
+		since.UnixNano(), limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	trending := []TagCount{}
+	for rows.Next() {
+		tag := TagCount{}
+		err = rows.Scan(&tag.Tag, &tag.Count)
+		if err != nil {
+			return nil, err
+		}
+		trending = append(trending, tag)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return trending, nil
+}
+
+// sqliteEntities parses body within tx, resolving mentions against the
+// handles of the users.
+func sqliteEntities(tx *sql.Tx, body string) ([]Entity, error) {
+	return parseEntities(body, func(handle string) (int, error) {
+		var id int
+		err := tx.QueryRow("SELECT id FROM users WHERE handle != '' AND lower(handle) = lower(?)", handle).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		return id, err
+	})
+}
+
+// writeEntities stores the entities of chirp and replaces its rows in the
+// tag index.
+func writeEntities(tx *sql.Tx, chirp Chirp) error {
+	encoded, err := json.Marshal(chirp.Entities)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE chirps SET entities = ? WHERE id = ?", string(encoded), chirp.Id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM chirp_tags WHERE chirp_id = ?", chirp.Id)
+	if err != nil {
+		return err
+	}
+	for _, tag := range entities.Tags(chirp.Entities) {
+		_, err = tx.Exec(
+			"INSERT INTO chirp_tags (tag, chirp_id, created_at) VALUES (?, ?, ?)",
+			tag, chirp.Id, chirp.CreatedAt.UnixNano(),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillEntities parses the bodies of the chirps written before entities
+// were extracted. Users had no handles, the mentions are left unresolved.
+func backfillEntities(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT id, body, created_at FROM chirps")
+	if err != nil {
+		return err
+	}
+	chirps := []Chirp{}
+	for rows.Next() {
+		chirp := Chirp{}
+		var createdAt int64
+		err = rows.Scan(&chirp.Id, &chirp.Body, &createdAt)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		chirp.CreatedAt = fromUnixNano(createdAt)
+		chirps = append(chirps, chirp)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, chirp := range chirps {
+		chirp.Entities, err = parseEntities(chirp.Body, unresolved)
+		if err != nil {
+			return err
+		}
+		err = writeEntities(tx, chirp)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
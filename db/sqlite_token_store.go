@@ -0,0 +1,399 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/ortin779/chirpy/models"
+)
+
+func sqliteStartFamily(tx *sql.Tx, userId int, client models.Client) (string, error) {
+	familyId := randomId()
+	now := time.Now().UTC().UnixNano()
+	_, err := tx.Exec(
+		`INSERT INTO token_families (id, user_id, user_agent, ip, created_at, last_used_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		familyId, userId, client.UserAgent, client.IP, now, now,
+	)
+	if err != nil {
+		return "", err
+	}
+	return familyId, nil
+}
+
+func insertRefreshToken(tx *sql.Tx, rToken models.RefreshToken) error {
+	_, err := tx.Exec(
+		`INSERT INTO refresh_tokens (id, user_id, has_revoked, family_id, replaced_by, access_token_id, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		rToken.Id, rToken.UserId, rToken.HasRevoked, rToken.FamilyId, rToken.ReplacedBy, rToken.AccessTokenId,
+		rToken.CreatedAt.UnixNano(), rToken.ExpiresAt.UnixNano(),
+	)
+	return err
+}
+
+// sqliteDenyAccessTokens puts the access tokens handed out with the
+// refresh tokens matching condition on the denylist, unless they have
+// already expired.
+func sqliteDenyAccessTokens(tx *sql.Tx, condition string, args ...any) error {
+	expiry := int64(accessTokenExpiry)
+	_, err := tx.Exec(
+		`INSERT INTO revoked_access_tokens (id, user_id, expires_at)
+		SELECT access_token_id, user_id, created_at + ? FROM refresh_tokens
+		WHERE access_token_id != '' AND created_at + ? > ? AND `+condition+`
+		ON CONFLICT DO NOTHING`,
+		append([]any{expiry, expiry, time.Now().UTC().UnixNano()}, args...)...,
+	)
+	return err
+}
+
+func sqliteRevokeFamily(tx *sql.Tx, familyId string) error {
+	_, err := tx.Exec(
+		"UPDATE token_families SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL",
+		time.Now().UTC().UnixNano(), familyId,
+	)
+	if err != nil {
+		return err
+	}
+	err = sqliteDenyAccessTokens(tx, "family_id = ?", familyId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE refresh_tokens SET has_revoked = 1 WHERE family_id = ?", familyId)
+	return err
+}
+
+// sqliteRevokeUserTokens revokes every session of the user, and the tokens
+// handed out before rotation that have no family. It returns the number
+// of sessions that were revoked.
+func sqliteRevokeUserTokens(tx *sql.Tx, userId int) (int, error) {
+	rows, err := tx.Query("SELECT id FROM token_families WHERE user_id = ? AND revoked_at IS NULL", userId)
+	if err != nil {
+		return 0, err
+	}
+	familyIds := []string{}
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		familyIds = append(familyIds, id)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, id := range familyIds {
+		err = sqliteRevokeFamily(tx, id)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = sqliteDenyAccessTokens(tx, "user_id = ? AND family_id = '' AND has_revoked = 0", userId)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec("UPDATE refresh_tokens SET has_revoked = 1 WHERE user_id = ? AND family_id = ''", userId)
+	if err != nil {
+		return 0, err
+	}
+	return len(familyIds), nil
+}
+
+func (db *SQLiteDB) RefreshToken(token string, client models.Client) (models.RefreshTokenResponse, error) {
+	parsedToken, err := parseRefreshToken(token)
+	if err != nil {
+		return models.RefreshTokenResponse{}, err
+	}
+	userId, err := parsedToken.Claims.GetSubject()
+	if err != nil {
+		return models.RefreshTokenResponse{}, errors.New("invalid refresh token claims")
+	}
+	parsedUserId, err := strconv.Atoi(userId)
+	if err != nil {
+		return models.RefreshTokenResponse{}, errors.New("invalid refresh token claims")
+	}
+
+	accessToken, accessTokenId, err := createAccessToken(userId)
+	if err != nil {
+		return models.RefreshTokenResponse{}, err
+	}
+	refreshToken, next, err := createRefreshToken(parsedUserId)
+	if err != nil {
+		return models.RefreshTokenResponse{}, err
+	}
+	next.AccessTokenId = accessTokenId
+
+	id := hashToken(parsedToken.Raw)
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return models.RefreshTokenResponse{}, err
+	}
+	defer tx.Rollback()
+
+	var hasRevoked bool
+	var familyId, replacedBy string
+	err = tx.QueryRow(
+		"SELECT has_revoked, family_id, replaced_by FROM refresh_tokens WHERE id = ?",
+		id,
+	).Scan(&hasRevoked, &familyId, &replacedBy)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.RefreshTokenResponse{}, AuthenticationError{message: "invalid refresh token"}
+	}
+	if err != nil {
+		return models.RefreshTokenResponse{}, err
+	}
+
+	if replacedBy != "" {
+		err = sqliteRevokeFamily(tx, familyId)
+		if err != nil {
+			return models.RefreshTokenResponse{}, err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return models.RefreshTokenResponse{}, err
+		}
+		return models.RefreshTokenResponse{}, reusedTokenError
+	}
+	if hasRevoked {
+		return models.RefreshTokenResponse{}, AuthenticationError{message: "refresh token revoked"}
+	}
+
+	if familyId == "" {
+		familyId, err = sqliteStartFamily(tx, parsedUserId, client)
+	} else {
+		_, err = tx.Exec(
+			"UPDATE token_families SET user_agent = ?, ip = ?, last_used_at = ? WHERE id = ?",
+			client.UserAgent, client.IP, time.Now().UTC().UnixNano(), familyId,
+		)
+	}
+	if err != nil {
+		return models.RefreshTokenResponse{}, err
+	}
+	_, err = tx.Exec(
+		"UPDATE refresh_tokens SET has_revoked = 1, family_id = ?, replaced_by = ? WHERE id = ?",
+		familyId, next.Id, id,
+	)
+	if err != nil {
+		return models.RefreshTokenResponse{}, err
+	}
+	next.FamilyId = familyId
+	err = insertRefreshToken(tx, next)
+	if err != nil {
+		return models.RefreshTokenResponse{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return models.RefreshTokenResponse{}, err
+	}
+	return models.RefreshTokenResponse{Token: accessToken, RefreshToken: refreshToken}, nil
+}
+
+func (db *SQLiteDB) RevokeToken(token string) error {
+	parsedToken, err := parseRefreshToken(token)
+	if err != nil {
+		return err
+	}
+
+	id := hashToken(parsedToken.Raw)
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var hasRevoked bool
+	var familyId string
+	err = tx.QueryRow("SELECT has_revoked, family_id FROM refresh_tokens WHERE id = ?", id).Scan(&hasRevoked, &familyId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return AuthenticationError{message: "invalid refresh token"}
+	}
+	if err != nil {
+		return err
+	}
+
+	if hasRevoked {
+		return AuthenticationError{message: "token has been revoked"}
+	}
+
+	if familyId != "" {
+		err = sqliteRevokeFamily(tx, familyId)
+	} else {
+		err = sqliteDenyAccessTokens(tx, "id = ?", id)
+		if err == nil {
+			_, err = tx.Exec("UPDATE refresh_tokens SET has_revoked = 1 WHERE id = ?", id)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *SQLiteDB) PurgeRefreshTokens(now time.Time) (int, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		`DELETE FROM refresh_tokens
+		WHERE expires_at <= ?
+		OR (has_revoked = 1 AND (family_id = ''
+			OR family_id NOT IN (SELECT id FROM token_families WHERE revoked_at IS NULL)))`,
+		now.UnixNano(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	purged, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec("DELETE FROM token_families WHERE id NOT IN (SELECT family_id FROM refresh_tokens)")
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec("DELETE FROM revoked_access_tokens WHERE expires_at <= ?", now.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	return int(purged), tx.Commit()
+}
+
+func (db *SQLiteDB) GetSessions(userId int) ([]models.Session, error) {
+	rows, err := db.conn.Query(
+		`SELECT token_families.id, user_agent, ip, token_families.created_at, last_used_at, refresh_tokens.expires_at
+		FROM refresh_tokens
+		JOIN token_families ON token_families.id = refresh_tokens.family_id
+		WHERE refresh_tokens.user_id = ? AND has_revoked = 0 AND expires_at > ? AND revoked_at IS NULL`,
+		userId, time.Now().UTC().UnixNano(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		session := models.Session{}
+		var createdAt, lastUsedAt, expiresAt int64
+		err = rows.Scan(&session.Id, &session.UserAgent, &session.IP, &createdAt, &lastUsedAt, &expiresAt)
+		if err != nil {
+			return nil, err
+		}
+		session.CreatedAt = fromUnixNano(createdAt)
+		session.LastUsedAt = fromUnixNano(lastUsedAt)
+		session.ExpiresAt = fromUnixNano(expiresAt)
+		sessions = append(sessions, session)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sortSessions(sessions)
+	return sessions, nil
+}
+
+func (db *SQLiteDB) RevokeSession(userId int, id string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists int
+	err = tx.QueryRow(
+		"SELECT 1 FROM token_families WHERE id = ? AND user_id = ? AND revoked_at IS NULL",
+		id, userId,
+	).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return NotFoundError{}
+	}
+	if err != nil {
+		return err
+	}
+
+	err = sqliteRevokeFamily(tx, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *SQLiteDB) RevokeAllSessions(userId int) (int, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	revoked, err := sqliteRevokeUserTokens(tx, userId)
+	if err != nil {
+		return 0, err
+	}
+	return revoked, tx.Commit()
+}
+
+func (db *SQLiteDB) IsAccessTokenRevoked(jti string) (bool, error) {
+	var revoked bool
+	err := db.conn.QueryRow("SELECT EXISTS (SELECT 1 FROM revoked_access_tokens WHERE id = ?)", jti).Scan(&revoked)
+	return revoked, err
+}
+
+// hashRefreshTokens rekeys the refresh tokens stored before tokens were
+// hashed and fills in their owner, creation and expiry from their claims.
+// Tokens that cannot be decoded could never be used and are dropped.
+func hashRefreshTokens(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT id, replaced_by FROM refresh_tokens")
+	if err != nil {
+		return err
+	}
+	tokens := [][2]string{}
+	for rows.Next() {
+		var id, replacedBy string
+		err = rows.Scan(&id, &replacedBy)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		tokens = append(tokens, [2]string{id, replacedBy})
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		id, replacedBy := token[0], token[1]
+		rToken, ok := legacyRefreshToken(id)
+		if !ok {
+			_, err = tx.Exec("DELETE FROM refresh_tokens WHERE id = ?", id)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if replacedBy != "" {
+			replacedBy = hashToken(replacedBy)
+		}
+		_, err = tx.Exec(
+			"UPDATE refresh_tokens SET id = ?, replaced_by = ?, user_id = ?, created_at = ?, expires_at = ? WHERE id = ?",
+			rToken.Id, replacedBy, rToken.UserId, rToken.CreatedAt.UnixNano(), rToken.ExpiresAt.UnixNano(), id,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,287 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ortin779/chirpy/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const userColumns = "id, email, handle, password, is_chirpy_red, created_at, updated_at, suspended_at"
+
+func scanUser(row rowScanner) (models.User, error) {
+	user := models.User{}
+	var createdAt, updatedAt int64
+	var suspendedAt sql.NullInt64
+	err := row.Scan(&user.Id, &user.Email, &user.Handle, &user.Password, &user.IsChirpyRed, &createdAt, &updatedAt, &suspendedAt)
+	user.CreatedAt = fromUnixNano(createdAt)
+	user.UpdatedAt = fromUnixNano(updatedAt)
+	if suspendedAt.Valid {
+		suspended := fromUnixNano(suspendedAt.Int64)
+		user.SuspendedAt = &suspended
+	}
+	return user, err
+}
+
+func (db *SQLiteDB) CreateUser(userBody models.UserRequestBody) (models.UserResponse, error) {
+	err := checkHandle(userBody.Handle)
+	if err != nil {
+		return models.UserResponse{}, err
+	}
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return models.UserResponse{}, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE email = ?)", userBody.Email).Scan(&exists)
+	if err != nil {
+		return models.UserResponse{}, err
+	}
+	if exists {
+		return models.UserResponse{}, fmt.Errorf("user already exist with given email")
+	}
+	err = sqliteCheckHandleFree(tx, userBody.Handle, 0)
+	if err != nil {
+		return models.UserResponse{}, err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(userBody.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return models.UserResponse{}, err
+	}
+	now := time.Now().UTC()
+	res, err := tx.Exec(
+		"INSERT INTO users (email, handle, password, created_at, updated_at) VALUES (?, ?, ?, ?, ?)",
+		userBody.Email, userBody.Handle, string(hashedPassword), now.UnixNano(), now.UnixNano(),
+	)
+	if err != nil {
+		return models.UserResponse{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return models.UserResponse{}, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return models.UserResponse{}, err
+	}
+	return models.UserResponse{
+		Id:        int(id),
+		Email:     userBody.Email,
+		Handle:    userBody.Handle,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}, nil
+}
+
+func (db *SQLiteDB) UpdateUser(userBody models.UserRequestBody, userId string) (models.UserResponse, error) {
+	parsedId, err := strconv.Atoi(userId)
+	if err != nil {
+		return models.UserResponse{}, fmt.Errorf("invalid user id")
+	}
+	err = checkHandle(userBody.Handle)
+	if err != nil {
+		return models.UserResponse{}, err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(userBody.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return models.UserResponse{}, err
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return models.UserResponse{}, err
+	}
+	defer tx.Rollback()
+
+	// The stored hash is compared in the same transaction, so a concurrent
+	// change of the password cannot be missed.
+	var currentPassword string
+	err = tx.QueryRow("SELECT password FROM users WHERE id = ?", parsedId).Scan(&currentPassword)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.UserResponse{}, NotFoundError{}
+	}
+	if err != nil {
+		return models.UserResponse{}, err
+	}
+	passwordChanged := bcrypt.CompareHashAndPassword([]byte(currentPassword), []byte(userBody.Password)) != nil
+
+	err = sqliteCheckHandleFree(tx, userBody.Handle, parsedId)
+	if err != nil {
+		return models.UserResponse{}, err
+	}
+	user, err := scanUser(tx.QueryRow(
+		"UPDATE users SET email = ?, handle = ?, password = ?, updated_at = ? WHERE id = ? RETURNING "+userColumns,
+		userBody.Email, userBody.Handle, string(hashedPassword), time.Now().UTC().UnixNano(), parsedId,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.UserResponse{}, NotFoundError{}
+	}
+	if err != nil {
+		return models.UserResponse{}, err
+	}
+
+	// Whoever got hold of the old password may be logged in.
+	if passwordChanged {
+		_, err = sqliteRevokeUserTokens(tx, parsedId)
+		if err != nil {
+			return models.UserResponse{}, err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return models.UserResponse{}, err
+	}
+	return toUserResponse(user), nil
+}
+
+func (db *SQLiteDB) LoginUser(userBody models.UserRequestBody, client models.Client) (models.UserLoginResponse, error) {
+	user, err := scanUser(db.conn.QueryRow("SELECT "+userColumns+" FROM users WHERE email = ?", userBody.Email))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.UserLoginResponse{}, AuthenticationError{message: fmt.Sprintf("no user with given email %s", userBody.Email)}
+	}
+	if err != nil {
+		return models.UserLoginResponse{}, err
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(userBody.Password))
+	if err != nil {
+		return models.UserLoginResponse{}, AuthenticationError{message: fmt.Sprintf("invalid password for user with email %s", userBody.Email)}
+	}
+	if user.SuspendedAt != nil {
+		return models.UserLoginResponse{}, suspendedError
+	}
+
+	accessToken, accessTokenId, err := createAccessToken(strconv.Itoa(user.Id))
+	if err != nil {
+		return models.UserLoginResponse{}, err
+	}
+	refreshToken, rToken, err := createRefreshToken(user.Id)
+	if err != nil {
+		return models.UserLoginResponse{}, err
+	}
+	rToken.AccessTokenId = accessTokenId
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return models.UserLoginResponse{}, err
+	}
+	defer tx.Rollback()
+
+	rToken.FamilyId, err = sqliteStartFamily(tx, user.Id, client)
+	if err != nil {
+		return models.UserLoginResponse{}, err
+	}
+	err = insertRefreshToken(tx, rToken)
+	if err != nil {
+		return models.UserLoginResponse{}, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return models.UserLoginResponse{}, err
+	}
+	return models.UserLoginResponse{
+		Id:           user.Id,
+		Email:        user.Email,
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		IsChirpyRed:  user.IsChirpyRed,
+	}, nil
+}
+
+func (db *SQLiteDB) MarkUserAsRedChirp(userId int) error {
+	res, err := db.conn.Exec("UPDATE users SET is_chirpy_red = 1, updated_at = ? WHERE id = ?", time.Now().UTC().UnixNano(), userId)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return NotFoundError{}
+	}
+	return nil
+}
+
+func (db *SQLiteDB) SuspendUser(userId int) (models.UserResponse, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return models.UserResponse{}, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC().UnixNano()
+	user, err := scanUser(tx.QueryRow(
+		`UPDATE users SET suspended_at = COALESCE(suspended_at, ?),
+			updated_at = CASE WHEN suspended_at IS NULL THEN ? ELSE updated_at END
+		WHERE id = ? RETURNING `+userColumns,
+		now, now, userId,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.UserResponse{}, NotFoundError{}
+	}
+	if err != nil {
+		return models.UserResponse{}, err
+	}
+
+	_, err = sqliteRevokeUserTokens(tx, userId)
+	if err != nil {
+		return models.UserResponse{}, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return models.UserResponse{}, err
+	}
+	return toUserResponse(user), nil
+}
+
+func (db *SQLiteDB) UnsuspendUser(userId int) (models.UserResponse, error) {
+	user, err := scanUser(db.conn.QueryRow(
+		`UPDATE users SET suspended_at = NULL,
+			updated_at = CASE WHEN suspended_at IS NULL THEN updated_at ELSE ? END
+		WHERE id = ? RETURNING `+userColumns,
+		time.Now().UTC().UnixNano(), userId,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.UserResponse{}, NotFoundError{}
+	}
+	if err != nil {
+		return models.UserResponse{}, err
+	}
+	return toUserResponse(user), nil
+}
+
+func (db *SQLiteDB) IsUserSuspended(userId int) (bool, error) {
+	var suspended bool
+	err := db.conn.QueryRow("SELECT suspended_at IS NOT NULL FROM users WHERE id = ?", userId).Scan(&suspended)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, NotFoundError{}
+	}
+	return suspended, err
+}
+
+// sqliteCheckHandleFree returns handleTakenError if a user other than
+// userId has the handle.
+func sqliteCheckHandleFree(tx *sql.Tx, handle string, userId int) error {
+	if handle == "" {
+		return nil
+	}
+	var taken bool
+	err := tx.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM users WHERE handle != '' AND lower(handle) = lower(?) AND id != ?)",
+		handle, userId,
+	).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return handleTakenError
+	}
+	return nil
+}
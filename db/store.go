@@ -0,0 +1,97 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ortin779/chirpy/models"
+)
+
+// Store is the persistence layer used by the api handlers. The JSON file
+// backed DB and the SQLite backed SQLiteDB both implement it.
+type Store interface {
+	CreateChirp(chirp models.Chirp) (models.Chirp, error)
+	GetChirps(query models.ChirpQuery) (models.ChirpPage, error)
+	GetChirp(id int) (models.Chirp, error)
+	GetChirpsByIds(ids []int) (map[int]models.Chirp, error)
+	DeleteChirp(id int, authorId int) (models.Chirp, error)
+	UpdateChirp(id int, authorId int, body string, flagged bool) (models.Chirp, error)
+	GetChirpHistory(id int) ([]models.ChirpVersion, error)
+	RestoreChirp(id int, authorId int, window time.Duration) (models.Chirp, error)
+	PurgeDeletedChirps(before time.Time) (int, error)
+	GetThread(id int) (models.ChirpThread, error)
+	GetTrendingTags(since time.Time, limit int) ([]models.TagCount, error)
+
+	LikeChirp(chirpId int, userId int) (models.Chirp, error)
+	UnlikeChirp(chirpId int, userId int) (models.Chirp, error)
+	GetLikedChirps(userId int) ([]models.Chirp, error)
+	HasLiked(userId int, chirpIds []int) (map[int]bool, error)
+
+	FollowUser(followerId int, followeeId int) (models.Follow, error)
+	UnfollowUser(followerId int, followeeId int) error
+	GetFollowers(userId int) ([]models.FollowUser, error)
+	GetFollowing(userId int) ([]models.FollowUser, error)
+
+	GetNotifications(userId int, limit int) ([]models.Notification, error)
+	CountUnreadNotifications(userId int) (int, error)
+	MarkNotificationsRead(userId int, ids []int) (int, error)
+
+	CreateConversation(creatorId int, memberIds []int) (conversation models.Conversation, created bool, err error)
+	GetConversations(userId int) ([]models.Conversation, error)
+	GetConversation(id int, userId int) (models.Conversation, error)
+	SendMessage(conversationId int, senderId int, body string) (models.Message, error)
+	GetMessages(conversationId int, userId int, query models.MessageQuery) (models.MessagePage, error)
+	MarkConversationRead(conversationId int, userId int, messageId int) (models.Conversation, error)
+
+	BlockUser(blockerId int, blockedId int) (models.Block, error)
+	UnblockUser(blockerId int, blockedId int) error
+	GetBlocks(userId int) ([]models.Block, error)
+
+	CreateUser(userBody models.UserRequestBody) (models.UserResponse, error)
+	UpdateUser(userBody models.UserRequestBody, userId string) (models.UserResponse, error)
+	LoginUser(userBody models.UserRequestBody, client models.Client) (models.UserLoginResponse, error)
+	MarkUserAsRedChirp(userId int) error
+	// SuspendUser keeps the user from logging in and revokes all their
+	// sessions and access tokens.
+	SuspendUser(userId int) (models.UserResponse, error)
+	UnsuspendUser(userId int) (models.UserResponse, error)
+	// IsUserSuspended reports whether the user is suspended.
+	IsUserSuspended(userId int) (bool, error)
+
+	RefreshToken(token string, client models.Client) (models.RefreshTokenResponse, error)
+	RevokeToken(token string) error
+	// PurgeRefreshTokens deletes the refresh tokens that expired or were
+	// revoked by now and returns how many were deleted. Denylisted access
+	// tokens that expired are deleted along with them.
+	PurgeRefreshTokens(now time.Time) (int, error)
+	// IsAccessTokenRevoked reports whether the access token with the given
+	// jti is on the denylist.
+	IsAccessTokenRevoked(jti string) (bool, error)
+
+	GetSessions(userId int) ([]models.Session, error)
+	// RevokeSession revokes the session of the user with the given id.
+	RevokeSession(userId int, id string) error
+	// RevokeAllSessions revokes every session of the user and returns how
+	// many were revoked.
+	RevokeAllSessions(userId int) (int, error)
+
+	Close() error
+}
+
+const (
+	DriverJSON   = "json"
+	DriverSQLite = "sqlite"
+)
+
+// Open returns the Store for the given driver. An empty driver falls back
+// to the JSON file store.
+func Open(driver string, path string) (Store, error) {
+	switch driver {
+	case "", DriverJSON:
+		return NewDB(path)
+	case DriverSQLite:
+		return NewSQLiteDB(path)
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", driver)
+	}
+}
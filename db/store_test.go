@@ -0,0 +1,97 @@
+package db
+
+import (
+	"errors"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/ortin779/chirpy/models"
+)
+
+func TestStoreCRUD(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store, reopen func() Store) {
+		user, err := store.CreateUser(models.UserRequestBody{Email: "jane@example.com", Password: "secret"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = store.CreateUser(models.UserRequestBody{Email: "jane@example.com", Password: "other"})
+		if err == nil {
+			t.Error("CreateUser() accepted a taken email")
+		}
+		updated, err := store.UpdateUser(models.UserRequestBody{Email: "jane.doe@example.com", Password: "secret"}, strconv.Itoa(user.Id))
+		if err != nil || updated.Id != user.Id || updated.Email != "jane.doe@example.com" {
+			t.Errorf("UpdateUser() = %+v, %v", updated, err)
+		}
+		err = store.MarkUserAsRedChirp(user.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		first, err := store.CreateChirp(models.Chirp{Body: "hello", AuthorId: user.Id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := store.CreateChirp(models.Chirp{Body: "world", AuthorId: user.Id})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		store = reopen()
+		page, err := store.GetChirps(models.ChirpQuery{AuthorId: user.Id, Sort: "desc", Limit: 20})
+		if err != nil {
+			t.Fatal(err)
+		}
+		chirps := page.Chirps
+		if len(chirps) != 2 || chirps[0].Id != second.Id || chirps[1].Id != first.Id {
+			t.Errorf("GetChirps() = %+v, want chirps %d and %d, newest first", chirps, second.Id, first.Id)
+		}
+		got, err := store.GetChirp(first.Id)
+		if err != nil || got.Body != "hello" || got.AuthorId != user.Id {
+			t.Errorf("GetChirp() = %+v, %v", got, err)
+		}
+
+		_, err = store.DeleteChirp(first.Id, user.Id+1)
+		if !errors.As(err, &AuthorizationError{}) {
+			t.Errorf("DeleteChirp() by another user error = %v, want an AuthorizationError", err)
+		}
+		_, err = store.DeleteChirp(first.Id, user.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = store.GetChirp(first.Id)
+		if !errors.As(err, &GoneError{}) {
+			t.Errorf("GetChirp() of a deleted chirp error = %v, want a GoneError", err)
+		}
+	})
+}
+
+// eachStore runs fn as a subtest against an empty store of every driver.
+// reopen closes the store and opens the same database again.
+func eachStore(t *testing.T, fn func(t *testing.T, store Store, reopen func() Store)) {
+	for _, driver := range []string{DriverJSON, DriverSQLite} {
+		t.Run(driver, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "chirpy.db")
+			store := openStore(t, driver, path)
+			reopen := func() Store {
+				err := store.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				store = openStore(t, driver, path)
+				return store
+			}
+			t.Cleanup(func() { store.Close() })
+			fn(t, store, reopen)
+		})
+	}
+}
+
+func openStore(t *testing.T, driver string, path string) Store {
+	t.Helper()
+	store, err := Open(driver, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
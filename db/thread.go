@@ -0,0 +1,41 @@
+package db
+
+import (
+	"slices"
+
+	. "github.com/ortin779/chirpy/models"
+)
+
+// buildThread arranges a chirp, its ancestors ordered from the parent
+// upwards and all of its descendants into a thread. Deleted chirps are left
+// out, their replies move up to the closest ancestor that is not deleted.
+func buildThread(chirp Chirp, ancestors []Chirp, descendants []Chirp) ChirpThread {
+	thread := ChirpThread{Ancestors: []Chirp{}}
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		if ancestors[i].DeletedAt == nil {
+			thread.Ancestors = append(thread.Ancestors, ancestors[i])
+		}
+	}
+
+	replies := make(map[int][]Chirp)
+	for _, reply := range descendants {
+		replies[reply.ReplyTo] = append(replies[reply.ReplyTo], reply)
+	}
+
+	var collect func(id int) []ChirpNode
+	collect = func(id int) []ChirpNode {
+		nodes := []ChirpNode{}
+		for _, reply := range replies[id] {
+			if reply.DeletedAt != nil {
+				nodes = append(nodes, collect(reply.Id)...)
+				continue
+			}
+			nodes = append(nodes, ChirpNode{Chirp: reply, Replies: collect(reply.Id)})
+		}
+		slices.SortFunc(nodes, func(a, b ChirpNode) int { return a.Id - b.Id })
+		return nodes
+	}
+
+	thread.Chirp = ChirpNode{Chirp: chirp, Replies: collect(chirp.Id)}
+	return thread
+}
@@ -2,100 +2,305 @@ package db
 
 import (
 	"errors"
-	"fmt"
+	"strconv"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
-	"github.com/ortin779/chirpy/helpers"
 	"github.com/ortin779/chirpy/models"
 )
 
-func (db *DB) RefreshToken(token string) (models.RefreshTokenResponse, error) {
-	dbstruct, err := db.loadDB()
-	if err != nil {
-		return models.RefreshTokenResponse{}, err
+// reusedTokenError is returned when a refresh token that was already
+// rotated is presented again. Only a copy of the token can do that, so its
+// whole family has been revoked.
+var reusedTokenError = AuthenticationError{message: "refresh token reused, the session has been revoked"}
+
+// startFamily records a new token family for the user.
+func (tx *Tx) startFamily(userId int, client models.Client) string {
+	now := time.Now().UTC()
+	family := models.TokenFamily{
+		Id:         randomId(),
+		UserId:     userId,
+		UserAgent:  client.UserAgent,
+		IP:         client.IP,
+		CreatedAt:  now,
+		LastUsedAt: now,
+	}
+	tx.TokenFamilies[family.Id] = family
+	tx.Touch("token_families", family.Id)
+	return family.Id
+}
+
+// revokeFamily revokes the family and every token in it, and puts the
+// access tokens handed out with them on the denylist.
+func (tx *Tx) revokeFamily(familyId string) {
+	family, ok := tx.TokenFamilies[familyId]
+	if !ok {
+		return
+	}
+	now := time.Now().UTC()
+	if family.RevokedAt == nil {
+		family.RevokedAt = &now
+		tx.TokenFamilies[familyId] = family
+		tx.Touch("token_families", familyId)
 	}
 
-	parsedToken, err := helpers.ValidateToken(token)
+	for id, rToken := range tx.RefreshToken {
+		if rToken.FamilyId != familyId {
+			continue
+		}
+		tx.denyAccessToken(rToken, now)
+		if !rToken.HasRevoked {
+			rToken.HasRevoked = true
+			tx.RefreshToken[id] = rToken
+			tx.Touch("refresh_tokens", id)
+		}
+	}
+}
 
-	if err != nil {
-		return models.RefreshTokenResponse{}, AuthenticationError{message: err.Error()}
+// revokeUserTokens revokes every session of the user, and the tokens
+// handed out before rotation that have no family. It returns the number
+// of sessions that were revoked.
+func (tx *Tx) revokeUserTokens(userId int) int {
+	revoked := 0
+	for id, family := range tx.TokenFamilies {
+		if family.UserId == userId && family.RevokedAt == nil {
+			tx.revokeFamily(id)
+			revoked++
+		}
+	}
+
+	now := time.Now().UTC()
+	for id, rToken := range tx.RefreshToken {
+		if rToken.UserId == userId && rToken.FamilyId == "" && !rToken.HasRevoked {
+			tx.denyAccessToken(rToken, now)
+			rToken.HasRevoked = true
+			tx.RefreshToken[id] = rToken
+			tx.Touch("refresh_tokens", id)
+		}
+	}
+	return revoked
+}
+
+// denyAccessToken puts the access token handed out with the refresh token
+// on the denylist, unless it has already expired.
+func (tx *Tx) denyAccessToken(rToken models.RefreshToken, now time.Time) {
+	expiresAt := accessTokenExpiresAt(rToken)
+	if rToken.AccessTokenId == "" || !expiresAt.After(now) {
+		return
 	}
-	if !parsedToken.Valid {
-		return models.RefreshTokenResponse{}, AuthenticationError{message: "invalid refresh token"}
+	if _, ok := tx.RevokedAccessTokens[rToken.AccessTokenId]; ok {
+		return
 	}
-	issuer, err := parsedToken.Claims.GetIssuer()
+	tx.RevokedAccessTokens[rToken.AccessTokenId] = models.RevokedAccessToken{
+		Id:        rToken.AccessTokenId,
+		UserId:    rToken.UserId,
+		ExpiresAt: expiresAt,
+	}
+	tx.Touch("revoked_access_tokens", rToken.AccessTokenId)
+}
+
+// RefreshToken hands out a new access token and rotates the refresh token:
+// the presented token is revoked and replaced by a new one of its family.
+func (db *DB) RefreshToken(token string, client models.Client) (models.RefreshTokenResponse, error) {
+	parsedToken, err := parseRefreshToken(token)
 	if err != nil {
 		return models.RefreshTokenResponse{}, err
 	}
-	if issuer != "chirpy-refresh" {
-		return models.RefreshTokenResponse{}, AuthenticationError{message: "invalid refresh token issuer"}
-	}
 	userId, err := parsedToken.Claims.GetSubject()
 	if err != nil {
 		return models.RefreshTokenResponse{}, errors.New("invalid refresh token claims")
 	}
-
-	rToken, ok := dbstruct.RefreshToken[parsedToken.Raw]
-	if !ok {
-		return models.RefreshTokenResponse{}, AuthenticationError{message: "invalid refresh token"}
+	parsedUserId, err := strconv.Atoi(userId)
+	if err != nil {
+		return models.RefreshTokenResponse{}, errors.New("invalid refresh token claims")
 	}
 
-	if rToken.HasRevoked {
-		return models.RefreshTokenResponse{}, AuthenticationError{message: "refresh token revoked"}
+	accessToken, accessTokenId, err := createAccessToken(userId)
+	if err != nil {
+		return models.RefreshTokenResponse{}, err
 	}
-
-	accessTokenClaims := &jwt.RegisteredClaims{
-		IssuedAt:  jwt.NewNumericDate(time.Now()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(accessTokenExpiry)),
-		Issuer:    "chirpy-access",
-		Subject:   userId,
+	refreshToken, next, err := createRefreshToken(parsedUserId)
+	if err != nil {
+		return models.RefreshTokenResponse{}, err
 	}
+	next.AccessTokenId = accessTokenId
+
+	id := hashToken(parsedToken.Raw)
+	reused := false
+	err = db.Update(func(tx *Tx) error {
+		rToken, ok := tx.RefreshToken[id]
+		if !ok {
+			return AuthenticationError{message: "invalid refresh token"}
+		}
+
+		if rToken.ReplacedBy != "" {
+			reused = true
+			tx.revokeFamily(rToken.FamilyId)
+			return nil
+		}
+		if rToken.HasRevoked {
+			return AuthenticationError{message: "refresh token revoked"}
+		}
+
+		// Tokens handed out before rotation existed start their family
+		// on their first refresh.
+		if rToken.FamilyId == "" {
+			rToken.FamilyId = tx.startFamily(parsedUserId, client)
+		} else if family, ok := tx.TokenFamilies[rToken.FamilyId]; ok {
+			family.UserAgent = client.UserAgent
+			family.IP = client.IP
+			family.LastUsedAt = time.Now().UTC()
+			tx.TokenFamilies[family.Id] = family
+			tx.Touch("token_families", family.Id)
+		}
+		rToken.HasRevoked = true
+		rToken.ReplacedBy = next.Id
+		tx.RefreshToken[id] = rToken
+		tx.Touch("refresh_tokens", id)
 
-	accessToken, err := helpers.CreateToken(accessTokenClaims)
+		next.FamilyId = rToken.FamilyId
+		tx.RefreshToken[next.Id] = next
+		tx.Touch("refresh_tokens", next.Id)
+		return nil
+	})
 	if err != nil {
-		return models.RefreshTokenResponse{}, errors.New("error while signing the token")
+		return models.RefreshTokenResponse{}, err
+	}
+	if reused {
+		return models.RefreshTokenResponse{}, reusedTokenError
 	}
-	return models.RefreshTokenResponse{Token: accessToken}, nil
+
+	return models.RefreshTokenResponse{Token: accessToken, RefreshToken: refreshToken}, nil
 }
 
+// RevokeToken ends the session of the refresh token, revoking its whole
+// family.
 func (db *DB) RevokeToken(token string) error {
-	dbstruct, err := db.loadDB()
+	parsedToken, err := parseRefreshToken(token)
 	if err != nil {
 		return err
 	}
 
-	parsedToken, err := helpers.ValidateToken(token)
+	id := hashToken(parsedToken.Raw)
+	return db.Update(func(tx *Tx) error {
+		rToken, ok := tx.RefreshToken[id]
+		if !ok {
+			return AuthenticationError{message: "invalid refresh token"}
+		}
 
+		if rToken.HasRevoked {
+			return AuthenticationError{message: "token has been revoked"}
+		}
+
+		if rToken.FamilyId != "" {
+			tx.revokeFamily(rToken.FamilyId)
+			return nil
+		}
+		tx.denyAccessToken(rToken, time.Now().UTC())
+		rToken.HasRevoked = true
+		tx.RefreshToken[id] = rToken
+		tx.Touch("refresh_tokens", id)
+		return nil
+	})
+}
+
+// PurgeRefreshTokens deletes the refresh tokens that expired or were
+// revoked by now, the families left without tokens and the denylisted
+// access tokens that expired. It returns the number of deleted refresh
+// tokens.
+func (db *DB) PurgeRefreshTokens(now time.Time) (int, error) {
+	purged := 0
+
+	err := db.Update(func(tx *Tx) error {
+		live := make(map[string]bool)
+		for id, rToken := range tx.RefreshToken {
+			family, ok := tx.TokenFamilies[rToken.FamilyId]
+			familyRevoked := !ok || family.RevokedAt != nil
+			if !sweepable(rToken, familyRevoked, now) {
+				live[rToken.FamilyId] = true
+				continue
+			}
+			delete(tx.RefreshToken, id)
+			tx.Touch("refresh_tokens", id)
+			purged++
+		}
+
+		for id := range tx.TokenFamilies {
+			if !live[id] {
+				delete(tx.TokenFamilies, id)
+				tx.Touch("token_families", id)
+			}
+		}
+
+		for id, revoked := range tx.RevokedAccessTokens {
+			if !revoked.ExpiresAt.After(now) {
+				delete(tx.RevokedAccessTokens, id)
+				tx.Touch("revoked_access_tokens", id)
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return AuthenticationError{message: err.Error()}
-	}
-	if !parsedToken.Valid {
-		return AuthenticationError{message: "invalid refresh token"}
+		return 0, err
 	}
-	issuer, err := parsedToken.Claims.GetIssuer()
+	return purged, nil
+}
+
+// GetSessions returns the sessions of the user that can still be
+// refreshed, the most recently used first.
+func (db *DB) GetSessions(userId int) ([]models.Session, error) {
+	sessions := []models.Session{}
+	now := time.Now().UTC()
+
+	err := db.View(func(dbstruct *DBStructure) error {
+		for _, rToken := range dbstruct.RefreshToken {
+			if rToken.UserId != userId || rToken.HasRevoked || !rToken.ExpiresAt.After(now) {
+				continue
+			}
+			family, ok := dbstruct.TokenFamilies[rToken.FamilyId]
+			if !ok || family.RevokedAt != nil {
+				continue
+			}
+			sessions = append(sessions, toSession(family, rToken))
+		}
+		return nil
+	})
 	if err != nil {
-		return err
-	}
-	if issuer != "chirpy-refresh" {
-		return AuthenticationError{message: "invalid refresh token issuer"}
+		return nil, err
 	}
 
-	rToken, ok := dbstruct.RefreshToken[parsedToken.Raw]
-	if !ok {
-		return AuthenticationError{message: "invalid refresh token"}
-	}
+	sortSessions(sessions)
+	return sessions, nil
+}
 
-	if rToken.HasRevoked {
-		fmt.Println("Revoked state")
-		return AuthenticationError{message: "token has been revoked"}
-	}
+func (db *DB) RevokeSession(userId int, id string) error {
+	return db.Update(func(tx *Tx) error {
+		family, ok := tx.TokenFamilies[id]
+		if !ok || family.UserId != userId || family.RevokedAt != nil {
+			return NotFoundError{}
+		}
+		tx.revokeFamily(id)
+		return nil
+	})
+}
 
-	dbstruct.RefreshToken[parsedToken.Raw] = models.RefreshToken{
-		Id:         rToken.Id,
-		HasRevoked: true,
-	}
+func (db *DB) RevokeAllSessions(userId int) (int, error) {
+	revoked := 0
 
-	return db.writeDB(dbstruct)
+	err := db.Update(func(tx *Tx) error {
+		revoked = tx.revokeUserTokens(userId)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return revoked, nil
+}
 
+func (db *DB) IsAccessTokenRevoked(jti string) (bool, error) {
+	revoked := false
+	err := db.View(func(dbstruct *DBStructure) error {
+		_, revoked = dbstruct.RevokedAccessTokens[jti]
+		return nil
+	})
+	return revoked, err
 }
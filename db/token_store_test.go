@@ -0,0 +1,304 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ortin779/chirpy/helpers"
+	"github.com/ortin779/chirpy/models"
+)
+
+func TestRefreshTokenRotation(t *testing.T) {
+	tests := []struct {
+		name string
+		// rotations is the number of refreshes before the token with
+		// index present is presented, the login token has index 0.
+		rotations int
+		present   int
+		// revoke logs out with the newest token first.
+		revoke     bool
+		wantErr    error
+		wantReused bool
+	}{
+		{name: "login token rotates", rotations: 0, present: 0},
+		{name: "newest token rotates", rotations: 2, present: 2},
+		{name: "reusing the login token", rotations: 1, present: 0, wantErr: reusedTokenError, wantReused: true},
+		{name: "reusing a rotated token", rotations: 3, present: 2, wantErr: reusedTokenError, wantReused: true},
+		{name: "token of a revoked session", rotations: 1, present: 1, revoke: true, wantErr: AuthenticationError{message: "refresh token revoked"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eachStore(t, func(t *testing.T, store Store, _ func() Store) {
+				session := login(t, store, "jane@example.com")
+				for i := 0; i < tt.rotations; i++ {
+					session.refresh(t, store, len(session.refreshTokens)-1)
+				}
+				if tt.revoke {
+					err := store.RevokeToken(bearer(session.refreshTokens[len(session.refreshTokens)-1]))
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				_, err := store.RefreshToken(bearer(session.refreshTokens[tt.present]), models.Client{})
+				if tt.wantErr == nil && err != nil {
+					t.Fatalf("RefreshToken() error = %v", err)
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Fatalf("RefreshToken() error = %v, want %v", err, tt.wantErr)
+				}
+
+				sessions, err := store.GetSessions(session.userId)
+				if err != nil {
+					t.Fatal(err)
+				}
+				live := !tt.wantReused && !tt.revoke
+				if live != (len(sessions) == 1) {
+					t.Errorf("sessions = %+v, want the session live: %t", sessions, live)
+				}
+				if !tt.wantReused {
+					return
+				}
+
+				// A reuse ends the session: every token of the family
+				// stops working and its access tokens are denylisted.
+				for i, token := range session.refreshTokens {
+					_, err = store.RefreshToken(bearer(token), models.Client{})
+					if err == nil {
+						t.Errorf("refresh token %d still works after the reuse", i)
+					}
+				}
+				for i, jti := range session.accessTokenIds {
+					revoked, err := store.IsAccessTokenRevoked(jti)
+					if err != nil || !revoked {
+						t.Errorf("access token %d revoked = %t, %v, want it denylisted", i, revoked, err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestReuseOnlyRevokesItsFamily(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store, _ func() Store) {
+		phone := login(t, store, "jane@example.com")
+		laptop := phone.login(t, store)
+		phone.refresh(t, store, 0)
+
+		_, err := store.RefreshToken(bearer(phone.refreshTokens[0]), models.Client{})
+		if !errors.Is(err, reusedTokenError) {
+			t.Fatalf("RefreshToken() error = %v, want %v", err, reusedTokenError)
+		}
+		laptop.refresh(t, store, 0)
+		revoked, err := store.IsAccessTokenRevoked(laptop.accessTokenIds[0])
+		if err != nil || revoked {
+			t.Errorf("access token of the other session revoked = %t, %v", revoked, err)
+		}
+	})
+}
+
+func TestPurgeRefreshTokens(t *testing.T) {
+	invalidToken := AuthenticationError{message: "invalid refresh token"}
+	tests := []struct {
+		name string
+		// revoke logs out after one refresh.
+		revoke bool
+		// after is how long after now the tokens are purged.
+		after      time.Duration
+		wantPurged int
+		// wantErr is returned when the login token is presented after
+		// the purge.
+		wantErr    error
+		wantDenied bool
+	}{
+		{name: "rotated token of a live session is kept", wantErr: reusedTokenError},
+		{name: "revoked session", revoke: true, wantPurged: 2, wantErr: invalidToken, wantDenied: true},
+		{name: "expired session", after: refreshTokenExpiry + time.Minute, wantPurged: 2, wantErr: invalidToken},
+		{name: "expired revoked session", revoke: true, after: refreshTokenExpiry + time.Minute, wantPurged: 2, wantErr: invalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eachStore(t, func(t *testing.T, store Store, _ func() Store) {
+				session := login(t, store, "jane@example.com")
+				session.refresh(t, store, 0)
+				if tt.revoke {
+					err := store.RevokeToken(bearer(session.refreshTokens[1]))
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				purged, err := store.PurgeRefreshTokens(time.Now().UTC().Add(tt.after))
+				if err != nil || purged != tt.wantPurged {
+					t.Fatalf("PurgeRefreshTokens() = %d, %v, want %d", purged, err, tt.wantPurged)
+				}
+				denied, err := store.IsAccessTokenRevoked(session.accessTokenIds[0])
+				if err != nil || denied != tt.wantDenied {
+					t.Errorf("access token denylisted = %t, %v, want %t", denied, err, tt.wantDenied)
+				}
+				_, err = store.RefreshToken(bearer(session.refreshTokens[0]), models.Client{})
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("RefreshToken() error = %v, want %v", err, tt.wantErr)
+				}
+			})
+		})
+	}
+}
+
+func TestRunTokenSweeper(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store, _ func() Store) {
+		session := login(t, store, "jane@example.com")
+		err := store.RevokeToken(bearer(session.refreshTokens[0]))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			RunTokenSweeper(ctx, store, time.Millisecond)
+			close(done)
+		}()
+		defer func() {
+			cancel()
+			<-done
+		}()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			_, err = store.RefreshToken(bearer(session.refreshTokens[0]), models.Client{})
+			if errors.Is(err, AuthenticationError{message: "invalid refresh token"}) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("RefreshToken() error = %v, want the revoked token swept", err)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	})
+}
+
+func TestSessionsBelongToTheirUser(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store, _ func() Store) {
+		jane := login(t, store, "jane@example.com")
+		jane.login(t, store)
+		doe := login(t, store, "doe@example.com")
+
+		sessions := mustGetSessions(t, store, jane.userId, 2)
+		mustGetSessions(t, store, doe.userId, 1)
+		id := sessions[0].Id
+
+		err := store.RevokeSession(doe.userId, id)
+		if !errors.Is(err, NotFoundError{}) {
+			t.Errorf("RevokeSession() of another user's session error = %v, want NotFoundError", err)
+		}
+		mustGetSessions(t, store, jane.userId, 2)
+
+		err = store.RevokeSession(jane.userId, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, missing := range []string{id, "missing"} {
+			err = store.RevokeSession(jane.userId, missing)
+			if !errors.Is(err, NotFoundError{}) {
+				t.Errorf("RevokeSession(%q) error = %v, want NotFoundError", missing, err)
+			}
+		}
+		remaining := mustGetSessions(t, store, jane.userId, 1)
+		if remaining[0].Id == id {
+			t.Fatal("revoked session is still listed")
+		}
+
+		revoked, err := store.RevokeAllSessions(jane.userId)
+		if err != nil || revoked != 1 {
+			t.Errorf("RevokeAllSessions() = %d, %v, want 1", revoked, err)
+		}
+		mustGetSessions(t, store, jane.userId, 0)
+		mustGetSessions(t, store, doe.userId, 1)
+		_, err = store.RefreshToken(bearer(doe.refreshTokens[0]), models.Client{})
+		if err != nil {
+			t.Errorf("RefreshToken() of another user after RevokeAllSessions() error = %v", err)
+		}
+	})
+}
+
+// testSession holds the tokens handed out to a user, oldest first.
+type testSession struct {
+	userId         int
+	email          string
+	refreshTokens  []string
+	accessTokenIds []string
+}
+
+// login creates a user and logs them in. Tokens are signed with a keyring
+// of the test.
+func login(t *testing.T, store Store, email string) *testSession {
+	t.Helper()
+	keyring, err := helpers.NewKeyring(filepath.Join(t.TempDir(), "jwt_keys.json"), helpers.AlgorithmEdDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	helpers.UseKeyring(keyring)
+	t.Cleanup(func() { helpers.UseKeyring(nil) })
+	user := mustCreateUser(t, store, models.UserRequestBody{Email: email, Password: "secret"})
+	return (&testSession{userId: user.Id, email: email}).login(t, store)
+}
+
+// login starts another session of the same user.
+func (s *testSession) login(t *testing.T, store Store) *testSession {
+	t.Helper()
+	response, err := store.LoginUser(models.UserRequestBody{Email: s.email, Password: "secret"}, models.Client{UserAgent: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testSession{
+		userId:         s.userId,
+		email:          s.email,
+		refreshTokens:  []string{response.RefreshToken},
+		accessTokenIds: []string{accessTokenId(t, response.Token)},
+	}
+}
+
+// refresh presents the refresh token with index i and keeps the tokens it
+// is rotated into.
+func (s *testSession) refresh(t *testing.T, store Store, i int) {
+	t.Helper()
+	response, err := store.RefreshToken(bearer(s.refreshTokens[i]), models.Client{UserAgent: "test"})
+	if err != nil {
+		t.Fatalf("refreshing token %d: %v", i, err)
+	}
+	s.refreshTokens = append(s.refreshTokens, response.RefreshToken)
+	s.accessTokenIds = append(s.accessTokenIds, accessTokenId(t, response.Token))
+}
+
+func bearer(token string) string {
+	return fmt.Sprintf("Bearer %s", token)
+}
+
+func accessTokenId(t *testing.T, token string) string {
+	t.Helper()
+	claims := &jwt.RegisteredClaims{}
+	_, _, err := jwt.NewParser().ParseUnverified(token, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return claims.ID
+}
+
+// mustGetSessions returns the sessions of the user and checks there are n.
+func mustGetSessions(t *testing.T, store Store, userId int, n int) []models.Session {
+	t.Helper()
+	sessions, err := store.GetSessions(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != n {
+		t.Fatalf("GetSessions(%d) = %+v, want %d sessions", userId, sessions, n)
+	}
+	return sessions
+}
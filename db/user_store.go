@@ -1,98 +1,117 @@
 package db
 
 import (
-	"errors"
 	"fmt"
 	"strconv"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
-	"github.com/ortin779/chirpy/helpers"
 	"github.com/ortin779/chirpy/models"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// suspendedError is returned when a suspended user tries to log in.
+var suspendedError = AuthorizationError{message: "account suspended"}
+
 func (db *DB) CreateUser(userBody models.UserRequestBody) (models.UserResponse, error) {
-	dbstruct, err := db.loadDB()
+	err := checkHandle(userBody.Handle)
 	if err != nil {
 		return models.UserResponse{}, err
 	}
-
-	existingUsr := findUser(userBody.Email, dbstruct.Users)
-	if existingUsr != nil {
-		return models.UserResponse{}, fmt.Errorf("user already exist with given email")
-	}
-
-	nextIndex := 1
-
-	if len(dbstruct.Users) > 0 {
-		keys := getSortedKeys(dbstruct.Users)
-		nextIndex = keys[0] + 1
-	}
-
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(userBody.Password), bcrypt.DefaultCost)
 	if err != nil {
 		return models.UserResponse{}, err
 	}
-	newUser := models.User{
-		Id:       nextIndex,
-		Email:    userBody.Email,
-		Password: string(hashedPassword),
-	}
-	dbstruct.Users[nextIndex] = newUser
-	err = db.writeDB(dbstruct)
+
+	newUser := models.User{}
+	err = db.Update(func(tx *Tx) error {
+		existingUsr := findUser(userBody.Email, tx.Users)
+		if existingUsr != nil {
+			return fmt.Errorf("user already exist with given email")
+		}
+		if handleTaken(tx.DBStructure, userBody.Handle, 0) {
+			return handleTakenError
+		}
+
+		nextIndex := tx.nextId("users")
+
+		now := time.Now().UTC()
+		newUser = models.User{
+			Id:        nextIndex,
+			Email:     userBody.Email,
+			Handle:    userBody.Handle,
+			Password:  string(hashedPassword),
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		tx.Users[nextIndex] = newUser
+		tx.Touch("users", nextIndex)
+		return nil
+	})
 	if err != nil {
 		return models.UserResponse{}, err
 	}
-	return models.UserResponse{
-		Id:    newUser.Id,
-		Email: newUser.Email,
-	}, nil
+	return toUserResponse(newUser), nil
 }
 
 func (db *DB) UpdateUser(userBody models.UserRequestBody, userId string) (models.UserResponse, error) {
-	dbstruct, err := db.loadDB()
-	if err != nil {
-		return models.UserResponse{}, err
-	}
-
 	parsedId, err := strconv.Atoi(userId)
 	if err != nil {
 		return models.UserResponse{}, fmt.Errorf("invalid user id")
 	}
-
-	existingUsr, ok := dbstruct.Users[parsedId]
-	if !ok {
-		return models.UserResponse{}, NotFoundError{}
+	err = checkHandle(userBody.Handle)
+	if err != nil {
+		return models.UserResponse{}, err
 	}
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(userBody.Password), bcrypt.DefaultCost)
 	if err != nil {
 		return models.UserResponse{}, err
 	}
-	updatedUser := models.User{
-		Id:       existingUsr.Id,
-		Email:    userBody.Email,
-		Password: string(hashedPassword),
-	}
-	dbstruct.Users[parsedId] = updatedUser
-	err = db.writeDB(dbstruct)
+
+	updatedUser := models.User{}
+	err = db.Update(func(tx *Tx) error {
+		existingUsr, ok := tx.Users[parsedId]
+		if !ok {
+			return NotFoundError{}
+		}
+		if handleTaken(tx.DBStructure, userBody.Handle, parsedId) {
+			return handleTakenError
+		}
+
+		// The stored hash is compared in the same transaction, so a
+		// concurrent change of the password cannot be missed.
+		passwordChanged := bcrypt.CompareHashAndPassword([]byte(existingUsr.Password), []byte(userBody.Password)) != nil
+
+		updatedUser = existingUsr
+		updatedUser.Email = userBody.Email
+		updatedUser.Handle = userBody.Handle
+		updatedUser.Password = string(hashedPassword)
+		updatedUser.UpdatedAt = time.Now().UTC()
+		tx.Users[parsedId] = updatedUser
+		tx.Touch("users", parsedId)
+
+		// Whoever got hold of the old password may be logged in.
+		if passwordChanged {
+			tx.revokeUserTokens(parsedId)
+		}
+		return nil
+	})
 	if err != nil {
 		return models.UserResponse{}, err
 	}
-	return models.UserResponse{
-		Id:    updatedUser.Id,
-		Email: updatedUser.Email,
-	}, nil
+	return toUserResponse(updatedUser), nil
 }
 
-func (db *DB) LoginUser(userBody models.UserRequestBody) (models.UserLoginResponse, error) {
-	dbstruct, err := db.loadDB()
+func (db *DB) LoginUser(userBody models.UserRequestBody, client models.Client) (models.UserLoginResponse, error) {
+	var user *models.User
+	err := db.View(func(dbstruct *DBStructure) error {
+		user = findUser(userBody.Email, dbstruct.Users)
+		return nil
+	})
 	if err != nil {
 		return models.UserLoginResponse{}, err
 	}
 
-	user := findUser(userBody.Email, dbstruct.Users)
 	if user == nil {
 		return models.UserLoginResponse{}, AuthenticationError{message: fmt.Sprintf("no user with given email %s", userBody.Email)}
 	}
@@ -100,34 +119,25 @@ func (db *DB) LoginUser(userBody models.UserRequestBody) (models.UserLoginRespon
 	if err != nil {
 		return models.UserLoginResponse{}, AuthenticationError{message: fmt.Sprintf("invalid password for user with email %s", userBody.Email)}
 	}
-
-	accessTokenClaims := &jwt.RegisteredClaims{
-		IssuedAt:  jwt.NewNumericDate(time.Now()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(accessTokenExpiry)),
-		Issuer:    "chirpy-access",
-		Subject:   strconv.Itoa(user.Id),
-	}
-
-	refreshTokenClaims := &jwt.RegisteredClaims{
-		IssuedAt:  jwt.NewNumericDate(time.Now()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(refreshTokenExpiry)),
-		Issuer:    "chirpy-refresh",
-		Subject:   strconv.Itoa(user.Id),
+	if user.SuspendedAt != nil {
+		return models.UserLoginResponse{}, suspendedError
 	}
 
-	accessToken, err := helpers.CreateToken(accessTokenClaims)
+	accessToken, accessTokenId, err := createAccessToken(strconv.Itoa(user.Id))
 	if err != nil {
-		return models.UserLoginResponse{}, errors.New("error while signing the token")
+		return models.UserLoginResponse{}, err
 	}
-	refreshToken, err := helpers.CreateToken(refreshTokenClaims)
+	refreshToken, rToken, err := createRefreshToken(user.Id)
 	if err != nil {
-		return models.UserLoginResponse{}, errors.New("error while signing the token")
-	}
-	dbstruct.RefreshToken[refreshToken] = models.RefreshToken{
-		Id:         refreshToken,
-		HasRevoked: false,
+		return models.UserLoginResponse{}, err
 	}
-	err = db.writeDB(dbstruct)
+	rToken.AccessTokenId = accessTokenId
+	err = db.Update(func(tx *Tx) error {
+		rToken.FamilyId = tx.startFamily(user.Id, client)
+		tx.RefreshToken[rToken.Id] = rToken
+		tx.Touch("refresh_tokens", rToken.Id)
+		return nil
+	})
 	if err != nil {
 		return models.UserLoginResponse{}, err
 	}
@@ -141,28 +151,99 @@ func (db *DB) LoginUser(userBody models.UserRequestBody) (models.UserLoginRespon
 }
 
 func (db *DB) MarkUserAsRedChirp(userId int) error {
-	dbstruct, err := db.loadDB()
-	if err != nil {
-		return err
-	}
+	return db.Update(func(tx *Tx) error {
+		existingUsr, ok := tx.Users[userId]
+		if !ok {
+			return NotFoundError{}
+		}
+
+		updatedUser := existingUsr
+		updatedUser.IsChirpyRed = true
+		updatedUser.UpdatedAt = time.Now().UTC()
+
+		tx.Users[userId] = updatedUser
+		tx.Touch("users", userId)
+		return nil
+	})
+}
 
-	existingUsr, ok := dbstruct.Users[userId]
-	if !ok {
-		return NotFoundError{}
+// SuspendUser suspends the user, revoking all their sessions and access
+// tokens. Suspending a suspended user keeps the original suspension time.
+func (db *DB) SuspendUser(userId int) (models.UserResponse, error) {
+	suspendedUser := models.User{}
+	err := db.Update(func(tx *Tx) error {
+		existingUsr, ok := tx.Users[userId]
+		if !ok {
+			return NotFoundError{}
+		}
+
+		suspendedUser = existingUsr
+		if suspendedUser.SuspendedAt == nil {
+			now := time.Now().UTC()
+			suspendedUser.SuspendedAt = &now
+			suspendedUser.UpdatedAt = now
+			tx.Users[userId] = suspendedUser
+			tx.Touch("users", userId)
+		}
+		tx.revokeUserTokens(userId)
+		return nil
+	})
+	if err != nil {
+		return models.UserResponse{}, err
 	}
+	return toUserResponse(suspendedUser), nil
+}
 
-	updatedUser := models.User{
-		Id:          existingUsr.Id,
-		Email:       existingUsr.Email,
-		Password:    existingUsr.Password,
-		IsChirpyRed: true,
+func (db *DB) UnsuspendUser(userId int) (models.UserResponse, error) {
+	unsuspendedUser := models.User{}
+	err := db.Update(func(tx *Tx) error {
+		existingUsr, ok := tx.Users[userId]
+		if !ok {
+			return NotFoundError{}
+		}
+
+		unsuspendedUser = existingUsr
+		if unsuspendedUser.SuspendedAt != nil {
+			unsuspendedUser.SuspendedAt = nil
+			unsuspendedUser.UpdatedAt = time.Now().UTC()
+			tx.Users[userId] = unsuspendedUser
+			tx.Touch("users", userId)
+		}
+		return nil
+	})
+	if err != nil {
+		return models.UserResponse{}, err
 	}
+	return toUserResponse(unsuspendedUser), nil
+}
 
-	dbstruct.Users[userId] = updatedUser
+func (db *DB) IsUserSuspended(userId int) (bool, error) {
+	suspended := false
+	err := db.View(func(dbstruct *DBStructure) error {
+		user, ok := dbstruct.Users[userId]
+		if !ok {
+			return NotFoundError{}
+		}
+		suspended = user.SuspendedAt != nil
+		return nil
+	})
+	return suspended, err
+}
 
-	err = db.writeDB(dbstruct)
-	if err != nil {
-		return err
+func toUserResponse(user models.User) models.UserResponse {
+	return models.UserResponse{
+		Id:          user.Id,
+		Email:       user.Email,
+		Handle:      user.Handle,
+		IsChirpyRed: user.IsChirpyRed,
+		CreatedAt:   user.CreatedAt,
+		UpdatedAt:   user.UpdatedAt,
+		SuspendedAt: user.SuspendedAt,
 	}
-	return nil
+}
+
+// handleTaken reports whether a user other than userId has the handle.
+func handleTaken(dbstruct *DBStructure, handle string, userId int) bool {
+	mentioned, _ := dbstruct.mentionedUser(handle)
+	return handle != "" && mentioned != 0 && mentioned != userId
 }
@@ -0,0 +1,99 @@
+package db
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/ortin779/chirpy/models"
+)
+
+func TestMentionsResolveHandles(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store, _ func() Store) {
+		jane := mustCreateUser(t, store, models.UserRequestBody{Email: "jane@example.com", Password: "secret"})
+		doe := mustCreateUser(t, store, models.UserRequestBody{Email: "doe@example.com", Password: "secret", Handle: "Jane_Doe"})
+
+		chirp, err := store.CreateChirp(models.Chirp{Body: "hi @jane and @jane_doe", AuthorId: jane.Id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(chirp.Entities) != 2 {
+			t.Fatalf("entities = %+v, want two mentions", chirp.Entities)
+		}
+		if chirp.Entities[0].UserId != 0 {
+			t.Errorf("@jane resolved to user %d through an email address", chirp.Entities[0].UserId)
+		}
+		if chirp.Entities[1].UserId != doe.Id {
+			t.Errorf("@jane_doe resolved to user %d, want %d", chirp.Entities[1].UserId, doe.Id)
+		}
+	})
+}
+
+func TestHandlesAreValidAndUnique(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store, _ func() Store) {
+		jane := mustCreateUser(t, store, models.UserRequestBody{Email: "jane@example.com", Password: "secret", Handle: "jane"})
+
+		tests := []struct {
+			name   string
+			handle string
+		}{
+			{"taken regardless of case", "JANE"},
+			{"invalid character", "jane!"},
+			{"trailing punctuation", "jane."},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := store.CreateUser(models.UserRequestBody{Email: "other@example.com", Password: "secret", Handle: tt.handle})
+				if !errors.As(err, &ValidationError{}) {
+					t.Errorf("CreateUser() error = %v, want a ValidationError", err)
+				}
+			})
+		}
+
+		updated, err := store.UpdateUser(models.UserRequestBody{Email: "jane@example.com", Password: "secret", Handle: "Jane"}, strconv.Itoa(jane.Id))
+		if err != nil || updated.Handle != "Jane" {
+			t.Errorf("UpdateUser() keeping the own handle = %+v, %v", updated, err)
+		}
+		other := mustCreateUser(t, store, models.UserRequestBody{Email: "other@example.com", Password: "secret"})
+		if other.Id == jane.Id || other.Handle != "" {
+			t.Errorf("user without a handle = %+v", other)
+		}
+	})
+}
+
+func TestPasswordChangeRevokesSessions(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		sessions int
+	}{
+		{name: "same password", password: "secret", sessions: 1},
+		{name: "new password", password: "new secret", sessions: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eachStore(t, func(t *testing.T, store Store, _ func() Store) {
+				session := login(t, store, "jane@example.com")
+
+				_, err := store.UpdateUser(models.UserRequestBody{Email: session.email, Password: tt.password}, strconv.Itoa(session.userId))
+				if err != nil {
+					t.Fatal(err)
+				}
+				mustGetSessions(t, store, session.userId, tt.sessions)
+				revoked, err := store.IsAccessTokenRevoked(session.accessTokenIds[0])
+				if err != nil || revoked != (tt.sessions == 0) {
+					t.Errorf("access token revoked = %t, %v, want %t", revoked, err, tt.sessions == 0)
+				}
+			})
+		})
+	}
+}
+
+func mustCreateUser(t *testing.T, store Store, user models.UserRequestBody) models.UserResponse {
+	t.Helper()
+	created, err := store.CreateUser(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return created
+}
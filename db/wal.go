@@ -0,0 +1,240 @@
+package db
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// compactThreshold is the number of logged transactions after which the
+	// log is compacted without waiting for the next tick.
+	compactThreshold = 1_000
+	compactInterval  = time.Minute
+)
+
+// Tx is the view of the database handed to Update. Collections are
+// accessed through the embedded DBStructure as usual.
+type Tx struct {
+	*DBStructure
+	touched []walKey
+}
+
+type walKey struct {
+	table string
+	key   string
+}
+
+// Touch records that the entry under key in the collection with the given
+// json name was added, changed or deleted.
+func (tx *Tx) Touch(table string, key any) {
+	tx.touched = append(tx.touched, walKey{table: table, key: fmt.Sprint(key)})
+}
+
+// walRecord is the current value of a single touched entry, a nil Value
+// means the entry was deleted. Each line of the log holds the records of
+// one transaction.
+type walRecord struct {
+	Table string          `json:"table"`
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+func (db *DB) walPath() string {
+	return db.path + ".wal"
+}
+
+func (db *DB) openWAL() error {
+	var err error
+	db.wal, err = os.OpenFile(db.walPath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	return err
+}
+
+// replayWAL applies the log at path onto doc and returns the number of
+// transactions it contained.
+func replayWAL(path string, doc *document) (int, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	replayed := 0
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// A trailing line without a newline is a write that was cut
+			// short by a crash, the transaction never completed.
+			return replayed, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		records := []walRecord{}
+		err = json.Unmarshal(line, &records)
+		if err != nil {
+			return 0, fmt.Errorf("corrupt write-ahead log %s: %w", path, err)
+		}
+		for _, record := range records {
+			doc.apply(record)
+		}
+		replayed++
+	}
+}
+
+// appendWAL writes the touched entries of tx as a single line and syncs the
+// log. It is called with the write lock held.
+func (db *DB) appendWAL(tx *Tx) error {
+	records := make([]walRecord, 0, len(tx.touched))
+	for _, touched := range tx.touched {
+		record, err := currentRecord(tx.DBStructure, touched)
+		if err != nil {
+			return err
+		}
+		records = append(records, record)
+	}
+
+	line, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	_, err = db.wal.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+	err = db.wal.Sync()
+	if err != nil {
+		return err
+	}
+
+	db.walEntries++
+	if db.walEntries >= compactThreshold {
+		select {
+		case db.compact <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (db *DB) compactLoop() {
+	defer db.closed.Done()
+
+	ticker := time.NewTicker(compactInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-db.done:
+			return
+		case <-ticker.C:
+		case <-db.compact:
+		}
+
+		err := db.Compact()
+		if err != nil {
+			log.Printf("db: compacting %s: %v", db.path, err)
+		}
+	}
+}
+
+// Compact writes the in-memory state to the snapshot file and truncates the
+// write-ahead log. Readers are not blocked while the snapshot is written.
+func (db *DB) Compact() error {
+	db.compactMx.Lock()
+	defer db.compactMx.Unlock()
+
+	db.mx.RLock()
+	defer db.mx.RUnlock()
+
+	if db.walEntries == 0 {
+		return nil
+	}
+
+	err := db.writeDB(db.data)
+	if err != nil {
+		return err
+	}
+
+	// The log is only appended to under the write lock, so nothing can be
+	// lost between writing the snapshot and truncating it.
+	err = db.wal.Truncate(0)
+	if err != nil {
+		return err
+	}
+	err = db.wal.Sync()
+	if err != nil {
+		return err
+	}
+	db.walEntries = 0
+	return nil
+}
+
+func currentRecord(dbstruct *DBStructure, touched walKey) (walRecord, error) {
+	table, err := tableField(dbstruct, touched.table)
+	if err != nil {
+		return walRecord{}, err
+	}
+	key, err := parseMapKey(table, touched.key)
+	if err != nil {
+		return walRecord{}, err
+	}
+
+	record := walRecord{Table: touched.table, Key: touched.key}
+	value := table.MapIndex(key)
+	if !value.IsValid() {
+		return record, nil
+	}
+	record.Value, err = json.Marshal(value.Interface())
+	if err != nil {
+		return walRecord{}, err
+	}
+	return record, nil
+}
+
+// tableField returns the map field of dbstruct whose json name is table.
+func tableField(dbstruct *DBStructure, table string) (reflect.Value, error) {
+	structValue := reflect.ValueOf(dbstruct).Elem()
+	structType := structValue.Type()
+
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name != table || field.Type.Kind() != reflect.Map {
+			continue
+		}
+
+		return structValue.Field(i), nil
+	}
+	return reflect.Value{}, fmt.Errorf("unknown table %q", table)
+}
+
+func parseMapKey(table reflect.Value, key string) (reflect.Value, error) {
+	keyType := table.Type().Key()
+
+	switch keyType.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(key).Convert(keyType), nil
+	case reflect.Int:
+		parsed, err := strconv.Atoi(key)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(parsed).Convert(keyType), nil
+	default:
+		return reflect.Value{}, fmt.Errorf("unsupported key type %s", keyType)
+	}
+}
@@ -0,0 +1,106 @@
+// Package entities finds the hashtags and mentions in chirp bodies.
+package entities
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ortin779/chirpy/models"
+)
+
+const (
+	TypeHashtag = "hashtag"
+	TypeMention = "mention"
+)
+
+// Parse returns the hashtags and mentions of body in order. A hashtag is a
+// # followed by letters, digits and underscores, including at least one
+// letter. A mention is an @ followed by the characters allowed in the local
+// part of an email address. Neither is recognised directly after a word
+// character, so email addresses are not mistaken for mentions.
+func Parse(body string) []models.Entity {
+	found := []models.Entity{}
+
+	for i := 0; i < len(body); {
+		sigil := body[i]
+		if (sigil != '#' && sigil != '@') || afterWord(body, i) {
+			i++
+			continue
+		}
+
+		end := i + 1
+		for end < len(body) {
+			r, size := utf8.DecodeRuneInString(body[end:])
+			if !isEntityRune(sigil, r) {
+				break
+			}
+			end += size
+		}
+
+		text := body[i+1 : end]
+		entityType := TypeHashtag
+		if sigil == '@' {
+			// Trailing punctuation ends the sentence, not the handle.
+			text = strings.TrimRight(text, ".-+")
+			end = i + 1 + len(text)
+			entityType = TypeMention
+		}
+
+		if text == "" || (sigil == '#' && !strings.ContainsFunc(text, unicode.IsLetter)) {
+			i++
+			continue
+		}
+		found = append(found, models.Entity{Type: entityType, Text: text, Start: i, End: end})
+		i = end
+	}
+	return found
+}
+
+// ValidHandle reports whether handle can be mentioned, that is whether
+// "@" followed by handle is parsed as a single mention of handle.
+func ValidHandle(handle string) bool {
+	found := Parse("@" + handle)
+	return len(found) == 1 && found[0].Text == handle
+}
+
+// NormalizeTag returns the form hashtags are indexed under: lower case and
+// without the leading #.
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(tag, "#"))
+}
+
+// Tags returns the distinct normalized hashtags of entities.
+func Tags(found []models.Entity) []string {
+	tags := []string{}
+	for _, entity := range found {
+		if entity.Type != TypeHashtag {
+			continue
+		}
+		tag := NormalizeTag(entity.Text)
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func afterWord(body string, i int) bool {
+	if i == 0 {
+		return false
+	}
+	r, _ := utf8.DecodeLastRuneInString(body[:i])
+	return isWordRune(r) || r == '.' || r == '@' || r == '#'
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+func isEntityRune(sigil byte, r rune) bool {
+	if isWordRune(r) {
+		return true
+	}
+	return sigil == '@' && (r == '.' || r == '-' || r == '+')
+}
@@ -0,0 +1,153 @@
+package events
+
+import (
+	"log"
+	"time"
+
+	"github.com/ortin779/chirpy/db"
+	"github.com/ortin779/chirpy/models"
+)
+
+// Store is a db.Store that publishes created, deleted and restored chirps,
+// follows and the unread notification counts they change to the hub. Every
+// other call goes straight to the wrapped store.
+type Store struct {
+	db.Store
+	hub *Hub
+}
+
+// New wraps store to publish to hub.
+func New(store db.Store, hub *Hub) *Store {
+	return &Store{
+		Store: store,
+		hub:   hub,
+	}
+}
+
+func (s *Store) CreateChirp(chirp models.Chirp) (models.Chirp, error) {
+	chirp, err := s.Store.CreateChirp(chirp)
+	if err != nil {
+		return models.Chirp{}, err
+	}
+	s.hub.Publish(Event{Type: TypeChirpCreated, Chirp: chirp})
+	s.publishUnread(s.notified(chirp)...)
+	return chirp, nil
+}
+
+func (s *Store) DeleteChirp(id int, authorId int) (models.Chirp, error) {
+	chirp, err := s.Store.DeleteChirp(id, authorId)
+	if err != nil {
+		return models.Chirp{}, err
+	}
+	// The content of a deleted chirp is not passed on.
+	s.hub.Publish(Event{Type: TypeChirpDeleted, Chirp: models.Chirp{Id: chirp.Id, AuthorId: chirp.AuthorId}})
+	// The likes of the chirp notified its author.
+	s.publishUnread(append(s.notified(chirp), chirp.AuthorId)...)
+	return chirp, nil
+}
+
+func (s *Store) RestoreChirp(id int, authorId int, window time.Duration) (models.Chirp, error) {
+	chirp, err := s.Store.RestoreChirp(id, authorId, window)
+	if err != nil {
+		return models.Chirp{}, err
+	}
+	s.hub.Publish(Event{Type: TypeChirpRestored, Chirp: chirp})
+	s.publishUnread(append(s.notified(chirp), chirp.AuthorId)...)
+	return chirp, nil
+}
+
+func (s *Store) LikeChirp(chirpId int, userId int) (models.Chirp, error) {
+	chirp, err := s.Store.LikeChirp(chirpId, userId)
+	if err != nil {
+		return models.Chirp{}, err
+	}
+	if chirp.AuthorId != userId {
+		s.publishUnread(chirp.AuthorId)
+	}
+	return chirp, nil
+}
+
+func (s *Store) UnlikeChirp(chirpId int, userId int) (models.Chirp, error) {
+	chirp, err := s.Store.UnlikeChirp(chirpId, userId)
+	if err != nil {
+		return models.Chirp{}, err
+	}
+	if chirp.AuthorId != userId {
+		s.publishUnread(chirp.AuthorId)
+	}
+	return chirp, nil
+}
+
+func (s *Store) FollowUser(followerId int, followeeId int) (models.Follow, error) {
+	follow, err := s.Store.FollowUser(followerId, followeeId)
+	if err != nil {
+		return models.Follow{}, err
+	}
+	s.hub.Publish(Event{Type: TypeFollowing, UserId: followerId})
+	s.publishUnread(followeeId)
+	return follow, nil
+}
+
+func (s *Store) UnfollowUser(followerId int, followeeId int) error {
+	err := s.Store.UnfollowUser(followerId, followeeId)
+	if err != nil {
+		return err
+	}
+	s.hub.Publish(Event{Type: TypeFollowing, UserId: followerId})
+	s.publishUnread(followeeId)
+	return nil
+}
+
+func (s *Store) MarkNotificationsRead(userId int, ids []int) (int, error) {
+	marked, err := s.Store.MarkNotificationsRead(userId, ids)
+	if err != nil {
+		return 0, err
+	}
+	s.publishUnread(userId)
+	return marked, nil
+}
+
+// notified returns the users the chirp sends notifications to: the authors
+// of the chirps it replies to, quotes or rechirps and the users it
+// mentions.
+func (s *Store) notified(chirp models.Chirp) []int {
+	userIds := []int{}
+	for _, id := range []int{chirp.ReplyTo, max(chirp.QuoteOf, chirp.RechirpOf)} {
+		if id == 0 {
+			continue
+		}
+		parents, err := s.Store.GetChirpsByIds([]int{id})
+		if err != nil {
+			log.Printf("events: looking up chirp %d: %v", id, err)
+		}
+		if parent, ok := parents[id]; ok {
+			userIds = append(userIds, parent.AuthorId)
+		}
+	}
+	for _, entity := range chirp.Entities {
+		if entity.UserId != 0 {
+			userIds = append(userIds, entity.UserId)
+		}
+	}
+	return userIds
+}
+
+// publishUnread publishes the unread notification count of every user
+// once. The change that made them has already been stored, so failing to
+// publish is only logged.
+func (s *Store) publishUnread(userIds ...int) {
+	published := map[int]bool{}
+	for _, userId := range userIds {
+		if published[userId] {
+			continue
+		}
+		published[userId] = true
+
+		count, err := s.Store.CountUnreadNotifications(userId)
+		if err != nil {
+			log.Printf("events: counting unread notifications of user %d: %v", userId, err)
+			continue
+		}
+		s.hub.Publish(Event{Type: TypeNotifications, UserId: userId, UnreadCount: count})
+	}
+}
@@ -0,0 +1,77 @@
+package events
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ortin779/chirpy/db"
+	"github.com/ortin779/chirpy/models"
+)
+
+func TestChirpEvents(t *testing.T) {
+	database, err := db.NewDB(filepath.Join(t.TempDir(), "chirpy.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	hub := NewHub()
+	store := New(database, hub)
+	sub, _ := hub.Subscribe("")
+	defer sub.Close()
+
+	chirp, err := store.CreateChirp(models.Chirp{Body: "secret plans #go", AuthorId: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.DeleteChirp(chirp.Id, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.RestoreChirp(chirp.Id, 1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		eventType string
+		body      string
+	}{
+		{TypeChirpCreated, "secret plans #go"},
+		// The content of a deleted chirp is not passed on.
+		{TypeChirpDeleted, ""},
+		{TypeChirpRestored, "secret plans #go"},
+	}
+	for _, tt := range tests {
+		event := nextChirpEvent(t, sub)
+		if event.Type != tt.eventType || event.Chirp.Id != chirp.Id || event.Chirp.Body != tt.body {
+			t.Fatalf("event = %s of chirp %d with body %q, want %s of chirp %d with body %q", event.Type, event.Chirp.Id, event.Chirp.Body, tt.eventType, chirp.Id, tt.body)
+		}
+		if event.Type != TypeChirpDeleted {
+			continue
+		}
+		data, err := json.Marshal(event.ChirpData())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := `{"id":1,"author_id":1}`; string(data) != want {
+			t.Errorf("deleted chirp data = %s, want %s", data, want)
+		}
+	}
+}
+
+// nextChirpEvent returns the next chirp event, skipping the others.
+func nextChirpEvent(t *testing.T, sub *Subscription) Event {
+	t.Helper()
+	for {
+		select {
+		case event := <-sub.C:
+			if event.IsChirp() {
+				return event
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no chirp event published")
+		}
+	}
+}
@@ -0,0 +1,185 @@
+// Package events publishes changes to the clients streaming them: created,
+// deleted and restored chirps, unread notification counts and follows. The
+// Hub fans events out to subscribers and keeps the most recent ones so a
+// client that reconnects can catch up on what it missed.
+package events
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ortin779/chirpy/models"
+)
+
+const (
+	TypeChirpCreated = "chirp_created"
+	TypeChirpDeleted = "chirp_deleted"
+	// TypeChirpRestored is published when a deleted chirp is restored.
+	TypeChirpRestored = "chirp_restored"
+	// TypeNotifications carries the new unread notification count of a
+	// user.
+	TypeNotifications = "notifications"
+	// TypeFollowing is published when a user follows or unfollows someone.
+	TypeFollowing = "following"
+)
+
+// ReplaySize is the number of recent events kept for clients resuming a
+// stream.
+const ReplaySize = 1000
+
+// subscriberBuffer is the number of events a subscriber can fall behind
+// before it is dropped.
+const subscriberBuffer = 64
+
+// Event is a single change. Ids are the epoch of the hub, which differs on
+// every start, and a sequence number increasing by one with every event
+// published since, so an id from before a restart is never mistaken for a
+// later event.
+type Event struct {
+	Id   string
+	Type string
+	// Chirp is set for chirp events. The chirp of a chirp_deleted event
+	// only has its Id and AuthorId.
+	Chirp models.Chirp
+	// UserId is the user a notifications or following event is about.
+	UserId      int
+	UnreadCount int
+
+	seq uint64
+}
+
+// IsChirp reports whether the event is about a chirp.
+func (e Event) IsChirp() bool {
+	return e.Type == TypeChirpCreated || e.Type == TypeChirpDeleted || e.Type == TypeChirpRestored
+}
+
+// DeletedChirp is what clients get of a deleted chirp.
+type DeletedChirp struct {
+	Id       int `json:"id"`
+	AuthorId int `json:"author_id"`
+}
+
+// ChirpData returns the chirp of a chirp event as it is sent to clients.
+func (e Event) ChirpData() any {
+	if e.Type == TypeChirpDeleted {
+		return DeletedChirp{Id: e.Chirp.Id, AuthorId: e.Chirp.AuthorId}
+	}
+	return e.Chirp
+}
+
+// Hub fans published events out to its subscribers. Publishing never
+// blocks, a subscriber that does not keep up is closed instead.
+type Hub struct {
+	mx     *sync.Mutex
+	epoch  string
+	lastId uint64
+	// recent holds the last ReplaySize events, oldest first.
+	recent      []Event
+	subscribers map[*Subscription]bool
+	closed      bool
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		mx:          &sync.Mutex{},
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		subscribers: make(map[*Subscription]bool),
+	}
+}
+
+// Subscription receives the events published after it was created. C is
+// closed when the subscription is closed, the subscriber fell behind or the
+// hub shut down.
+type Subscription struct {
+	C <-chan Event
+
+	hub *Hub
+	ch  chan Event
+}
+
+// Publish assigns the event the next id and sends it to every subscriber.
+func (h *Hub) Publish(event Event) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	if h.closed {
+		return
+	}
+
+	h.lastId++
+	event.seq = h.lastId
+	event.Id = fmt.Sprintf("%s-%d", h.epoch, h.lastId)
+	h.recent = append(h.recent, event)
+	if len(h.recent) > ReplaySize {
+		h.recent = h.recent[len(h.recent)-ReplaySize:]
+	}
+
+	for sub := range h.subscribers {
+		select {
+		case sub.ch <- event:
+		default:
+			h.drop(sub)
+		}
+	}
+}
+
+// Subscribe starts a subscription. The events published after lastId that
+// are still kept are returned to be sent first. An empty lastId, or one
+// the hub did not hand out since it started, starts with new events only.
+func (h *Hub) Subscribe(lastId string) (*Subscription, []Event) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	ch := make(chan Event, subscriberBuffer)
+	sub := &Subscription{C: ch, hub: h, ch: ch}
+	if h.closed {
+		close(ch)
+		return sub, nil
+	}
+	h.subscribers[sub] = true
+
+	missed := []Event{}
+	epoch, seq, _ := strings.Cut(lastId, "-")
+	after, err := strconv.ParseUint(seq, 10, 64)
+	if epoch != h.epoch || err != nil || after > h.lastId {
+		return sub, missed
+	}
+	for _, event := range h.recent {
+		if event.seq > after {
+			missed = append(missed, event)
+		}
+	}
+	return sub, missed
+}
+
+// Close ends the subscription. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.hub.mx.Lock()
+	defer s.hub.mx.Unlock()
+
+	if s.hub.subscribers[s] {
+		s.hub.drop(s)
+	}
+}
+
+// Close ends every subscription, so streaming requests return and the
+// server can shut down.
+func (h *Hub) Close() {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	h.closed = true
+	for sub := range h.subscribers {
+		h.drop(sub)
+	}
+}
+
+// drop removes the subscription and closes its channel. It is called with
+// the lock held.
+func (h *Hub) drop(sub *Subscription) {
+	delete(h.subscribers, sub)
+	close(sub.ch)
+}
@@ -0,0 +1,49 @@
+package events
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSubscribeReplaysOnlyIdsOfThisHub(t *testing.T) {
+	previous := NewHub()
+	previous.Publish(Event{Type: TypeChirpCreated})
+	stale, _ := previous.Subscribe("")
+	defer stale.Close()
+	previous.Publish(Event{Type: TypeChirpCreated})
+	staleId := (<-stale.C).Id
+
+	hub := NewHub()
+	hub.epoch = previous.epoch + "x"
+	for i := 0; i < 3; i++ {
+		hub.Publish(Event{Type: TypeChirpCreated})
+	}
+	sub, _ := hub.Subscribe("")
+	defer sub.Close()
+	hub.Publish(Event{Type: TypeChirpCreated})
+	last := <-sub.C
+	if !strings.HasPrefix(last.Id, hub.epoch+"-") {
+		t.Fatalf("event id = %q, want it prefixed with the epoch %q", last.Id, hub.epoch)
+	}
+
+	tests := []struct {
+		name   string
+		lastId string
+		missed int
+	}{
+		{"new subscriber", "", 0},
+		{"id of this hub", hub.epoch + "-2", 2},
+		{"id of a previous start", staleId, 0},
+		{"id not handed out yet", hub.epoch + "-9", 0},
+		{"malformed id", "17", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, missed := hub.Subscribe(tt.lastId)
+			defer sub.Close()
+			if len(missed) != tt.missed {
+				t.Errorf("Subscribe(%q) replayed %d events, want %d", tt.lastId, len(missed), tt.missed)
+			}
+		})
+	}
+}
@@ -2,23 +2,63 @@ package helpers
 
 import (
 	"errors"
-	"os"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func CreateToken(claims *jwt.RegisteredClaims) (string, error) {
-	jwtSecret := os.Getenv("JWT_SECRET")
+var keyring atomic.Pointer[Keyring]
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+var legacy atomic.Pointer[legacySecret]
 
-	return token.SignedString([]byte(jwtSecret))
+// legacySecret verifies the tokens signed with HS256 and JWT_SECRET before
+// the keyring existed. Tokens are only accepted if they were issued before
+// issuedBefore and are younger than the lifetime of their issuer.
+type legacySecret struct {
+	secret       []byte
+	issuedBefore time.Time
+	lifetimes    map[string]time.Duration
 }
 
-func ValidateToken(token string) (*jwt.Token, error) {
-	jwtSecret := os.Getenv("JWT_SECRET")
+// UseKeyring makes CreateToken sign with the keyring and ValidateToken
+// verify against it.
+func UseKeyring(k *Keyring) {
+	keyring.Store(k)
+}
+
+// UseLegacySecret makes ValidateToken accept the tokens without a kid
+// header that were signed with HS256 and secret before issuedBefore, when
+// the keyring took over, for as long as lifetimes gives their issuer. Once
+// the longest lifetime has passed no token is accepted, and an empty
+// secret accepts none to begin with.
+func UseLegacySecret(secret string, issuedBefore time.Time, lifetimes map[string]time.Duration) {
+	if secret == "" {
+		legacy.Store(nil)
+		return
+	}
+	legacy.Store(&legacySecret{
+		secret:       []byte(secret),
+		issuedBefore: issuedBefore,
+		lifetimes:    lifetimes,
+	})
+}
 
+// CreateToken signs the claims with the keyring.
+func CreateToken(claims *jwt.RegisteredClaims) (string, error) {
+	k := keyring.Load()
+	if k == nil {
+		return "", errors.New("no signing key")
+	}
+	return k.Sign(claims)
+}
+
+// ValidateToken parses an "Authorization: Bearer <token>" header value.
+// Tokens naming a key in their kid header are verified against the
+// keyring. Tokens without one are verified with the legacy secret, see
+// UseLegacySecret.
+func ValidateToken(token string) (*jwt.Token, error) {
 	tokenParts := strings.Split(token, " ")
 
 	if len(tokenParts) != 2 {
@@ -26,6 +66,29 @@ func ValidateToken(token string) (*jwt.Token, error) {
 	}
 
 	return jwt.ParseWithClaims(tokenParts[1], &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(jwtSecret), nil
+		if kid, ok := token.Header["kid"].(string); ok {
+			k := keyring.Load()
+			if k == nil {
+				return nil, errors.New("unknown signing key")
+			}
+			return k.verificationKey(kid, token.Method)
+		}
+
+		l := legacy.Load()
+		if l == nil {
+			return nil, errors.New("unknown signing key")
+		}
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, errors.New("unexpected signing method " + token.Method.Alg())
+		}
+		claims := token.Claims.(*jwt.RegisteredClaims)
+		lifetime, ok := l.lifetimes[claims.Issuer]
+		if !ok || claims.IssuedAt == nil {
+			return nil, errors.New("unknown signing key")
+		}
+		if !claims.IssuedAt.Before(l.issuedBefore) || time.Since(claims.IssuedAt.Time) > lifetime {
+			return nil, errors.New("token signed with a retired key")
+		}
+		return l.secret, nil
 	})
 }
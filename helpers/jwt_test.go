@@ -0,0 +1,64 @@
+package helpers
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestValidateTokenLegacySecret(t *testing.T) {
+	k, err := NewKeyring(filepath.Join(t.TempDir(), "jwt_keys.json"), AlgorithmEdDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	UseKeyring(k)
+	defer UseKeyring(nil)
+	cutover := k.CreatedAt()
+	lifetimes := map[string]time.Duration{"chirpy-access": time.Hour}
+
+	tests := []struct {
+		name     string
+		secret   string
+		issuedAt time.Time
+		issuer   string
+		valid    bool
+	}{
+		{name: "issued before the keyring", secret: "secret", issuedAt: cutover.Add(-time.Minute), issuer: "chirpy-access", valid: true},
+		{name: "no legacy secret", issuedAt: cutover.Add(-time.Minute), issuer: "chirpy-access"},
+		{name: "issued after the keyring", secret: "secret", issuedAt: cutover.Add(time.Second), issuer: "chirpy-access"},
+		{name: "older than its lifetime", secret: "secret", issuedAt: cutover.Add(-2 * time.Hour), issuer: "chirpy-access"},
+		{name: "unknown issuer", secret: "secret", issuedAt: cutover.Add(-time.Minute), issuer: "chirpy-other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			UseLegacySecret(tt.secret, cutover, lifetimes)
+			defer UseLegacySecret("", time.Time{}, nil)
+
+			claims := &jwt.RegisteredClaims{
+				Issuer:    tt.issuer,
+				Subject:   "1",
+				IssuedAt:  jwt.NewNumericDate(tt.issuedAt),
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			}
+			token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = ValidateToken("Bearer " + token)
+			if tt.valid != (err == nil) {
+				t.Errorf("ValidateToken() error = %v, want valid: %t", err, tt.valid)
+			}
+		})
+	}
+
+	signed, err := CreateToken(&jwt.RegisteredClaims{Subject: "1", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ValidateToken("Bearer " + signed); err != nil {
+		t.Errorf("token signed by the keyring: %v", err)
+	}
+}
@@ -0,0 +1,371 @@
+package helpers
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+
+	rsaKeyBits = 2048
+)
+
+// signingKey is a key of the keyring as stored in the keys file. RotatedAt
+// is set once a newer key took over signing.
+type signingKey struct {
+	Id         string     `json:"kid"`
+	Algorithm  string     `json:"alg"`
+	PrivateKey string     `json:"private_key"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RotatedAt  *time.Time `json:"rotated_at,omitempty"`
+
+	signer crypto.Signer
+}
+
+// keyFile is the keys file. CreatedAt is when the keyring was created,
+// before which tokens were signed with JWT_SECRET.
+type keyFile struct {
+	CreatedAt time.Time    `json:"created_at"`
+	Keys      []signingKey `json:"keys"`
+}
+
+// JWK is the public half of a signing key, as published in the JWKS.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyId     string `json:"kid"`
+	// N and E are set for RSA keys.
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Curve and X are set for Ed25519 keys.
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Keyring holds the keys tokens are signed with, in a JSON file. The newest
+// key signs new tokens. A key replaced by a rotation keeps verifying the
+// tokens it signed for verifyWindow, which must outlive every token, and
+// is then retired and deleted.
+type Keyring struct {
+	path         string
+	algorithm    string
+	verifyWindow time.Duration
+	createdAt    time.Time
+	mx           *sync.RWMutex
+	// keys are oldest first, the last one signs.
+	keys []signingKey
+}
+
+// NewKeyring loads the keys from the file at path. A key is generated if
+// there is none yet or the newest key does not use algorithm.
+func NewKeyring(path string, algorithm string, verifyWindow time.Duration) (*Keyring, error) {
+	if algorithm != AlgorithmRS256 && algorithm != AlgorithmEdDSA {
+		return nil, fmt.Errorf("unsupported signing algorithm %q, use %s or %s", algorithm, AlgorithmRS256, AlgorithmEdDSA)
+	}
+	k := &Keyring{
+		path:         path,
+		algorithm:    algorithm,
+		verifyWindow: verifyWindow,
+		mx:           &sync.RWMutex{},
+	}
+
+	file, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if len(file) > 0 {
+		keys := keyFile{}
+		err = json.Unmarshal(file, &keys)
+		if err != nil {
+			return nil, fmt.Errorf("decoding %s: %w", path, err)
+		}
+		for i := range keys.Keys {
+			keys.Keys[i].signer, err = decodePrivateKey(keys.Keys[i])
+			if err != nil {
+				return nil, fmt.Errorf("%s: key %s: %w", path, keys.Keys[i].Id, err)
+			}
+		}
+		k.keys = keys.Keys
+		k.createdAt = keys.CreatedAt
+		// Files written before they were dated were created with their
+		// oldest key.
+		if k.createdAt.IsZero() && len(k.keys) > 0 {
+			k.createdAt = k.keys[0].CreatedAt
+		}
+	}
+
+	if k.createdAt.IsZero() {
+		k.createdAt = time.Now().UTC()
+	}
+	if len(k.keys) == 0 || k.keys[len(k.keys)-1].Algorithm != algorithm {
+		err = k.Rotate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// Rotate generates a new signing key. The previous key keeps verifying
+// tokens until it retires.
+func (k *Keyring) Rotate() error {
+	key, err := generateKey(k.algorithm)
+	if err != nil {
+		return err
+	}
+
+	k.mx.Lock()
+	defer k.mx.Unlock()
+
+	keys := slices.Clone(k.keys)
+	if len(keys) > 0 {
+		rotatedAt := key.CreatedAt
+		keys[len(keys)-1].RotatedAt = &rotatedAt
+	}
+	keys = append(keys, key)
+	keys = k.live(keys, key.CreatedAt)
+
+	err = k.save(keys)
+	if err != nil {
+		return err
+	}
+	k.keys = keys
+	return nil
+}
+
+// RunRotation rotates the signing key once it is older than every, and
+// deletes retired keys, checking every hour until ctx is cancelled.
+func (k *Keyring) RunRotation(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(min(every, time.Hour))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if time.Since(k.current().CreatedAt) >= every {
+			err := k.Rotate()
+			if err != nil {
+				log.Printf("helpers: rotating signing key: %v", err)
+				continue
+			}
+			log.Printf("helpers: rotated signing key, now signing with %s", k.current().Id)
+			continue
+		}
+
+		err := k.prune()
+		if err != nil {
+			log.Printf("helpers: retiring signing keys: %v", err)
+		}
+	}
+}
+
+// Sign signs the claims with the newest key, naming it in the kid header.
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	key := k.current()
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+	token.Header["kid"] = key.Id
+	return token.SignedString(key.signer)
+}
+
+// verificationKey returns the public key of the key named kid, if it has
+// not retired and signs with method.
+func (k *Keyring) verificationKey(kid string, method jwt.SigningMethod) (crypto.PublicKey, error) {
+	k.mx.RLock()
+	defer k.mx.RUnlock()
+
+	now := time.Now().UTC()
+	for _, key := range k.keys {
+		if key.Id != kid || k.retired(key, now) {
+			continue
+		}
+		if method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("unexpected signing method %s", method.Alg())
+		}
+		return key.signer.Public(), nil
+	}
+	return nil, errors.New("unknown signing key")
+}
+
+// JWKS returns the public keys that have not retired.
+func (k *Keyring) JWKS() JWKS {
+	k.mx.RLock()
+	defer k.mx.RUnlock()
+
+	jwks := JWKS{Keys: []JWK{}}
+	now := time.Now().UTC()
+	for _, key := range k.keys {
+		if k.retired(key, now) {
+			continue
+		}
+		jwk := JWK{
+			Use:       "sig",
+			Algorithm: key.Algorithm,
+			KeyId:     key.Id,
+		}
+		switch public := key.signer.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks
+}
+
+// CreatedAt returns when the keyring was created. Tokens issued before
+// were signed with JWT_SECRET.
+func (k *Keyring) CreatedAt() time.Time {
+	return k.createdAt
+}
+
+func (k *Keyring) current() signingKey {
+	k.mx.RLock()
+	defer k.mx.RUnlock()
+
+	return k.keys[len(k.keys)-1]
+}
+
+func (k *Keyring) retired(key signingKey, now time.Time) bool {
+	return key.RotatedAt != nil && !key.RotatedAt.Add(k.verifyWindow).After(now)
+}
+
+// live returns the keys that have not retired by now.
+func (k *Keyring) live(keys []signingKey, now time.Time) []signingKey {
+	return slices.DeleteFunc(keys, func(key signingKey) bool {
+		return k.retired(key, now)
+	})
+}
+
+// prune deletes the keys that retired from the file.
+func (k *Keyring) prune() error {
+	k.mx.Lock()
+	defer k.mx.Unlock()
+
+	keys := k.live(slices.Clone(k.keys), time.Now().UTC())
+	if len(keys) == len(k.keys) {
+		return nil
+	}
+	err := k.save(keys)
+	if err != nil {
+		return err
+	}
+	k.keys = keys
+	return nil
+}
+
+// save replaces the keys file atomically. It is only readable by its owner
+// as it holds the private keys.
+func (k *Keyring) save(keys []signingKey) error {
+	data, err := json.MarshalIndent(keyFile{CreatedAt: k.createdAt, Keys: keys}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(k.path), filepath.Base(k.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), k.path)
+}
+
+func generateKey(algorithm string) (signingKey, error) {
+	var signer crypto.Signer
+	var err error
+	switch algorithm {
+	case AlgorithmRS256:
+		signer, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case AlgorithmEdDSA:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		return signingKey{}, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return signingKey{}, err
+	}
+	id := make([]byte, 8)
+	_, err = rand.Read(id)
+	if err != nil {
+		return signingKey{}, err
+	}
+
+	return signingKey{
+		Id:         hex.EncodeToString(id),
+		Algorithm:  algorithm,
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		CreatedAt:  time.Now().UTC(),
+		signer:     signer,
+	}, nil
+}
+
+func decodePrivateKey(key signingKey) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return nil, errors.New("private key is not PEM encoded")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	switch signer := parsed.(type) {
+	case *rsa.PrivateKey:
+		if key.Algorithm == AlgorithmRS256 {
+			return signer, nil
+		}
+	case ed25519.PrivateKey:
+		if key.Algorithm == AlgorithmEdDSA {
+			return signer, nil
+		}
+	}
+	return nil, fmt.Errorf("private key does not match algorithm %s", key.Algorithm)
+}
@@ -1,14 +1,36 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/ortin779/chirpy/api"
 	"github.com/ortin779/chirpy/app"
 	"github.com/ortin779/chirpy/db"
+	"github.com/ortin779/chirpy/events"
+	"github.com/ortin779/chirpy/helpers"
+	"github.com/ortin779/chirpy/moderation"
+	"github.com/ortin779/chirpy/search"
+	"github.com/ortin779/chirpy/timeline"
+)
+
+const (
+	// tokenSweepInterval is how often expired and revoked refresh tokens
+	// are deleted.
+	tokenSweepInterval = time.Hour
+	// keyVerifyWindow is how long a rotated signing key keeps verifying
+	// tokens, longer than the six days a refresh token lives.
+	keyVerifyWindow = 7 * 24 * time.Hour
 )
 
 func main() {
@@ -16,38 +38,275 @@ func main() {
 	if err != nil {
 		log.Fatalln("error while loading env variables")
 	}
+	dbDriver, dbPath := databaseConfig()
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err = runMigrate(dbDriver, dbPath, os.Args[2:])
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
 	apiCfg := app.ApiConfig{}
 	mux := http.NewServeMux()
 	corsMux := api.MiddlewareCors(mux)
-	database, err := db.NewDB("database.json")
+	store, err := db.Open(dbDriver, dbPath)
 	if err != nil {
 		log.Fatalf(err.Error())
 	}
+	fanoutThreshold, err := fanoutThresholdConfig()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	hub := events.NewHub()
+	database, err := search.New(events.New(timeline.New(store, fanoutThreshold), hub))
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	moderationFile := os.Getenv("MODERATION_FILE")
+	if moderationFile == "" {
+		moderationFile = "moderation.json"
+	}
+	moderator, err := moderation.New(moderationFile)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	restoreWindow, err := restoreWindowConfig()
+	if err != nil {
+		log.Fatalln(err)
+	}
 
-	chirpHandler := api.NewChirpHandler(database)
+	keysFile, algorithm, keyRotation, err := keyringConfig()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	keyring, err := helpers.NewKeyring(keysFile, algorithm, keyVerifyWindow)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	helpers.UseKeyring(keyring)
+	// JWT_SECRET only verifies the tokens issued before the keyring, for
+	// as long as they live.
+	helpers.UseLegacySecret(os.Getenv("JWT_SECRET"), keyring.CreatedAt(), db.TokenLifetimes())
+
+	purgeCtx, stopPurger := context.WithCancel(context.Background())
+	purgerDone := make(chan struct{})
+	go func() {
+		defer close(purgerDone)
+		db.RunPurger(purgeCtx, database, restoreWindow, min(restoreWindow, time.Hour))
+	}()
+	sweeperDone := make(chan struct{})
+	go func() {
+		defer close(sweeperDone)
+		db.RunTokenSweeper(purgeCtx, database, tokenSweepInterval)
+	}()
+	rotationDone := make(chan struct{})
+	go func() {
+		defer close(rotationDone)
+		keyring.RunRotation(purgeCtx, keyRotation)
+	}()
+
+	auth := api.NewAuthMiddleware(database)
+	chirpHandler := api.NewChirpHandler(database, moderator, restoreWindow)
+	likeHandler := api.NewLikeHandler(database)
+	followHandler := api.NewFollowHandler(database)
+	searchHandler := api.NewSearchHandler(database)
+	notificationHandler := api.NewNotificationHandler(database)
+	conversationHandler := api.NewConversationHandler(database)
+	blockHandler := api.NewBlockHandler(database)
+	streamHandler := api.NewStreamHandler(hub)
+	wsHandler := api.NewWebSocketHandler(database, hub, wsOriginsConfig())
 	userHandler := api.NewUserHandler(database)
 	authHandler := api.NewAuthHandler(database)
+	sessionHandler := api.NewSessionHandler(database)
+	jwksHandler := api.NewJWKSHandler(keyring)
 	polkaHanler := api.NewPolksHandler(database)
+	moderationHandler := api.NewModerationHandler(moderator, database)
 
 	mux.Handle("/app/*", apiCfg.MiddlewareMetricInc(app.HandleFileServer()))
 	mux.HandleFunc("GET /api/healthz", api.HealthHandler)
 	mux.HandleFunc("GET /admin/metrics", apiCfg.MetricsHandler)
 	mux.HandleFunc("/api/reset", apiCfg.ResetHandler)
 
-	mux.Handle("POST /api/chirps", api.AuthMiddleware(chirpHandler.HandleCreateChirp))
-	mux.HandleFunc("GET /api/chirps", chirpHandler.HandleGetChirps)
-	mux.HandleFunc("GET /api/chirps/{chirpId}", chirpHandler.HandleGetChirp)
-	mux.Handle("DELETE /api/chirps/{chirpId}", api.AuthMiddleware(chirpHandler.HandleDeleteChirp))
+	mux.Handle("GET /admin/moderation/policies", api.AdminMiddleware(moderationHandler.HandleGetPolicies))
+	mux.Handle("PUT /admin/moderation/policies/{name}", api.AdminMiddleware(moderationHandler.HandlePutPolicy))
+	mux.Handle("DELETE /admin/moderation/policies/{name}", api.AdminMiddleware(moderationHandler.HandleDeletePolicy))
+	mux.Handle("POST /admin/moderation/policies/{name}/words", api.AdminMiddleware(moderationHandler.HandleAddWords))
+	mux.Handle("DELETE /admin/moderation/policies/{name}/words", api.AdminMiddleware(moderationHandler.HandleRemoveWords))
+	mux.Handle("GET /admin/moderation/flagged", api.AdminMiddleware(moderationHandler.HandleGetFlaggedChirps))
+	mux.Handle("POST /admin/users/{userId}/suspend", api.AdminMiddleware(userHandler.HandleSuspendUser))
+	mux.Handle("DELETE /admin/users/{userId}/suspend", api.AdminMiddleware(userHandler.HandleUnsuspendUser))
+
+	mux.Handle("POST /api/chirps", auth.Required(chirpHandler.HandleCreateChirp))
+	mux.Handle("GET /api/chirps", auth.Optional(chirpHandler.HandleGetChirps))
+	mux.HandleFunc("GET /api/chirps/stream", streamHandler.HandleStreamChirps)
+	mux.Handle("GET /api/chirps/search", auth.Optional(searchHandler.HandleSearchChirps))
+	mux.Handle("GET /api/chirps/{chirpId}", auth.Optional(chirpHandler.HandleGetChirp))
+	mux.Handle("DELETE /api/chirps/{chirpId}", auth.Required(chirpHandler.HandleDeleteChirp))
+	mux.Handle("PUT /api/chirps/{chirpId}", auth.Required(chirpHandler.HandleEditChirp))
+	mux.HandleFunc("GET /api/chirps/{chirpId}/history", chirpHandler.HandleGetChirpHistory)
+	mux.Handle("POST /api/chirps/{chirpId}/restore", auth.Required(chirpHandler.HandleRestoreChirp))
+	mux.Handle("POST /api/chirps/{chirpId}/rechirp", auth.Required(chirpHandler.HandleRechirp))
+	mux.Handle("GET /api/chirps/{chirpId}/replies", auth.Optional(chirpHandler.HandleGetReplies))
+	mux.Handle("GET /api/chirps/{chirpId}/thread", auth.Optional(chirpHandler.HandleGetThread))
+	mux.Handle("POST /api/chirps/{chirpId}/likes", auth.Required(likeHandler.HandleLikeChirp))
+	mux.Handle("DELETE /api/chirps/{chirpId}/likes", auth.Required(likeHandler.HandleUnlikeChirp))
+
+	mux.Handle("GET /api/tags/{tag}/chirps", auth.Optional(chirpHandler.HandleGetTagChirps))
+	mux.HandleFunc("GET /api/tags/trending", chirpHandler.HandleGetTrendingTags)
 
 	mux.HandleFunc("POST /api/users", userHandler.HandleCreateUser)
-	mux.Handle("PUT /api/users", api.AuthMiddleware(userHandler.HandleEditUser))
+	mux.Handle("PUT /api/users", auth.Required(userHandler.HandleEditUser))
+	mux.Handle("GET /api/users/{userId}/likes", auth.Optional(likeHandler.HandleGetLikedChirps))
+	mux.Handle("POST /api/users/{userId}/follow", auth.Required(followHandler.HandleFollowUser))
+	mux.Handle("DELETE /api/users/{userId}/follow", auth.Required(followHandler.HandleUnfollowUser))
+	mux.HandleFunc("GET /api/users/{userId}/followers", followHandler.HandleGetFollowers)
+	mux.HandleFunc("GET /api/users/{userId}/following", followHandler.HandleGetFollowing)
+	mux.Handle("POST /api/users/{userId}/block", auth.Required(blockHandler.HandleBlockUser))
+	mux.Handle("DELETE /api/users/{userId}/block", auth.Required(blockHandler.HandleUnblockUser))
+	mux.Handle("GET /api/blocks", auth.Required(blockHandler.HandleGetBlocks))
+
+	mux.Handle("GET /api/timeline", auth.Required(chirpHandler.HandleGetTimeline))
+
+	mux.Handle("GET /api/ws", auth.Required(wsHandler.HandleWebSocket))
+
+	mux.Handle("GET /api/notifications", auth.Required(notificationHandler.HandleGetNotifications))
+	mux.Handle("POST /api/notifications/read", auth.Required(notificationHandler.HandleMarkNotificationsRead))
+
+	mux.Handle("POST /api/conversations", auth.Required(conversationHandler.HandleCreateConversation))
+	mux.Handle("GET /api/conversations", auth.Required(conversationHandler.HandleGetConversations))
+	mux.Handle("GET /api/conversations/{conversationId}", auth.Required(conversationHandler.HandleGetConversation))
+	mux.Handle("POST /api/conversations/{conversationId}/messages", auth.Required(conversationHandler.HandleSendMessage))
+	mux.Handle("GET /api/conversations/{conversationId}/messages", auth.Required(conversationHandler.HandleGetMessages))
+	mux.Handle("POST /api/conversations/{conversationId}/read", auth.Required(conversationHandler.HandleMarkConversationRead))
+
+	mux.HandleFunc("GET /.well-known/jwks.json", jwksHandler.HandleGetJWKS)
 
 	mux.HandleFunc("POST /api/login", authHandler.HandleLogin)
 	mux.HandleFunc("POST /api/refresh", authHandler.HandleRefresToken)
 	mux.HandleFunc("POST /api/revoke", authHandler.HandleRevokeToken)
+	mux.Handle("POST /api/logout-all", auth.Required(sessionHandler.HandleLogoutAll))
+
+	mux.Handle("GET /api/sessions", auth.Required(sessionHandler.HandleGetSessions))
+	mux.Handle("DELETE /api/sessions/{sessionId}", auth.Required(sessionHandler.HandleRevokeSession))
 
 	mux.HandleFunc("POST /api/polka/webhooks", polkaHanler.HandlePolkaWebhook)
 
-	fmt.Println("Starting server on 8080")
-	http.ListenAndServe(":8080", corsMux)
+	server := &http.Server{
+		Addr:    ":8080",
+		Handler: corsMux,
+	}
+	// Streams never go idle on their own, closing the hub ends them.
+	server.RegisterOnShutdown(hub.Close)
+
+	go func() {
+		fmt.Println("Starting server on 8080")
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalln(err)
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = server.Shutdown(ctx)
+	if err != nil {
+		log.Println(err)
+	}
+
+	stopPurger()
+	<-purgerDone
+	<-sweeperDone
+	<-rotationDone
+
+	// Closing the store flushes any pending writes to disk.
+	err = database.Close()
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func databaseConfig() (driver string, path string) {
+	path = os.Getenv("DB_PATH")
+	if path == "" {
+		path = "database.json"
+	}
+	return os.Getenv("DB_DRIVER"), path
+}
+
+// restoreWindowConfig reads how long deleted chirps can be restored before
+// they are purged, 24 hours unless CHIRP_RESTORE_WINDOW is set.
+func restoreWindowConfig() (time.Duration, error) {
+	window := os.Getenv("CHIRP_RESTORE_WINDOW")
+	if window == "" {
+		return 24 * time.Hour, nil
+	}
+	parsed, err := time.ParseDuration(window)
+	if err != nil {
+		return 0, fmt.Errorf("CHIRP_RESTORE_WINDOW: %w", err)
+	}
+	if parsed <= 0 {
+		return 0, fmt.Errorf("CHIRP_RESTORE_WINDOW must be positive")
+	}
+	return parsed, nil
+}
+
+// keyringConfig reads where the token signing keys are kept, JWT_KEYS_FILE
+// or jwt_keys.json, the algorithm of new keys, JWT_ALGORITHM or EdDSA, and
+// how often the signing key is rotated, JWT_KEY_ROTATION or 30 days.
+func keyringConfig() (path string, algorithm string, rotation time.Duration, err error) {
+	path = os.Getenv("JWT_KEYS_FILE")
+	if path == "" {
+		path = "jwt_keys.json"
+	}
+	algorithm = os.Getenv("JWT_ALGORITHM")
+	if algorithm == "" {
+		algorithm = helpers.AlgorithmEdDSA
+	}
+
+	rotation = 30 * 24 * time.Hour
+	if value := os.Getenv("JWT_KEY_ROTATION"); value != "" {
+		rotation, err = time.ParseDuration(value)
+		if err != nil {
+			return "", "", 0, fmt.Errorf("JWT_KEY_ROTATION: %w", err)
+		}
+		if rotation <= 0 {
+			return "", "", 0, fmt.Errorf("JWT_KEY_ROTATION must be positive")
+		}
+	}
+	return path, algorithm, rotation, nil
+}
+
+// wsOriginsConfig reads the origins, besides the server's own, whose pages
+// may open WebSockets, the comma separated WS_ALLOWED_ORIGINS.
+func wsOriginsConfig() []string {
+	origins := []string{}
+	for _, origin := range strings.Split(os.Getenv("WS_ALLOWED_ORIGINS"), ",") {
+		origin = strings.TrimSpace(origin)
+		if origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+// fanoutThresholdConfig reads the follower count above which new chirps are
+// no longer pushed into the followers' timelines.
+func fanoutThresholdConfig() (int, error) {
+	threshold := os.Getenv("TIMELINE_FANOUT_THRESHOLD")
+	if threshold == "" {
+		return timeline.DefaultThreshold, nil
+	}
+	parsed, err := strconv.Atoi(threshold)
+	if err != nil || parsed < 0 {
+		return 0, fmt.Errorf("TIMELINE_FANOUT_THRESHOLD must be a non-negative number")
+	}
+	return parsed, nil
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/ortin779/chirpy/db"
+)
+
+// runMigrate implements `chirpy migrate [-dry-run]`, which upgrades the
+// JSON database to the current schema version and reports every change.
+func runMigrate(driver string, path string, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "report pending migrations without changing the database")
+	flags.Parse(args)
+
+	if driver == db.DriverSQLite {
+		return errors.New("sqlite databases are migrated automatically on startup")
+	}
+
+	migrations, err := db.MigrateFile(path, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	if len(migrations) == 0 {
+		fmt.Printf("%s is up to date\n", path)
+		return nil
+	}
+
+	verb := "Applied"
+	if *dryRun {
+		verb = "Would apply"
+	}
+	for _, migration := range migrations {
+		fmt.Printf("%s migration %d: %s\n", verb, migration.Version, migration.Description)
+		for _, change := range migration.Changes {
+			fmt.Printf("  - %s\n", change)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ortin779/chirpy/db"
+)
+
+func TestRunMigrateDryRun(t *testing.T) {
+	fixture, err := os.ReadFile(filepath.Join("db", "testdata", "v0.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "chirpy.json")
+	err = os.WriteFile(path, fixture, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := captureStdout(t, func() {
+		err = runMigrate(db.DriverJSON, path, []string{"-dry-run"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"Would apply migration 1: rename revoked_tokens to refresh_tokens",
+		"  - renamed revoked_tokens to refresh_tokens (2 entries)",
+		"Would apply migration 4: store refresh tokens hashed, with their owner and expiry",
+	} {
+		if !strings.Contains(output, line+"\n") {
+			t.Errorf("output is missing %q:\n%s", line, output)
+		}
+	}
+	after, err := os.ReadFile(path)
+	if err != nil || !bytes.Equal(fixture, after) {
+		t.Error("dry run modified the database")
+	}
+
+	output = captureStdout(t, func() {
+		err = runMigrate(db.DriverJSON, path, nil)
+	})
+	if err != nil || !strings.Contains(output, "Applied migration 1: ") {
+		t.Fatalf("migrate = %v:\n%s", err, output)
+	}
+	output = captureStdout(t, func() {
+		err = runMigrate(db.DriverJSON, path, []string{"-dry-run"})
+	})
+	if err != nil || output != path+" is up to date\n" {
+		t.Errorf("dry run after migrating = %v:\n%s", err, output)
+	}
+}
+
+func TestRunMigrateRejectsSQLite(t *testing.T) {
+	err := runMigrate(db.DriverSQLite, filepath.Join(t.TempDir(), "chirpy.db"), nil)
+	if err == nil {
+		t.Error("runMigrate() accepted a sqlite database")
+	}
+}
+
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- string(data)
+	}()
+	fn()
+	w.Close()
+	return <-output
+}
@@ -1,7 +1,81 @@
 package models
 
+import "time"
+
 type Chirp struct {
-	Id       int    `json:"id"`
-	Body     string `json:"body"`
-	AuthorId int    `json:"author_id"`
+	Id        int       `json:"id"`
+	Body      string    `json:"body"`
+	AuthorId  int       `json:"author_id"`
+	Flagged   bool      `json:"flagged"`
+	Entities  []Entity  `json:"entities"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// DeletedAt is set once the chirp is deleted. Deleted chirps are kept
+	// as tombstones until their restore window has passed.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// ReplyTo is the id of the chirp this one replies to, zero for chirps
+	// that start a conversation.
+	ReplyTo int `json:"reply_to,omitempty"`
+	// QuoteOf is the id of the chirp a quote-chirp shares along with its
+	// own body.
+	QuoteOf int `json:"quote_of,omitempty"`
+	// RechirpOf is the id of the chirp a rechirp shares, rechirps have no
+	// body of their own.
+	RechirpOf int `json:"rechirp_of,omitempty"`
+	// ReplyCount is the number of replies that have not been deleted.
+	ReplyCount int `json:"reply_count"`
+	LikeCount  int `json:"like_count"`
+	// LikedByMe tells whether the user making the request liked the chirp,
+	// it is filled in per request and is always false in the database.
+	LikedByMe bool `json:"liked_by_me"`
+	// Original is the quoted or rechirped chirp, filled in per request like
+	// LikedByMe. OriginalDeleted is set instead once it has been deleted.
+	Original        *Chirp `json:"original,omitempty"`
+	OriginalDeleted bool   `json:"original_deleted,omitempty"`
+}
+
+// ChirpVersion is one revision of a chirp body. CreatedAt is the time the
+// revision was written.
+type ChirpVersion struct {
+	Version   int       `json:"version"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ChirpQuery filters and pages the chirps returned by GetChirps. Zero
+// values disable a filter: a zero AuthorId matches every author, zero
+// Since and Until leave the time range open and a zero Limit returns every
+// match. Since is inclusive and Until exclusive. FlaggedOnly restricts the
+// result to chirps flagged for review, a non-zero ReplyTo to the replies
+// of that chirp, a non-zero FollowedBy to the chirps of the users that
+// user follows and a non-empty Tag to the chirps using that normalized
+// hashtag.
+type ChirpQuery struct {
+	AuthorId    int
+	ReplyTo     int
+	FollowedBy  int
+	Tag         string
+	FlaggedOnly bool
+	Since       time.Time
+	Until       time.Time
+	Sort        string
+	Limit       int
+	Cursor      string
+}
+
+type ChirpPage struct {
+	Chirps     []Chirp `json:"chirps"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+// ChirpThread is a chirp together with the chain of chirps it replies to,
+// root first, and the tree of its replies.
+type ChirpThread struct {
+	Ancestors []Chirp   `json:"ancestors"`
+	Chirp     ChirpNode `json:"chirp"`
+}
+
+type ChirpNode struct {
+	Chirp
+	Replies []ChirpNode `json:"replies"`
 }
@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+// Conversation is a private exchange of messages between two or more
+// users. UpdatedAt is the time of the last message.
+type Conversation struct {
+	Id        int                  `json:"id"`
+	Members   []ConversationMember `json:"members"`
+	CreatedAt time.Time            `json:"created_at"`
+	UpdatedAt time.Time            `json:"updated_at"`
+
+	// LastMessage and UnreadCount are filled in for the user asking.
+	LastMessage *Message `json:"last_message,omitempty"`
+	UnreadCount int      `json:"unread_count"`
+}
+
+// ConversationMember is a member of a conversation and their read receipt,
+// the last message they have read.
+type ConversationMember struct {
+	UserId            int        `json:"user_id"`
+	JoinedAt          time.Time  `json:"joined_at"`
+	LastReadMessageId int        `json:"last_read_message_id"`
+	ReadAt            *time.Time `json:"read_at,omitempty"`
+}
+
+type Message struct {
+	Id             int       `json:"id"`
+	ConversationId int       `json:"conversation_id"`
+	SenderId       int       `json:"sender_id"`
+	Body           string    `json:"body"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// MessageQuery selects a page of the messages of a conversation, newest
+// first.
+type MessageQuery struct {
+	Limit  int
+	Cursor string
+}
+
+type MessagePage struct {
+	Messages   []Message `json:"messages"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+// Block records that the blocker blocked the blocked user, who can no
+// longer message them.
+type Block struct {
+	BlockerId int       `json:"blocker_id"`
+	BlockedId int       `json:"blocked_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
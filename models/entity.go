@@ -0,0 +1,20 @@
+package models
+
+// Entity is a hashtag or mention found in a chirp body. Start and End are
+// byte offsets into the body, the range includes the leading # or @.
+type Entity struct {
+	Type string `json:"type"`
+	// Text is the tag or handle as written, without the leading # or @.
+	Text  string `json:"text"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	// UserId is the user a mention refers to, zero if the handle does not
+	// match exactly one user.
+	UserId int `json:"user_id,omitempty"`
+}
+
+// TagCount is the number of chirps that used a hashtag.
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
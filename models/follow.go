@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Follow records that the follower follows the followee.
+type Follow struct {
+	FollowerId int       `json:"follower_id"`
+	FolloweeId int       `json:"followee_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// FollowUser is an entry of a follower or following list.
+type FollowUser struct {
+	Id         int       `json:"id"`
+	FollowedAt time.Time `json:"followed_at"`
+}
@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// Like records that a user liked a chirp. A user likes a chirp at most once.
+type Like struct {
+	ChirpId   int       `json:"chirp_id"`
+	UserId    int       `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+const (
+	NotificationMention = "mention"
+	NotificationReply   = "reply"
+	NotificationQuote   = "quote"
+	NotificationRechirp = "rechirp"
+	NotificationLike    = "like"
+	NotificationFollow  = "follow"
+)
+
+// Notification tells a user that the actor mentioned them, replied to,
+// quoted, rechirped or liked one of their chirps, or followed them.
+// ChirpId is the mentioning chirp, the reply, the quote, the rechirp or the
+// liked chirp.
+type Notification struct {
+	Id        int        `json:"id"`
+	UserId    int        `json:"user_id"`
+	Type      string     `json:"type"`
+	ActorId   int        `json:"actor_id"`
+	ChirpId   int        `json:"chirp_id,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ReadAt    *time.Time `json:"read_at,omitempty"`
+}
+
+// NotificationGroup is a burst of notifications about the same thing, like
+// every unread like of a chirp, shown as a single entry.
+type NotificationGroup struct {
+	Type    string `json:"type"`
+	ChirpId int    `json:"chirp_id,omitempty"`
+	// ActorIds holds every actor once, most recent first.
+	ActorIds        []int     `json:"actor_ids"`
+	Message         string    `json:"message"`
+	Read            bool      `json:"read"`
+	NotificationIds []int     `json:"notification_ids"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// NotificationInbox is the response of the notifications endpoint.
+type NotificationInbox struct {
+	UnreadCount   int                 `json:"unread_count"`
+	Notifications []NotificationGroup `json:"notifications"`
+}
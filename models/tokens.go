@@ -1,10 +1,62 @@
 package models
 
+import "time"
+
 type RefreshTokenResponse struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
 }
 
+// RefreshToken is a refresh token handed out to a user. Every refresh
+// rotates it: the token is replaced by a new one of the same family and
+// ReplacedBy records its successor. Only the SHA-256 hash of a token is
+// stored, Id and ReplacedBy are hashes. AccessTokenId is the jti of the
+// access token handed out along with it.
 type RefreshToken struct {
-	Id         string `json:"id"`
-	HasRevoked bool   `json:"hasRevoked"`
+	Id            string    `json:"id"`
+	UserId        int       `json:"user_id"`
+	HasRevoked    bool      `json:"hasRevoked"`
+	FamilyId      string    `json:"family_id,omitempty"`
+	ReplacedBy    string    `json:"replaced_by,omitempty"`
+	AccessTokenId string    `json:"access_token_id,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+// RevokedAccessToken is an access token on the denylist, by its jti. It is
+// kept until the token expires.
+type RevokedAccessToken struct {
+	Id        string    `json:"id"`
+	UserId    int       `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// TokenFamily is the chain of refresh tokens rotated from a single login,
+// the session of a device. Presenting a token that was already rotated
+// revokes the whole family.
+type TokenFamily struct {
+	Id         string     `json:"id"`
+	UserId     int        `json:"user_id"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt time.Time  `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Client describes where a login or refresh request came from.
+type Client struct {
+	UserAgent string
+	IP        string
+}
+
+// Session is a token family that can still be refreshed. ExpiresAt is the
+// expiry of its current refresh token.
+type Session struct {
+	Id         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
 }
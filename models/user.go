@@ -1,8 +1,11 @@
 package models
 
+import "time"
+
 type UserRequestBody struct {
 	Password string `json:"password"`
 	Email    string `json:"email"`
+	Handle   string `json:"handle"`
 }
 
 type UserLoginResponse struct {
@@ -14,14 +17,24 @@ type UserLoginResponse struct {
 }
 
 type UserResponse struct {
-	Id          int    `json:"id"`
-	Email       string `json:"email"`
-	IsChirpyRed bool   `json:"is_chirpy_red"`
+	Id          int        `json:"id"`
+	Email       string     `json:"email"`
+	Handle      string     `json:"handle,omitempty"`
+	IsChirpyRed bool       `json:"is_chirpy_red"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	SuspendedAt *time.Time `json:"suspended_at,omitempty"`
 }
 
+// User is an account. A suspended user, with SuspendedAt set, cannot log
+// in. Mentions of the Handle, unique regardless of case, link to the user.
 type User struct {
-	Id          int    `json:"id"`
-	Email       string `json:"email"`
-	Password    string `json:"password"`
-	IsChirpyRed bool   `json:"is_chirpy_red"`
+	Id          int        `json:"id"`
+	Email       string     `json:"email"`
+	Handle      string     `json:"handle,omitempty"`
+	Password    string     `json:"password"`
+	IsChirpyRed bool       `json:"is_chirpy_red"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	SuspendedAt *time.Time `json:"suspended_at,omitempty"`
 }
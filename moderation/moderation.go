@@ -0,0 +1,334 @@
+package moderation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Action is what happens to a chirp that contains a word of a policy.
+type Action string
+
+const (
+	// Mask replaces every matched word with Mask.
+	ActionMask Action = "mask"
+	// Reject refuses the chirp.
+	ActionReject Action = "reject"
+	// Flag accepts the chirp unchanged but marks it for review.
+	ActionFlag Action = "flag"
+)
+
+const Mask = "****"
+
+type Policy struct {
+	Name   string   `json:"name"`
+	Action Action   `json:"action"`
+	Words  []string `json:"words"`
+}
+
+type config struct {
+	Policies []Policy `json:"policies"`
+}
+
+// defaultPolicies are used until a moderation file is written.
+var defaultPolicies = []Policy{
+	{
+		Name:   "profanity",
+		Action: ActionMask,
+		Words:  []string{"kerfuffle", "sharbert", "fornax"},
+	},
+}
+
+// Result is the outcome of moderating a chirp body.
+type Result struct {
+	Body    string
+	Flagged bool
+}
+
+// RejectedError is returned by Moderate when a body contains a word of a
+// reject policy.
+type RejectedError struct {
+	Policy string
+}
+
+func (rerr RejectedError) Error() string {
+	return fmt.Sprintf("chirp violates the %s policy", rerr.Policy)
+}
+
+// ValidationError is returned when a policy edit is invalid.
+type ValidationError struct {
+	message string
+}
+
+func (verr ValidationError) Error() string {
+	return verr.message
+}
+
+type NotFoundError struct{}
+
+func (NotFoundError) Error() string {
+	return "policy not found"
+}
+
+// Moderator checks chirp bodies against the configured policies. Policies
+// are loaded from a JSON file and every edit is written back to it.
+type Moderator struct {
+	path     string
+	mx       *sync.RWMutex
+	policies []Policy
+	// words maps every lower cased word to the indexes of the policies
+	// listing it.
+	words map[string][]int
+}
+
+// New loads the policies from the file at path, falling back to the
+// default policies if the file does not exist yet.
+func New(path string) (*Moderator, error) {
+	m := &Moderator{
+		path: path,
+		mx:   &sync.RWMutex{},
+	}
+
+	file, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		m.setPolicies(slices.Clone(defaultPolicies))
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := config{}
+	err = json.Unmarshal(file, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", path, err)
+	}
+	for i, policy := range cfg.Policies {
+		cfg.Policies[i], err = normalize(policy)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	m.setPolicies(cfg.Policies)
+	return m, nil
+}
+
+// Moderate applies every policy to body. Reject policies take precedence,
+// otherwise words of mask policies are masked and the result is flagged
+// if a word of a flag policy is present.
+func (m *Moderator) Moderate(body string) (Result, error) {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+
+	result := Result{}
+	masked := strings.Builder{}
+	last := 0
+
+	for _, span := range wordSpans(body) {
+		word := strings.ToLower(body[span[0]:span[1]])
+		mask := false
+		for _, idx := range m.words[word] {
+			policy := m.policies[idx]
+			switch policy.Action {
+			case ActionReject:
+				return Result{}, RejectedError{Policy: policy.Name}
+			case ActionFlag:
+				result.Flagged = true
+			case ActionMask:
+				mask = true
+			}
+		}
+		if mask {
+			masked.WriteString(body[last:span[0]])
+			masked.WriteString(Mask)
+			last = span[1]
+		}
+	}
+	masked.WriteString(body[last:])
+
+	result.Body = masked.String()
+	return result, nil
+}
+
+func (m *Moderator) Policies() []Policy {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+
+	policies := make([]Policy, 0, len(m.policies))
+	for _, policy := range m.policies {
+		policy.Words = slices.Clone(policy.Words)
+		policies = append(policies, policy)
+	}
+	return policies
+}
+
+// SetPolicy creates the policy or replaces the one with the same name.
+func (m *Moderator) SetPolicy(policy Policy) (Policy, error) {
+	policy, err := normalize(policy)
+	if err != nil {
+		return Policy{}, err
+	}
+
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	policies := slices.Clone(m.policies)
+	idx := m.indexOf(policy.Name)
+	if idx < 0 {
+		policies = append(policies, policy)
+	} else {
+		policies[idx] = policy
+	}
+	return policy, m.save(policies)
+}
+
+func (m *Moderator) DeletePolicy(name string) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	idx := m.indexOf(name)
+	if idx < 0 {
+		return NotFoundError{}
+	}
+	return m.save(slices.Delete(slices.Clone(m.policies), idx, idx+1))
+}
+
+// AddWords adds words to an existing policy.
+func (m *Moderator) AddWords(name string, words []string) (Policy, error) {
+	return m.editWords(name, func(policy Policy) Policy {
+		policy.Words = append(slices.Clone(policy.Words), words...)
+		return policy
+	})
+}
+
+// RemoveWords removes words from an existing policy.
+func (m *Moderator) RemoveWords(name string, words []string) (Policy, error) {
+	return m.editWords(name, func(policy Policy) Policy {
+		policy.Words = slices.DeleteFunc(slices.Clone(policy.Words), func(word string) bool {
+			return slices.ContainsFunc(words, func(removed string) bool {
+				return strings.EqualFold(strings.TrimSpace(removed), word)
+			})
+		})
+		return policy
+	})
+}
+
+func (m *Moderator) editWords(name string, edit func(Policy) Policy) (Policy, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	idx := m.indexOf(name)
+	if idx < 0 {
+		return Policy{}, NotFoundError{}
+	}
+
+	policy, err := normalize(edit(m.policies[idx]))
+	if err != nil {
+		return Policy{}, err
+	}
+	policies := slices.Clone(m.policies)
+	policies[idx] = policy
+	return policy, m.save(policies)
+}
+
+func (m *Moderator) indexOf(name string) int {
+	return slices.IndexFunc(m.policies, func(policy Policy) bool { return policy.Name == name })
+}
+
+// save writes policies to the moderation file and, if that succeeded,
+// makes them the active policies. It is called with the write lock held.
+func (m *Moderator) save(policies []Policy) error {
+	data, err := json.MarshalIndent(config{Policies: policies}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(m.path), filepath.Base(m.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp.Name(), m.path)
+	if err != nil {
+		return err
+	}
+
+	m.setPolicies(policies)
+	return nil
+}
+
+func (m *Moderator) setPolicies(policies []Policy) {
+	words := make(map[string][]int)
+	for idx, policy := range policies {
+		for _, word := range policy.Words {
+			words[word] = append(words[word], idx)
+		}
+	}
+	m.policies = policies
+	m.words = words
+}
+
+// normalize validates a policy and lower cases, de-duplicates and sorts
+// its words.
+func normalize(policy Policy) (Policy, error) {
+	policy.Name = strings.TrimSpace(policy.Name)
+	if policy.Name == "" {
+		return Policy{}, ValidationError{message: "policy name is required"}
+	}
+
+	switch policy.Action {
+	case ActionMask, ActionReject, ActionFlag:
+	default:
+		return Policy{}, ValidationError{message: fmt.Sprintf("action must be one of %s, %s or %s", ActionMask, ActionReject, ActionFlag)}
+	}
+
+	words := make([]string, 0, len(policy.Words))
+	for _, word := range policy.Words {
+		word = strings.ToLower(strings.TrimSpace(word))
+		spans := wordSpans(word)
+		if len(spans) != 1 || spans[0][0] != 0 || spans[0][1] != len(word) {
+			return Policy{}, ValidationError{message: fmt.Sprintf("%q is not a single word", word)}
+		}
+		words = append(words, word)
+	}
+	slices.Sort(words)
+	policy.Words = slices.Compact(words)
+	return policy, nil
+}
+
+// wordSpans returns the byte ranges of the words in s. A word is a run of
+// letters and digits, so surrounding punctuation never hides a match.
+func wordSpans(s string) [][2]int {
+	spans := [][2]int{}
+	start := -1
+	for i, r := range s {
+		inWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if inWord && start < 0 {
+			start = i
+		}
+		if !inWord && start >= 0 {
+			spans = append(spans, [2]int{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, [2]int{start, len(s)})
+	}
+	return spans
+}
@@ -0,0 +1,137 @@
+package moderation
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestModerate(t *testing.T) {
+	m := newTestModerator(t,
+		Policy{Name: "profanity", Action: ActionMask, Words: []string{"Kerfuffle", "fornax"}},
+		Policy{Name: "spam", Action: ActionFlag, Words: []string{"giveaway"}},
+		Policy{Name: "threats", Action: ActionReject, Words: []string{"doxx"}},
+		Policy{Name: "overlap", Action: ActionFlag, Words: []string{"fornax"}},
+	)
+
+	tests := []struct {
+		name    string
+		body    string
+		want    Result
+		rejects string
+	}{
+		{name: "clean", body: "hello world", want: Result{Body: "hello world"}},
+		{name: "any case", body: "what a KERFUFFLE", want: Result{Body: "what a ****"}},
+		{name: "punctuation", body: "kerfuffle! (fornax), 'kerfuffle'", want: Result{Body: "****! (****), '****'", Flagged: true}},
+		{name: "only whole words", body: "kerfuffles and fornaxes", want: Result{Body: "kerfuffles and fornaxes"}},
+		{name: "mask and flag", body: "Fornax giveaway", want: Result{Body: "**** giveaway", Flagged: true}},
+		{name: "flag", body: "GIVEAWAY.", want: Result{Body: "GIVEAWAY.", Flagged: true}},
+		{name: "reject wins over mask and flag", body: "kerfuffle giveaway, Doxx!", rejects: "threats"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.Moderate(tt.body)
+			if tt.rejects != "" {
+				rerr := RejectedError{}
+				if !errors.As(err, &rerr) || rerr.Policy != tt.rejects {
+					t.Fatalf("Moderate(%q) error = %v, want a rejection by %s", tt.body, err, tt.rejects)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Moderate(%q) = %+v, want %+v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetPolicyValidates(t *testing.T) {
+	m := newTestModerator(t)
+
+	tests := []struct {
+		name   string
+		policy Policy
+	}{
+		{name: "no name", policy: Policy{Name: " ", Action: ActionMask}},
+		{name: "unknown action", policy: Policy{Name: "p", Action: "hide"}},
+		{name: "two words", policy: Policy{Name: "p", Action: ActionMask, Words: []string{"two words"}}},
+		{name: "punctuation", policy: Policy{Name: "p", Action: ActionMask, Words: []string{"word!"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := m.SetPolicy(tt.policy)
+			if !errors.As(err, &ValidationError{}) {
+				t.Errorf("SetPolicy() error = %v, want a ValidationError", err)
+			}
+		})
+	}
+}
+
+func TestPoliciesArePersisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "moderation.json")
+	m, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.SetPolicy(Policy{Name: "spam", Action: ActionFlag, Words: []string{"Giveaway", "giveaway "}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RemoveWords("spam", []string{"GIVEAWAY"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.AddWords("spam", []string{"Lottery"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := reloaded.Moderate("lottery giveaway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Flagged {
+		t.Error("chirp with a persisted flag word is not flagged")
+	}
+	got, err = reloaded.Moderate("giveaway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Flagged {
+		t.Error("chirp with a removed flag word is flagged")
+	}
+	// The default profanity policy was kept alongside the new one.
+	if got := len(reloaded.Policies()); got != 2 {
+		t.Errorf("reloaded %d policies, want 2", got)
+	}
+	if err := reloaded.DeletePolicy("missing"); !errors.Is(err, NotFoundError{}) {
+		t.Errorf("DeletePolicy() of a missing policy error = %v, want NotFoundError", err)
+	}
+}
+
+// newTestModerator returns a moderator with exactly the given policies.
+func newTestModerator(t *testing.T, policies ...Policy) *Moderator {
+	t.Helper()
+	m, err := New(filepath.Join(t.TempDir(), "moderation.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.DeletePolicy("profanity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, policy := range policies {
+		_, err = m.SetPolicy(policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return m
+}
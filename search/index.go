@@ -0,0 +1,217 @@
+package search
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/ortin779/chirpy/models"
+)
+
+// BM25 parameters, the usual defaults.
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+// document is an indexed chirp.
+type document struct {
+	authorId  int
+	updatedAt time.Time
+	length    int
+	// positions holds the offsets of every term in the body.
+	positions map[string][]int
+}
+
+// index is an inverted index of chirp bodies. It is not safe for concurrent
+// use.
+type index struct {
+	docs map[int]*document
+	// postings holds the ids of the chirps using a term.
+	postings map[string]map[int]bool
+	// terms is the sorted vocabulary, for prefix lookups.
+	terms       []string
+	totalLength int
+}
+
+func newIndex() *index {
+	return &index{
+		docs:     make(map[int]*document),
+		postings: make(map[string]map[int]bool),
+	}
+}
+
+// tokenize splits text into lower cased words of letters and digits.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// add indexes the chirp, replacing an older version of it. A version older
+// than the indexed one is ignored, so late updates cannot undo an edit.
+func (ix *index) add(chirp models.Chirp) {
+	if doc, ok := ix.docs[chirp.Id]; ok {
+		if doc.updatedAt.After(chirp.UpdatedAt) {
+			return
+		}
+		ix.remove(chirp.Id)
+	}
+
+	words := tokenize(chirp.Body)
+	if len(words) == 0 {
+		return
+	}
+	doc := &document{
+		authorId:  chirp.AuthorId,
+		updatedAt: chirp.UpdatedAt,
+		length:    len(words),
+		positions: make(map[string][]int),
+	}
+	for pos, word := range words {
+		doc.positions[word] = append(doc.positions[word], pos)
+	}
+	for term := range doc.positions {
+		ids, ok := ix.postings[term]
+		if !ok {
+			ids = make(map[int]bool)
+			ix.postings[term] = ids
+			idx, _ := slices.BinarySearch(ix.terms, term)
+			ix.terms = slices.Insert(ix.terms, idx, term)
+		}
+		ids[chirp.Id] = true
+	}
+	ix.docs[chirp.Id] = doc
+	ix.totalLength += doc.length
+}
+
+func (ix *index) remove(id int) {
+	doc, ok := ix.docs[id]
+	if !ok {
+		return
+	}
+	for term := range doc.positions {
+		ids := ix.postings[term]
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(ix.postings, term)
+			if idx, found := slices.BinarySearch(ix.terms, term); found {
+				ix.terms = slices.Delete(ix.terms, idx, idx+1)
+			}
+		}
+	}
+	delete(ix.docs, id)
+	ix.totalLength -= doc.length
+}
+
+// hit is a matching chirp and its relevance.
+type hit struct {
+	id    int
+	score float64
+}
+
+// search returns the chirps matching every clause, best match first. Ties
+// go to the newer chirp. Chirps of other authors are skipped if authorId is
+// set.
+func (ix *index) search(clauses []clause, authorId int) []hit {
+	scores := map[int]float64(nil)
+	for _, c := range clauses {
+		matches := ix.match(c)
+		if scores == nil {
+			scores = matches
+			continue
+		}
+		for id := range scores {
+			score, ok := matches[id]
+			if !ok {
+				delete(scores, id)
+				continue
+			}
+			scores[id] += score
+		}
+	}
+
+	hits := make([]hit, 0, len(scores))
+	for id, score := range scores {
+		if authorId != 0 && ix.docs[id].authorId != authorId {
+			continue
+		}
+		hits = append(hits, hit{id: id, score: score})
+	}
+	slices.SortFunc(hits, func(a, b hit) int {
+		if a.score != b.score {
+			if a.score > b.score {
+				return -1
+			}
+			return 1
+		}
+		return b.id - a.id
+	})
+	return hits
+}
+
+// match scores the chirps matching a single clause.
+func (ix *index) match(c clause) map[int]float64 {
+	scores := make(map[int]float64)
+	switch {
+	case c.prefix:
+		// A chirp scores by the best of the terms starting with the prefix.
+		start, _ := slices.BinarySearch(ix.terms, c.terms[0])
+		for _, term := range ix.terms[start:] {
+			if !strings.HasPrefix(term, c.terms[0]) {
+				break
+			}
+			for id := range ix.postings[term] {
+				scores[id] = max(scores[id], ix.score(term, len(ix.docs[id].positions[term]), ix.docs[id]))
+			}
+		}
+	case len(c.terms) == 1:
+		term := c.terms[0]
+		for id := range ix.postings[term] {
+			scores[id] = ix.score(term, len(ix.docs[id].positions[term]), ix.docs[id])
+		}
+	default:
+		for id := range ix.postings[c.terms[0]] {
+			doc := ix.docs[id]
+			count := phraseCount(doc, c.terms)
+			if count == 0 {
+				continue
+			}
+			for _, term := range c.terms {
+				scores[id] += ix.score(term, count, doc)
+			}
+		}
+	}
+	return scores
+}
+
+// phraseCount returns how often the terms appear next to each other, in
+// order, in the document.
+func phraseCount(doc *document, terms []string) int {
+	count := 0
+	for _, start := range doc.positions[terms[0]] {
+		found := true
+		for offset, term := range terms[1:] {
+			if !slices.Contains(doc.positions[term], start+offset+1) {
+				found = false
+				break
+			}
+		}
+		if found {
+			count++
+		}
+	}
+	return count
+}
+
+// score is the BM25 weight of a term that appears freq times in doc.
+func (ix *index) score(term string, freq int, doc *document) float64 {
+	n := float64(len(ix.docs))
+	df := float64(len(ix.postings[term]))
+	idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+	avgLength := float64(ix.totalLength) / n
+	tf := float64(freq)
+	return idf * tf * (k1 + 1) / (tf + k1*(1-b+b*float64(doc.length)/avgLength))
+}
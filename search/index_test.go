@@ -0,0 +1,239 @@
+package search
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ortin779/chirpy/db"
+	"github.com/ortin779/chirpy/models"
+)
+
+func TestRankingIsBM25(t *testing.T) {
+	ix := newIndex()
+	for id, body := range map[int]string{
+		1: "a gopher among many other words",
+		2: "gopher gopher",
+		3: "gopher",
+		4: "rust",
+		5: "same words",
+		6: "same words",
+	} {
+		ix.add(models.Chirp{Id: id, Body: body})
+	}
+
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		// More occurrences in a shorter chirp rank higher.
+		{query: "gopher", want: []int{2, 3, 1}},
+		// A prefix scores like the term it matches.
+		{query: "gopher*", want: []int{2, 3, 1}},
+		// Ties go to the newer chirp.
+		{query: "same", want: []int{6, 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got := hitIds(ix.search(parseQuery(tt.query), 0))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("search(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPhrasesAndPrefixes(t *testing.T) {
+	ix := newIndex()
+	for id, body := range map[int]string{
+		1: "the gopher day is here",
+		2: "day of the gopher",
+		3: "Gophers' day",
+		4: "go home",
+	} {
+		ix.add(models.Chirp{Id: id, Body: body})
+	}
+
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{query: `"gopher day"`, want: []int{1}},
+		{query: `gopher day`, want: []int{1, 2}},
+		{query: `goph*`, want: []int{1, 2, 3}},
+		{query: `goph`, want: []int{}},
+		{query: `go*`, want: []int{1, 2, 3, 4}},
+		{query: `"gophers day" go*`, want: []int{3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got := hitIds(ix.search(parseQuery(tt.query), 0))
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("search(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	store := newTestStore(t)
+	for i := 0; i < 5; i++ {
+		createChirp(t, store, 1, "hello gopher")
+	}
+	createChirp(t, store, 1, "hello rust")
+
+	all, err := store.SearchChirps(Query{Text: "gopher", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := []int{}
+	sizes := []int{}
+	cursor := ""
+	for {
+		page, err := store.SearchChirps(Query{Text: "gopher", Limit: 2, Cursor: cursor})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, chirpIds(page.Chirps)...)
+		sizes = append(sizes, len(page.Chirps))
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	if !slices.Equal(sizes, []int{2, 2, 1}) {
+		t.Errorf("page sizes = %v, want [2 2 1]", sizes)
+	}
+	if want := chirpIds(all.Chirps); !slices.Equal(got, want) {
+		t.Errorf("paged ids = %v, want %v", got, want)
+	}
+
+	for _, cursor := range []string{"not base64!", encodeCursor(0), "e30"} {
+		_, err := store.SearchChirps(Query{Text: "gopher", Limit: 2, Cursor: cursor})
+		if !errors.As(err, &ValidationError{}) {
+			t.Errorf("SearchChirps() with cursor %q error = %v, want a ValidationError", cursor, err)
+		}
+	}
+}
+
+func TestWritesUpdateTheIndex(t *testing.T) {
+	store := newTestStore(t)
+	chirp := createChirp(t, store, 1, "hello gopher")
+
+	_, err := store.UpdateChirp(chirp.Id, 1, "hello rustacean", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSearch(t, store, "gopher")
+	assertSearch(t, store, "rustacean", chirp.Id)
+
+	_, err = store.DeleteChirp(chirp.Id, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSearch(t, store, "rustacean")
+
+	_, err = store.RestoreChirp(chirp.Id, 1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSearch(t, store, "rustacean", chirp.Id)
+	assertSearch(t, store, "gopher")
+}
+
+func TestStaleVersionIsIgnored(t *testing.T) {
+	ix := newIndex()
+	now := time.Now()
+	ix.add(models.Chirp{Id: 1, Body: "edited", UpdatedAt: now})
+	ix.add(models.Chirp{Id: 1, Body: "original", UpdatedAt: now.Add(-time.Minute)})
+
+	if got := hitIds(ix.search(parseQuery("original"), 0)); len(got) != 0 {
+		t.Errorf("search(original) = %v, want no hits", got)
+	}
+	if got := hitIds(ix.search(parseQuery("edited"), 0)); !slices.Equal(got, []int{1}) {
+		t.Errorf("search(edited) = %v, want [1]", got)
+	}
+}
+
+func TestNewSkipsDeletedChirps(t *testing.T) {
+	database := newTestDB(t)
+	kept, err := database.CreateChirp(models.Chirp{Body: "hello gopher", AuthorId: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := database.CreateChirp(models.Chirp{Body: "bye gopher", AuthorId: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = database.DeleteChirp(deleted.Id, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := New(database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSearch(t, store, "gopher", kept.Id)
+}
+
+func newTestDB(t *testing.T) *db.DB {
+	t.Helper()
+	database, err := db.NewDB(filepath.Join(t.TempDir(), "chirpy.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return database
+}
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	store, err := New(newTestDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func createChirp(t *testing.T, store *Store, authorId int, body string) models.Chirp {
+	t.Helper()
+	chirp, err := store.CreateChirp(models.Chirp{Body: body, AuthorId: authorId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chirp
+}
+
+// assertSearch checks that searching for text finds exactly the chirps
+// with the given ids, in order.
+func assertSearch(t *testing.T, store *Store, text string, want ...int) {
+	t.Helper()
+	page, err := store.SearchChirps(Query{Text: text, Limit: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := chirpIds(page.Chirps); !slices.Equal(got, want) {
+		t.Errorf("SearchChirps(%q) = %v, want %v", text, got, want)
+	}
+}
+
+func hitIds(hits []hit) []int {
+	ids := make([]int, 0, len(hits))
+	for _, h := range hits {
+		ids = append(ids, h.id)
+	}
+	return ids
+}
+
+func chirpIds(chirps []models.Chirp) []int {
+	ids := make([]int, 0, len(chirps))
+	for _, chirp := range chirps {
+		ids = append(ids, chirp.Id)
+	}
+	return ids
+}
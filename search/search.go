@@ -0,0 +1,228 @@
+// Package search serves full-text search over chirps. The bodies of the
+// chirps that have not been deleted are kept in an in-memory inverted index,
+// built from the store on startup and updated as chirps are created,
+// edited, deleted and restored. Matches are ranked by BM25.
+package search
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ortin779/chirpy/db"
+	"github.com/ortin779/chirpy/models"
+)
+
+// ValidationError is returned when a search query is invalid.
+type ValidationError struct {
+	message string
+}
+
+func (verr ValidationError) Error() string {
+	return verr.message
+}
+
+// Query is a search request. Text holds words, which all have to match,
+// "quoted phrases" and prefixes ending with *, e.g. gopher*.
+type Query struct {
+	Text     string
+	AuthorId int
+	Limit    int
+	Cursor   string
+}
+
+// Store is a db.Store that keeps the search index up to date on writes.
+// Every other call goes straight to the wrapped store.
+type Store struct {
+	db.Store
+
+	mx    *sync.RWMutex
+	index *index
+}
+
+// New wraps store and indexes every chirp in it that has not been deleted.
+func New(store db.Store) (*Store, error) {
+	page, err := store.GetChirps(models.ChirpQuery{})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		Store: store,
+		mx:    &sync.RWMutex{},
+		index: newIndex(),
+	}
+	for _, chirp := range page.Chirps {
+		s.index.add(chirp)
+	}
+	return s, nil
+}
+
+func (s *Store) CreateChirp(chirp models.Chirp) (models.Chirp, error) {
+	chirp, err := s.Store.CreateChirp(chirp)
+	if err != nil {
+		return models.Chirp{}, err
+	}
+	s.add(chirp)
+	return chirp, nil
+}
+
+func (s *Store) UpdateChirp(id int, authorId int, body string, flagged bool) (models.Chirp, error) {
+	chirp, err := s.Store.UpdateChirp(id, authorId, body, flagged)
+	if err != nil {
+		return models.Chirp{}, err
+	}
+	s.add(chirp)
+	return chirp, nil
+}
+
+func (s *Store) DeleteChirp(id int, authorId int) (models.Chirp, error) {
+	chirp, err := s.Store.DeleteChirp(id, authorId)
+	if err != nil {
+		return models.Chirp{}, err
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.index.remove(id)
+	return chirp, nil
+}
+
+func (s *Store) RestoreChirp(id int, authorId int, window time.Duration) (models.Chirp, error) {
+	chirp, err := s.Store.RestoreChirp(id, authorId, window)
+	if err != nil {
+		return models.Chirp{}, err
+	}
+	s.add(chirp)
+	return chirp, nil
+}
+
+func (s *Store) add(chirp models.Chirp) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.index.add(chirp)
+}
+
+// SearchChirps returns a page of the chirps matching query, best match
+// first.
+func (s *Store) SearchChirps(query Query) (models.ChirpPage, error) {
+	clauses := parseQuery(query.Text)
+	if len(clauses) == 0 {
+		return models.ChirpPage{}, ValidationError{message: "query must contain a word"}
+	}
+	offset, err := decodeCursor(query.Cursor)
+	if err != nil {
+		return models.ChirpPage{}, err
+	}
+
+	s.mx.RLock()
+	hits := s.index.search(clauses, query.AuthorId)
+	s.mx.RUnlock()
+
+	if offset > len(hits) {
+		offset = len(hits)
+	}
+	hits = hits[offset:]
+
+	// Chirps deleted since the index was read are skipped, the page is
+	// filled up with the next hits.
+	page := models.ChirpPage{Chirps: []models.Chirp{}}
+	for len(hits) > 0 && len(page.Chirps) < query.Limit {
+		batch := hits[:min(len(hits), query.Limit-len(page.Chirps))]
+		hits = hits[len(batch):]
+		offset += len(batch)
+
+		ids := make([]int, 0, len(batch))
+		for _, h := range batch {
+			ids = append(ids, h.id)
+		}
+		found, err := s.Store.GetChirpsByIds(ids)
+		if err != nil {
+			return models.ChirpPage{}, err
+		}
+		for _, id := range ids {
+			if chirp, ok := found[id]; ok {
+				page.Chirps = append(page.Chirps, chirp)
+			}
+		}
+	}
+	if len(hits) > 0 {
+		page.NextCursor = encodeCursor(offset)
+	}
+	return page, nil
+}
+
+// clause is a single part of a query: a word, a phrase of several words or
+// a prefix.
+type clause struct {
+	terms  []string
+	prefix bool
+}
+
+// parseQuery splits text into clauses. Words that tokenize into several
+// terms, like "e-mail", are matched as a phrase.
+func parseQuery(text string) []clause {
+	clauses := []clause{}
+	for text != "" {
+		text = strings.TrimLeft(text, " \t\r\n")
+		if text == "" {
+			break
+		}
+
+		if text[0] == '"' {
+			phrase, rest, _ := strings.Cut(text[1:], `"`)
+			text = rest
+			if terms := tokenize(phrase); len(terms) > 0 {
+				clauses = append(clauses, clause{terms: terms})
+			}
+			continue
+		}
+
+		end := strings.IndexAny(text, " \t\r\n\"")
+		if end == -1 {
+			end = len(text)
+		}
+		word := text[:end]
+		text = text[end:]
+
+		terms := tokenize(word)
+		if len(terms) == 0 {
+			continue
+		}
+		clauses = append(clauses, clause{
+			terms:  terms,
+			prefix: len(terms) == 1 && strings.HasSuffix(word, "*"),
+		})
+	}
+	return clauses
+}
+
+// searchCursor is the number of hits on the previous pages. It is handed to
+// clients base64 encoded so they treat it as opaque.
+type searchCursor struct {
+	Offset int `json:"offset"`
+}
+
+func encodeCursor(offset int) string {
+	data, _ := json.Marshal(searchCursor{Offset: offset})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ValidationError{message: "invalid cursor"}
+	}
+	parsed := searchCursor{}
+	err = json.Unmarshal(data, &parsed)
+	if err != nil || parsed.Offset <= 0 {
+		return 0, ValidationError{message: "invalid cursor"}
+	}
+	return parsed.Offset, nil
+}
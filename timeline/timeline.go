@@ -0,0 +1,421 @@
+// Package timeline caches home timelines. New chirps are pushed into a
+// bounded inbox of every follower of their author when they are created
+// (fan-out-on-write). Chirps of authors with more followers than the
+// threshold are not pushed, they are merged in when a timeline is read
+// (fan-out-on-read).
+package timeline
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ortin779/chirpy/db"
+	"github.com/ortin779/chirpy/models"
+)
+
+// InboxSize is the number of chirps kept per follower. Pages beyond the
+// oldest cached chirp are read from the store.
+const InboxSize = 800
+
+// DefaultThreshold is the follower count above which chirps are no longer
+// pushed to the followers.
+const DefaultThreshold = 10_000
+
+// MaxInboxes is the number of inboxes kept. The least recently read one is
+// dropped to make room and rebuilt from the store when it is read again.
+const MaxInboxes = 10_000
+
+// MaxFollowerSets is the number of follower sets kept, the least recently
+// used one is dropped to make room.
+const MaxFollowerSets = 10_000
+
+// Store is a db.Store that serves home timelines, GetChirps queries with
+// FollowedBy set, from the inboxes and keeps them up to date on writes.
+// Every other call goes straight to the wrapped store.
+type Store struct {
+	db.Store
+	threshold int
+
+	mx      *sync.Mutex
+	inboxes *lru[int, *inbox]
+	// followers caches the follower sets of authors that have chirped.
+	followers *lru[int, map[int]bool]
+	// pulled holds the authors whose chirps are not pushed while they
+	// have more followers than the threshold, their timeline entries are
+	// read on demand.
+	pulled map[int]bool
+}
+
+// New wraps store. Authors with more than threshold followers are read on
+// demand instead of pushed.
+func New(store db.Store, threshold int) *Store {
+	return &Store{
+		Store:     store,
+		threshold: threshold,
+		mx:        &sync.Mutex{},
+		inboxes:   newLRU[int, *inbox](MaxInboxes),
+		followers: newLRU[int, map[int]bool](MaxFollowerSets),
+		pulled:    make(map[int]bool),
+	}
+}
+
+// inbox holds the newest timeline entries of a follower, newest first.
+type inbox struct {
+	entries []entry
+	// followees are the users the follower followed when the inbox was
+	// built, following or unfollowing someone drops the inbox.
+	followees map[int]bool
+	// truncated is set once older entries may be missing, entries older
+	// than the last one have to be read from the store.
+	truncated bool
+}
+
+type entry struct {
+	id        int
+	createdAt time.Time
+}
+
+func entryOf(chirp models.Chirp) entry {
+	return entry{id: chirp.Id, createdAt: chirp.CreatedAt}
+}
+
+// compareEntries orders entries newest first, like the -created_at sort.
+func compareEntries(a, b entry) int {
+	if c := b.createdAt.Compare(a.createdAt); c != 0 {
+		return c
+	}
+	return b.id - a.id
+}
+
+func (in *inbox) insert(e entry) {
+	idx, found := slices.BinarySearchFunc(in.entries, e, compareEntries)
+	if found {
+		return
+	}
+	if idx == len(in.entries) && in.truncated {
+		// Older than everything cached, it is read from the store.
+		return
+	}
+	in.entries = slices.Insert(in.entries, idx, e)
+	if len(in.entries) > InboxSize {
+		in.entries = in.entries[:InboxSize]
+		in.truncated = true
+	}
+}
+
+func (in *inbox) remove(id int) {
+	in.entries = slices.DeleteFunc(in.entries, func(e entry) bool { return e.id == id })
+}
+
+func (s *Store) CreateChirp(chirp models.Chirp) (models.Chirp, error) {
+	chirp, err := s.Store.CreateChirp(chirp)
+	if err != nil {
+		return models.Chirp{}, err
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	err = s.push(chirp)
+	if err != nil {
+		return models.Chirp{}, err
+	}
+	return chirp, nil
+}
+
+func (s *Store) DeleteChirp(id int, authorId int) (models.Chirp, error) {
+	chirp, err := s.Store.DeleteChirp(id, authorId)
+	if err != nil {
+		return models.Chirp{}, err
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	followers, err := s.followersOf(chirp.AuthorId)
+	if err != nil {
+		return models.Chirp{}, err
+	}
+	for follower := range followers {
+		if in, ok := s.inboxes.peek(follower); ok {
+			in.remove(id)
+		}
+	}
+	return chirp, nil
+}
+
+func (s *Store) RestoreChirp(id int, authorId int, window time.Duration) (models.Chirp, error) {
+	chirp, err := s.Store.RestoreChirp(id, authorId, window)
+	if err != nil {
+		return models.Chirp{}, err
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	err = s.push(chirp)
+	if err != nil {
+		return models.Chirp{}, err
+	}
+	return chirp, nil
+}
+
+func (s *Store) FollowUser(followerId int, followeeId int) (models.Follow, error) {
+	follow, err := s.Store.FollowUser(followerId, followeeId)
+	if err != nil {
+		return models.Follow{}, err
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if followers, ok := s.followers.peek(followeeId); ok {
+		followers[followerId] = true
+	}
+	s.inboxes.remove(followerId)
+	return follow, nil
+}
+
+func (s *Store) UnfollowUser(followerId int, followeeId int) error {
+	err := s.Store.UnfollowUser(followerId, followeeId)
+	if err != nil {
+		return err
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if followers, ok := s.followers.peek(followeeId); ok {
+		delete(followers, followerId)
+	}
+	s.inboxes.remove(followerId)
+	return s.checkPulled(followeeId)
+}
+
+// GetChirps serves home timelines from the cache. Queries with filters the
+// cache does not keep are passed on to the wrapped store.
+func (s *Store) GetChirps(query models.ChirpQuery) (models.ChirpPage, error) {
+	if !isHomeTimeline(query) {
+		return s.Store.GetChirps(query)
+	}
+
+	after, err := db.DecodeCursor(query.Cursor)
+	if err != nil {
+		return models.ChirpPage{}, err
+	}
+
+	s.mx.Lock()
+	in, err := s.inboxOf(query.FollowedBy)
+	if err != nil {
+		s.mx.Unlock()
+		return models.ChirpPage{}, err
+	}
+	candidates := slices.Clone(in.entries)
+	truncated := in.truncated
+	pulled := []int{}
+	for followee := range in.followees {
+		if s.pulled[followee] {
+			pulled = append(pulled, followee)
+		}
+	}
+	s.mx.Unlock()
+
+	if after != nil {
+		start, _ := slices.BinarySearchFunc(candidates, entryOf(*after), compareEntries)
+		for start < len(candidates) && candidates[start].id == after.Id {
+			start++
+		}
+		candidates = candidates[start:]
+	}
+	if truncated && len(candidates) == 0 {
+		// The page starts past the cached entries.
+		return s.Store.GetChirps(query)
+	}
+
+	oldest := entry{}
+	if len(candidates) > 0 {
+		oldest = candidates[len(candidates)-1]
+	}
+	for _, author := range pulled {
+		page, err := s.Store.GetChirps(models.ChirpQuery{
+			AuthorId: author,
+			Sort:     "-created_at",
+			Cursor:   query.Cursor,
+			Limit:    query.Limit + 1,
+		})
+		if err != nil {
+			return models.ChirpPage{}, err
+		}
+		for _, chirp := range page.Chirps {
+			// Beyond the cached entries the store is read anyway.
+			if truncated && compareEntries(entryOf(chirp), oldest) > 0 {
+				break
+			}
+			candidates = append(candidates, entryOf(chirp))
+		}
+	}
+	slices.SortFunc(candidates, compareEntries)
+	candidates = slices.CompactFunc(candidates, func(a, b entry) bool { return a.id == b.id })
+
+	chirps, err := s.resolve(candidates, query.Limit+1)
+	if err != nil {
+		return models.ChirpPage{}, err
+	}
+	if truncated && len(chirps) <= query.Limit {
+		// The page runs past the cached entries.
+		return s.Store.GetChirps(query)
+	}
+
+	page := models.ChirpPage{Chirps: chirps}
+	if len(chirps) > query.Limit {
+		page.Chirps = chirps[:query.Limit]
+		page.NextCursor = db.EncodeCursor(page.Chirps[query.Limit-1])
+	}
+	return page, nil
+}
+
+// isHomeTimeline reports whether query asks for nothing but a page of a
+// home timeline.
+func isHomeTimeline(query models.ChirpQuery) bool {
+	return query.FollowedBy != 0 &&
+		query.Sort == "-created_at" &&
+		query.Limit > 0 &&
+		query.AuthorId == 0 &&
+		query.ReplyTo == 0 &&
+		!query.FlaggedOnly &&
+		query.Tag == "" &&
+		query.Since.IsZero() &&
+		query.Until.IsZero()
+}
+
+// resolve looks up the chirps of the entries in order until n chirps that
+// have not been deleted are found.
+func (s *Store) resolve(entries []entry, n int) ([]models.Chirp, error) {
+	chirps := []models.Chirp{}
+	for len(entries) > 0 && len(chirps) < n {
+		batch := entries[:min(len(entries), n-len(chirps))]
+		entries = entries[len(batch):]
+
+		ids := make([]int, 0, len(batch))
+		for _, e := range batch {
+			ids = append(ids, e.id)
+		}
+		found, err := s.Store.GetChirpsByIds(ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			if chirp, ok := found[id]; ok {
+				chirps = append(chirps, chirp)
+			}
+		}
+	}
+	return chirps, nil
+}
+
+// push adds chirp to the inboxes of the followers of its author, unless the
+// author has too many followers. It is called with the lock held.
+func (s *Store) push(chirp models.Chirp) error {
+	err := s.checkPulled(chirp.AuthorId)
+	if err != nil {
+		return err
+	}
+	if s.pulled[chirp.AuthorId] {
+		return nil
+	}
+	followers, err := s.followersOf(chirp.AuthorId)
+	if err != nil {
+		return err
+	}
+	if len(followers) > s.threshold {
+		s.pulled[chirp.AuthorId] = true
+		return nil
+	}
+
+	for follower := range followers {
+		if in, ok := s.inboxes.peek(follower); ok {
+			in.insert(entryOf(chirp))
+		}
+	}
+	return nil
+}
+
+// checkPulled pushes the chirps of a pulled author again once they are
+// back to no more followers than the threshold. The inboxes of their
+// followers miss the chirps that were not pushed in the meantime, so they
+// are dropped and rebuilt on their next read. It is called with the lock
+// held.
+func (s *Store) checkPulled(authorId int) error {
+	if !s.pulled[authorId] {
+		return nil
+	}
+	followers, err := s.followersOf(authorId)
+	if err != nil {
+		return err
+	}
+	if len(followers) > s.threshold {
+		return nil
+	}
+
+	delete(s.pulled, authorId)
+	for follower := range followers {
+		s.inboxes.remove(follower)
+	}
+	return nil
+}
+
+// followersOf returns the cached follower set of the author, loading it on
+// first use. It is called with the lock held.
+func (s *Store) followersOf(authorId int) (map[int]bool, error) {
+	if followers, ok := s.followers.get(authorId); ok {
+		return followers, nil
+	}
+
+	list, err := s.Store.GetFollowers(authorId)
+	if err != nil {
+		return nil, err
+	}
+	followers := make(map[int]bool, len(list))
+	for _, follower := range list {
+		followers[follower.Id] = true
+	}
+	s.followers.add(authorId, followers)
+	return followers, nil
+}
+
+// inboxOf returns the inbox of the follower, building it from the store on
+// first use. It is called with the lock held, so no chirp can be pushed
+// while the inbox is built.
+func (s *Store) inboxOf(followerId int) (*inbox, error) {
+	if in, ok := s.inboxes.get(followerId); ok {
+		return in, nil
+	}
+
+	following, err := s.Store.GetFollowing(followerId)
+	if err != nil {
+		return nil, err
+	}
+	page, err := s.Store.GetChirps(models.ChirpQuery{
+		FollowedBy: followerId,
+		Sort:       "-created_at",
+		Limit:      InboxSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	in := &inbox{
+		entries:   make([]entry, 0, len(page.Chirps)),
+		followees: make(map[int]bool, len(following)),
+		truncated: page.NextCursor != "",
+	}
+	for _, followee := range following {
+		in.followees[followee.Id] = true
+	}
+	for _, chirp := range page.Chirps {
+		in.entries = append(in.entries, entryOf(chirp))
+	}
+	s.inboxes.add(followerId, in)
+	return in, nil
+}
@@ -0,0 +1,301 @@
+package timeline
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ortin779/chirpy/db"
+	"github.com/ortin779/chirpy/models"
+)
+
+func TestFanOutAndInvalidation(t *testing.T) {
+	store := newTestStore(t, DefaultThreshold, 4)
+	follow(t, store, 2, 1)
+	follow(t, store, 2, 3)
+	older := createChirp(t, store, 4, "before the follow")
+	homeTimeline(t, store, 2)
+
+	// New chirps are pushed into the cached inbox.
+	pushed := createChirp(t, store, 1, "pushed")
+	createChirp(t, store, 2, "own chirp")
+	assertInbox(t, store, 2, pushed.Id)
+
+	// Deleting removes the chirp from the inbox, restoring pushes it again.
+	_, err := store.DeleteChirp(pushed.Id, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInbox(t, store, 2)
+	_, err = store.RestoreChirp(pushed.Id, 1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInbox(t, store, 2, pushed.Id)
+
+	// Following or unfollowing drops the inbox, it is rebuilt with the
+	// chirps of the new followees.
+	follow(t, store, 2, 4)
+	if _, ok := store.inboxes.peek(2); ok {
+		t.Fatal("inbox kept after following another user")
+	}
+	assertHomeTimeline(t, store, 2, pushed.Id, older.Id)
+
+	err = store.UnfollowUser(2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.inboxes.peek(2); ok {
+		t.Fatal("inbox kept after unfollowing a user")
+	}
+	assertHomeTimeline(t, store, 2, older.Id)
+	if _, ok := store.inboxes.peek(1); ok {
+		t.Error("inbox built for a user whose timeline was never read")
+	}
+}
+
+func TestTagQueryBypassesInbox(t *testing.T) {
+	store := newTestStore(t, DefaultThreshold, 2)
+	follow(t, store, 2, 1)
+	tagged := createChirp(t, store, 1, "learning #go")
+	createChirp(t, store, 1, "no tags")
+	homeTimeline(t, store, 2)
+
+	page, err := store.GetChirps(models.ChirpQuery{FollowedBy: 2, Tag: "go", Sort: "-created_at", Limit: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Chirps) != 1 || page.Chirps[0].Id != tagged.Id {
+		t.Errorf("tagged home timeline = %v, want only chirp %d", ids(page.Chirps), tagged.Id)
+	}
+}
+
+func TestAuthorIsPushedAgainBelowThreshold(t *testing.T) {
+	store := newTestStore(t, 1, 3)
+	follow(t, store, 2, 1)
+	follow(t, store, 3, 1)
+	homeTimeline(t, store, 2)
+
+	whilePulled := createChirp(t, store, 1, "two followers")
+	if !store.pulled[1] {
+		t.Fatal("author above the threshold is not pulled")
+	}
+	err := store.UnfollowUser(3, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.pulled[1] {
+		t.Fatal("author back at the threshold is still pulled")
+	}
+
+	pushed := createChirp(t, store, 1, "one follower")
+	got := homeTimeline(t, store, 2)
+	if len(got) != 2 || got[0] != pushed.Id || got[1] != whilePulled.Id {
+		t.Errorf("home timeline = %v, want [%d %d]", got, pushed.Id, whilePulled.Id)
+	}
+}
+
+func TestCachesAreBounded(t *testing.T) {
+	store := newTestStore(t, DefaultThreshold, 4)
+	store.inboxes = newLRU[int, *inbox](2)
+	store.followers = newLRU[int, map[int]bool](2)
+	for follower := 2; follower <= 4; follower++ {
+		follow(t, store, follower, 1)
+		follow(t, store, 1, follower)
+	}
+
+	for user := 1; user <= 4; user++ {
+		homeTimeline(t, store, user)
+		createChirp(t, store, user, "hello")
+	}
+	if store.inboxes.len() != 2 || store.followers.len() != 2 {
+		t.Fatalf("cached %d inboxes and %d follower sets, want 2 of each", store.inboxes.len(), store.followers.len())
+	}
+	if _, ok := store.inboxes.peek(1); ok {
+		t.Error("least recently read inbox was kept")
+	}
+
+	// An evicted inbox is rebuilt with everything pushed meanwhile.
+	got := homeTimeline(t, store, 1)
+	if len(got) != 3 {
+		t.Errorf("rebuilt home timeline = %v, want the chirps of users 2 to 4", got)
+	}
+}
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newLRU[int, string](2)
+	cache.add(1, "one")
+	cache.add(2, "two")
+	cache.get(1)
+	cache.add(3, "three")
+
+	if _, ok := cache.peek(2); ok {
+		t.Error("least recently used entry 2 was kept")
+	}
+	for _, key := range []int{1, 3} {
+		if _, ok := cache.peek(key); !ok {
+			t.Errorf("entry %d was evicted", key)
+		}
+	}
+	cache.remove(1)
+	if cache.len() != 1 {
+		t.Errorf("len() = %d after remove, want 1", cache.len())
+	}
+}
+
+// benchThreshold is low enough for the benchmarks to set up in seconds.
+const benchThreshold = 100
+
+// BenchmarkFanOut measures creating a chirp and reading a home timeline
+// for an author just below, at and just above the threshold, where chirps
+// switch from being pushed to every follower to being read on demand.
+func BenchmarkFanOut(b *testing.B) {
+	for _, followers := range []int{benchThreshold - 1, benchThreshold, benchThreshold + 1} {
+		strategy := "write"
+		if followers > benchThreshold {
+			strategy = "read"
+		}
+		store := newBenchmarkStore(b, followers)
+
+		name := fmt.Sprintf("followers=%d/fan-out-on-%s", followers, strategy)
+		b.Run(name+"/CreateChirp", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, err := store.CreateChirp(models.Chirp{Body: "benchmarking", AuthorId: 1})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(name+"/HomeTimeline", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, err := store.GetChirps(models.ChirpQuery{FollowedBy: 2, Sort: "-created_at", Limit: 20})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// newBenchmarkStore sets up author 1 with the given number of followers,
+// the first of which also follows ten authors with no other followers. Every
+// inbox is built, so pushing a chirp reaches all of them.
+func newBenchmarkStore(b *testing.B, followers int) *Store {
+	b.Helper()
+	const others = 10
+	users := 1 + followers + others
+	store := newTestStore(b, benchThreshold, users)
+
+	for follower := 2; follower <= followers+1; follower++ {
+		follow(b, store, follower, 1)
+	}
+	for other := followers + 2; other <= users; other++ {
+		follow(b, store, 2, other)
+	}
+	for i := 0; i < 20; i++ {
+		createChirp(b, store, 1, "seeding the timelines")
+		for other := followers + 2; other <= users; other++ {
+			createChirp(b, store, other, "seeding the timelines")
+		}
+	}
+	for follower := 2; follower <= followers+1; follower++ {
+		homeTimeline(b, store, follower)
+	}
+	return store
+}
+
+// newTestStore wraps a JSON database holding the given number of users,
+// written directly rather than hashing a password for each.
+func newTestStore(tb testing.TB, threshold int, users int) *Store {
+	tb.Helper()
+	database, err := db.NewDB(filepath.Join(tb.TempDir(), "chirpy.json"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { database.Close() })
+
+	err = database.Update(func(tx *db.Tx) error {
+		now := time.Now().UTC()
+		for id := 1; id <= users; id++ {
+			tx.Users[id] = models.User{Id: id, Email: fmt.Sprintf("user%d@example.com", id), CreatedAt: now, UpdatedAt: now}
+			tx.Touch("users", id)
+		}
+		return nil
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return New(database, threshold)
+}
+
+func follow(tb testing.TB, store *Store, followerId int, followeeId int) {
+	tb.Helper()
+	_, err := store.FollowUser(followerId, followeeId)
+	if err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func createChirp(tb testing.TB, store *Store, authorId int, body string) models.Chirp {
+	tb.Helper()
+	chirp, err := store.CreateChirp(models.Chirp{Body: body, AuthorId: authorId})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return chirp
+}
+
+// homeTimeline reads the first page of the home timeline of the user and
+// returns the ids of its chirps.
+func homeTimeline(tb testing.TB, store *Store, userId int) []int {
+	tb.Helper()
+	page, err := store.GetChirps(models.ChirpQuery{FollowedBy: userId, Sort: "-created_at", Limit: 20})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return ids(page.Chirps)
+}
+
+// assertInbox checks the cached inbox of the user holds exactly the chirps
+// with the given ids, newest first.
+func assertInbox(t *testing.T, store *Store, userId int, want ...int) {
+	t.Helper()
+	in, ok := store.inboxes.peek(userId)
+	if !ok {
+		t.Fatalf("no inbox cached for user %d", userId)
+	}
+	got := []int{}
+	for _, e := range in.entries {
+		got = append(got, e.id)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("inbox of user %d = %v, want %v", userId, got, want)
+	}
+}
+
+// assertHomeTimeline checks the home timeline of the user read through the
+// cache and straight from the store both hold exactly the chirps with the
+// given ids, newest first.
+func assertHomeTimeline(t *testing.T, store *Store, userId int, want ...int) {
+	t.Helper()
+	if got := homeTimeline(t, store, userId); !slices.Equal(got, want) {
+		t.Errorf("cached home timeline of user %d = %v, want %v", userId, got, want)
+	}
+	page, err := store.Store.GetChirps(models.ChirpQuery{FollowedBy: userId, Sort: "-created_at", Limit: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(page.Chirps); !slices.Equal(got, want) {
+		t.Errorf("stored home timeline of user %d = %v, want %v", userId, got, want)
+	}
+}
+
+func ids(chirps []models.Chirp) []int {
+	ids := make([]int, 0, len(chirps))
+	for _, chirp := range chirps {
+		ids = append(ids, chirp.Id)
+	}
+	return ids
+}